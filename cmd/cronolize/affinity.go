@@ -0,0 +1,51 @@
+package main
+
+// A job with cpuset in a config has its processes pinned to the given CPUs,
+// e.g. cpuset: 2-3 or cpuset: 0,4-7, so batch work stays off the cores
+// reserved for latency-sensitive services on the same host. The command
+// inherits the affinity from the start, so do the processes it starts. CPU
+// affinity is only supported on Linux.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxCPUs is the number of CPUs a cpuSet can hold, like the cpu_set_t of
+// glibc.
+const maxCPUs int = 1024
+
+// cpuSet is a CPU affinity mask in the layout of the kernel.
+type cpuSet [maxCPUs / 64]uint64
+
+// parseCPUSet parses a list of CPUs and ranges of CPUs, e.g. 0,2,4-7.
+func parseCPUSet(s string) (*cpuSet, error) {
+	var set cpuSet
+	empty := true
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a list of CPUs such as 0,2-3", s)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return nil, fmt.Errorf("%q is not a list of CPUs such as 0,2-3", s)
+			}
+		}
+		if from < 0 || to >= maxCPUs {
+			return nil, fmt.Errorf("CPU %d is out of range", to)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			set[cpu/64] |= 1 << (uint(cpu) % 64)
+			empty = false
+		}
+	}
+	if empty {
+		return nil, fmt.Errorf("%q is not a list of CPUs such as 0,2-3", s)
+	}
+	return &set, nil
+}
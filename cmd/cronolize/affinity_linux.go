@@ -0,0 +1,17 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const cpuSetSupported = true
+
+func schedAffinity(trap uintptr, set *cpuSet) error {
+	// A pid of 0 is the calling thread.
+	_, _, errno := syscall.RawSyscall(trap, 0, unsafe.Sizeof(*set), uintptr(unsafe.Pointer(set)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+// Besides cron expressions, a job can be scheduled by the sun and the moon as
+// seen from the place given with -location (latitude and longitude in
+// degrees, e.g. 57.7,11.97, or a Maidenhead locator such as JO57xq) or with
+// -lat and -lon:
+//
+//	@solar-elevation>10   when the sun rises above 10 degrees
+//	@solar-elevation<-6   when the sun sets below -6 degrees
+//	@civil-dawn           when the sun rises above -6 degrees (civil-dusk when it sets below)
+//	@nautical-dawn        -12 degrees (nautical-dusk)
+//	@astronomical-dawn    -18 degrees (astronomical-dusk)
+//	@sunrise              when the upper edge of the sun rises above the horizon (sunset when it sets)
+//	@new-moon             at new moon (first-quarter, full-moon, last-quarter)
+//
+// Any of them but @solar-elevation may be followed by an offset, such as
+// @sunset-45m or @sunrise+1h30m for an hour and a half after sunrise.
+//
+// Elevations are geometric, of the center of the sun without refraction
+// (except for sunrise and sunset, which allow for it like almanacs do). The
+// sun's position is accurate to about a hundredth of a degree, which is well
+// within a minute for twilight, the moon's phases to within an hour. A place
+// where the sun never crosses the elevation (within a year) never runs the
+// job.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	locationFlag string = "location"
+	latFlag      string = "lat"
+	lonFlag      string = "lon"
+	// julianJ2000 is the Julian date of 2000-01-01 12:00 UTC.
+	julianJ2000 float64 = 2451545.0
+	// astroSearchLimit is how far ahead a crossing is searched for.
+	astroSearchLimit time.Duration = 366 * 24 * time.Hour
+	// solarSearchStep is the step searching for elevation crossings, short
+	// enough not to miss the sun barely reaching an elevation.
+	solarSearchStep time.Duration = 5 * time.Minute
+	// lunarSearchStep is the step searching for phases, the moon moves
+	// about 3 degrees from the sun in 6 hours.
+	lunarSearchStep time.Duration = 6 * time.Hour
+)
+
+// geoLocation is a place on earth in degrees, north and east positive.
+type geoLocation struct {
+	lat, lon float64
+}
+
+// parseGeoLocation parses LAT,LON or a Maidenhead locator.
+func parseGeoLocation(s string) (*geoLocation, error) {
+	if lat, lon, ok := strings.Cut(s, ","); ok {
+		la, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		lo, err2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if err1 != nil || err2 != nil || math.Abs(la) > 90 || math.Abs(lo) > 180 {
+			return nil, fmt.Errorf("%q is not a latitude and longitude", s)
+		}
+		return &geoLocation{lat: la, lon: lo}, nil
+	}
+	return parseMaidenhead(s)
+}
+
+// parseLatLon parses the latitude and longitude in degrees given with -lat
+// and -lon.
+func parseLatLon(lat, lon string) (*geoLocation, error) {
+	la, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || math.Abs(la) > 90 {
+		return nil, fmt.Errorf("-%s: %q is not a latitude in degrees", latFlag, lat)
+	}
+	lo, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || math.Abs(lo) > 180 {
+		return nil, fmt.Errorf("-%s: %q is not a longitude in degrees", lonFlag, lon)
+	}
+	return &geoLocation{lat: la, lon: lo}, nil
+}
+
+// parseMaidenhead returns the center of a Maidenhead locator of 2 to 8
+// characters, e.g. JO57 or JO57xq.
+func parseMaidenhead(s string) (*geoLocation, error) {
+	invalid := fmt.Errorf("%q is neither LAT,LON nor a Maidenhead locator", s)
+	s = strings.ToUpper(s)
+	if len(s) < 2 || len(s) > 8 || len(s)%2 != 0 {
+		return nil, invalid
+	}
+	// Each pair of characters narrows the field down, the letters of the
+	// subsquare are A-X, the others A-R or digits.
+	lon, lat := -180.0, -90.0
+	width, height := 360.0, 180.0
+	for i := 0; i < len(s); i += 2 {
+		var base, n byte
+		switch {
+		case i == 0:
+			base, n = 'A', 18
+		case i == 4:
+			base, n = 'A', 24
+		default:
+			base, n = '0', 10
+		}
+		x, y := s[i]-base, s[i+1]-base
+		if s[i] < base || s[i+1] < base || x >= n || y >= n {
+			return nil, invalid
+		}
+		width, height = width/float64(n), height/float64(n)
+		lon += float64(x) * width
+		lat += float64(y) * height
+	}
+	return &geoLocation{lat: lat + height/2, lon: lon + width/2}, nil
+}
+
+// daysSinceJ2000 returns the days (and fraction) since J2000.0.
+func daysSinceJ2000(t time.Time) float64 {
+	return float64(t.Unix())/86400 + 2440587.5 - julianJ2000
+}
+
+func sinDeg(x float64) float64 { return math.Sin(x * math.Pi / 180) }
+func cosDeg(x float64) float64 { return math.Cos(x * math.Pi / 180) }
+
+// sunPosition returns the mean anomaly and apparent ecliptic longitude of the
+// sun in degrees.
+func sunPosition(d float64) (anomaly, longitude float64) {
+	anomaly = 357.529 + 0.98560028*d
+	longitude = 280.459 + 0.98564736*d + 1.915*sinDeg(anomaly) + 0.020*sinDeg(2*anomaly)
+	return anomaly, longitude
+}
+
+// solarElevation returns the elevation of the sun at t seen from loc in
+// degrees.
+func solarElevation(t time.Time, loc *geoLocation) float64 {
+	d := daysSinceJ2000(t)
+	_, longitude := sunPosition(d)
+	obliquity := 23.439 - 0.00000036*d
+	ra := math.Atan2(cosDeg(obliquity)*sinDeg(longitude), cosDeg(longitude)) * 180 / math.Pi
+	dec := math.Asin(sinDeg(obliquity)*sinDeg(longitude)) * 180 / math.Pi
+	gmst := 280.46061837 + 360.98564736629*d
+	hourAngle := gmst + loc.lon - ra
+	return math.Asin(sinDeg(loc.lat)*sinDeg(dec)+cosDeg(loc.lat)*cosDeg(dec)*cosDeg(hourAngle)) * 180 / math.Pi
+}
+
+// lunarElongation returns how far the moon is ahead of the sun in ecliptic
+// longitude at t, 0 at new moon and 180 at full moon, using the largest terms
+// of the moon's longitude.
+func lunarElongation(t time.Time) float64 {
+	d := daysSinceJ2000(t)
+	sunAnomaly, sunLongitude := sunPosition(d)
+	l := 218.3165 + 13.17639648*d
+	m := 134.9634 + 13.06499295*d
+	e := 297.8502 + 12.19074912*d
+	f := 93.2721 + 13.22935024*d
+	moonLongitude := l + 6.289*sinDeg(m) + 1.274*sinDeg(2*e-m) + 0.658*sinDeg(2*e) +
+		0.214*sinDeg(2*m) - 0.186*sinDeg(sunAnomaly) - 0.114*sinDeg(2*f)
+	return math.Mod(math.Mod(moonLongitude-sunLongitude, 360)+360, 360)
+}
+
+// nextCrossing returns the first time after t that f goes from negative to
+// zero or positive, to the second, searching in steps of step for up to
+// astroSearchLimit. It returns the zero time if there is none.
+func nextCrossing(t time.Time, step time.Duration, f func(time.Time) float64) time.Time {
+	prev := t.Truncate(time.Second).Add(time.Second)
+	below := f(prev) < 0
+	for end := prev.Add(astroSearchLimit); prev.Before(end); {
+		next := prev.Add(step)
+		if f(next) >= 0 {
+			if below {
+				for next.Sub(prev) > time.Second {
+					mid := prev.Add(next.Sub(prev) / 2).Truncate(time.Second)
+					if f(mid) >= 0 {
+						next = mid
+					} else {
+						prev = mid
+					}
+				}
+				return next.In(t.Location())
+			}
+		} else {
+			below = true
+		}
+		prev = next
+	}
+	return time.Time{}
+}
+
+// elevationSchedule fires when the sun crosses elevation, rising or setting.
+type elevationSchedule struct {
+	location  *geoLocation
+	elevation float64
+	rising    bool
+}
+
+func (s elevationSchedule) Next(t time.Time) time.Time {
+	return nextCrossing(t, solarSearchStep, func(t time.Time) float64 {
+		if s.rising {
+			return solarElevation(t, s.location) - s.elevation
+		}
+		return s.elevation - solarElevation(t, s.location)
+	})
+}
+
+// moonPhaseSchedule fires when the moon's elongation reaches elongation.
+type moonPhaseSchedule struct {
+	elongation float64
+}
+
+func (s moonPhaseSchedule) Next(t time.Time) time.Time {
+	return nextCrossing(t, lunarSearchStep, func(t time.Time) float64 {
+		// Behind the phase is negative, ahead of it positive, the jump
+		// from +180 to -180 half a month later is not a crossing.
+		diff := math.Mod(lunarElongation(t)-s.elongation+540, 360) - 180
+		if diff > 90 {
+			return -1
+		}
+		return diff
+	})
+}
+
+// sunriseElevation is the elevation of the center of the sun at sunrise and
+// sunset, when its upper edge is on the horizon: its radius of 0.266 degrees
+// plus 0.567 degrees of atmospheric refraction.
+const sunriseElevation float64 = -0.833
+
+// twilights are the elevations of the sun at dawn and dusk.
+var twilights = map[string]float64{
+	"civil":        -6,
+	"nautical":     -12,
+	"astronomical": -18,
+}
+
+// moonPhases are the elongations of the moon's phases.
+var moonPhases = map[string]float64{
+	"new-moon":      0,
+	"first-quarter": 90,
+	"full-moon":     180,
+	"last-quarter":  270,
+}
+
+// parseAstroSchedule parses the astronomical schedules, ok is false if spec
+// is not one.
+func parseAstroSchedule(spec string, location *geoLocation) (schedule cron.Schedule, ok bool, err error) {
+	if !strings.HasPrefix(spec, "@") {
+		return nil, false, nil
+	}
+	name := strings.ToLower(spec[1:])
+	var offset string
+	if i := strings.LastIndexAny(name, "+-"); i > 0 && i+1 < len(name) && name[i+1] >= '0' && name[i+1] <= '9' && !strings.HasPrefix(name, "solar-elevation") {
+		name, offset = name[:i], name[i:]
+	}
+	schedule, ok, err = parseAstroEvent(spec, name, location)
+	if !ok || err != nil || len(offset) == 0 {
+		return schedule, ok, err
+	}
+	d, err := time.ParseDuration(offset)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %q is not an offset such as +30m or -1h", spec, offset)
+	}
+	// The offset works like -splay, also before the event.
+	return splaySchedule{schedule: schedule, offset: d}, true, nil
+}
+
+// parseAstroEvent parses the name of an astronomical schedule without an
+// offset.
+func parseAstroEvent(spec, name string, location *geoLocation) (schedule cron.Schedule, ok bool, err error) {
+	if elongation, found := moonPhases[name]; found {
+		return moonPhaseSchedule{elongation: elongation}, true, nil
+	}
+	var sun elevationSchedule
+	if name == "sunrise" || name == "sunset" {
+		sun = elevationSchedule{elevation: sunriseElevation, rising: name == "sunrise"}
+	} else if kind, when, found := strings.Cut(name, "-"); found && (when == "dawn" || when == "dusk") {
+		elevation, known := twilights[kind]
+		if !known {
+			return nil, false, nil
+		}
+		sun = elevationSchedule{elevation: elevation, rising: when == "dawn"}
+	} else if strings.HasPrefix(name, "solar-elevation") {
+		rest := strings.TrimPrefix(name, "solar-elevation")
+		if len(rest) < 2 || (rest[0] != '>' && rest[0] != '<') {
+			return nil, true, fmt.Errorf("%s: expected @solar-elevation>DEGREES or @solar-elevation<DEGREES", spec)
+		}
+		elevation, err := strconv.ParseFloat(rest[1:], 64)
+		if err != nil || math.Abs(elevation) > 90 {
+			return nil, true, fmt.Errorf("%s: %q is not an elevation in degrees", spec, rest[1:])
+		}
+		sun = elevationSchedule{elevation: elevation, rising: rest[0] == '>'}
+	} else {
+		return nil, false, nil
+	}
+	if location == nil {
+		return nil, true, errors.New(spec + " requires -" + locationFlag + " or -" + latFlag + " and -" + lonFlag)
+	}
+	sun.location = location
+	return sun, true, nil
+}
+
+// astroParser parses astronomical schedules for location and standard ones.
+type astroParser struct {
+	location *geoLocation
+}
+
+func (p astroParser) Parse(spec string) (cron.Schedule, error) {
+	// Jobs scheduled @reboot are run at startup, see reboot.go.
+	if isReboot(spec) {
+		return neverSchedule{}, nil
+	}
+	if isRRule(spec) {
+		return parseRRule(spec)
+	}
+	if schedule, ok, err := parseAstroSchedule(spec, p.location); ok {
+		return schedule, err
+	}
+	schedule, err := cronParser().Parse(spec)
+	if err != nil && !withSeconds && len(strings.Fields(spec)) == 6 && !strings.Contains(spec, "TZ=") {
+		return nil, fmt.Errorf("%w (a seconds field requires -%s)", err, secondsFlag)
+	}
+	return schedule, err
+}
@@ -0,0 +1,113 @@
+package main
+
+// With -audit-log, lifecycle events (daemon start and stop, jobs enabled or
+// disabled via the control socket and so on) are appended to a dedicated file
+// as one JSON object per line, recording what happened, when and who caused
+// it, for environments with change-tracking requirements.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	auditStart       string = "start"
+	auditStop        string = "stop"
+	auditEnable      string = "enable"
+	auditDisable     string = "disable"
+	auditSetSchedule string = "set-schedule"
+	auditAdd         string = "add"
+	auditRemove      string = "remove"
+	auditUpgrade     string = "upgrade"
+	auditReload      string = "reload"
+	auditKill        string = "kill"
+	auditRun         string = "run"
+	auditBackfill    string = "backfill"
+	auditRetry       string = "retry-failed"
+	auditFreeze      string = "freeze"
+	auditThaw        string = "thaw"
+	// The maintenance window, see maintenance.go.
+	auditMaintenanceOn  string = "maintenance-on"
+	auditMaintenanceOff string = "maintenance-off"
+)
+
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	PID    int       `json:"pid"`
+	User   string    `json:"user"`
+	Job    string    `json:"job,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// auditLog appends entries to the audit file, a nil *auditLog discards them.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record appends e (with the time and PID filled in) and syncs the file.
+// Errors are logged, not fatal.
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.PID = os.Getpid()
+	if len(e.User) == 0 {
+		e.User = usernameForUID(os.Getuid())
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Audit log: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		log.Printf("Audit log: %v", err)
+		return
+	}
+	if err := a.f.Sync(); err != nil {
+		log.Printf("Audit log: %v", err)
+	}
+}
+
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.f.Close()
+}
+
+// usernameForUID returns the name of user uid, or the uid if it has no name.
+func usernameForUID(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(uid)
+}
+
+// controlPeer describes the client of a control request for the audit log.
+func controlPeer(req controlRequest) string {
+	if req.peerUID < 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s (control socket)", usernameForUID(req.peerUID))
+}
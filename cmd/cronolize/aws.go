@@ -0,0 +1,275 @@
+package main
+
+// Run-completion events can be published to an AWS SNS topic (-sns-topic) or
+// EventBridge bus (-eventbridge-bus) so alerting and automations can react to
+// jobs without polling logs. Requests are signed using Signature Version 4
+// with credentials from the standard environment variables
+// (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optionally AWS_SESSION_TOKEN).
+// The region is taken from the ARN or AWS_REGION (AWS_DEFAULT_REGION) and
+// AWS_ENDPOINT_URL overrides the endpoint, e.g. for LocalStack.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snsTopicFlag       string        = "sns-topic"
+	eventBridgeBusFlag string        = "eventbridge-bus"
+	awsTimeout         time.Duration = 10 * time.Second
+	// awsEventSource is the source of EventBridge events.
+	awsEventSource string = "cronolize"
+	// awsEventDetailType is the detail-type of EventBridge events and the
+	// subject of SNS messages.
+	awsEventDetailType string = "Job run completed"
+)
+
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// awsCredentialsFromEnv returns the credentials in the environment.
+func awsCredentialsFromEnv() (awsCredentials, error) {
+	creds := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if len(creds.accessKeyID) == 0 || len(creds.secretAccessKey) == 0 {
+		return creds, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// awsRegion returns the region of arn, or the region in the environment if
+// arn is not an ARN.
+func awsRegion(arn string) (string, error) {
+	if fields := strings.Split(arn, ":"); len(fields) >= 6 && fields[0] == "arn" && len(fields[3]) > 0 {
+		return fields[3], nil
+	}
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); len(region) > 0 {
+			return region, nil
+		}
+	}
+	return "", errors.New("no region in the ARN and AWS_REGION is not set")
+}
+
+// awsClient sends signed requests to one AWS service in one region.
+type awsClient struct {
+	service  string
+	region   string
+	endpoint string
+	creds    awsCredentials
+	client   *http.Client
+}
+
+func newAWSClient(service, arn string) (*awsClient, error) {
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	region, err := awsRegion(arn)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region)
+	}
+	return &awsClient{
+		service:  service,
+		region:   region,
+		endpoint: endpoint,
+		creds:    creds,
+		client:   &http.Client{Timeout: awsTimeout},
+	}, nil
+}
+
+// post sends body to the service endpoint and returns the response body, or
+// an error unless the response is a 2xx.
+func (a *awsClient) post(contentType string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	a.sign(req, body, time.Now())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s: %s", a.service, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, err
+}
+
+// sign adds a Signature Version 4 Authorization header to req, signing all
+// headers set on it.
+func (a *awsClient) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(a.creds.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", a.creds.sessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := date + "/" + a.region + "/" + a.service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + a.creds.secretAccessKey)
+	for _, part := range []string{date, a.region, a.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// snsNotifier publishes every finished run to an SNS topic.
+type snsNotifier struct {
+	topicARN string
+	aws      *awsClient
+}
+
+func newSNSNotifier(topicARN string) (*snsNotifier, error) {
+	client, err := newAWSClient("sns", topicARN)
+	if err != nil {
+		return nil, fmt.Errorf("-%s: %w", snsTopicFlag, err)
+	}
+	return &snsNotifier{topicARN: topicARN, aws: client}, nil
+}
+
+func (s *snsNotifier) name() string {
+	return "SNS"
+}
+
+func (s *snsNotifier) notify(r runResult) error {
+	message, err := json.Marshal(r.event())
+	if err != nil {
+		return err
+	}
+	status := "succeeded"
+	if r.failed() {
+		status = "failed"
+	}
+	form := url.Values{
+		"Action":                         {"Publish"},
+		"Version":                        {"2010-03-31"},
+		"TopicArn":                       {s.topicARN},
+		"Subject":                        {headerValue(fmt.Sprintf("cronolize job %s %s", r.job.Name, status))},
+		"Message":                        {string(message)},
+		"MessageAttributes.entry.1.Name": {"success"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {fmt.Sprint(!r.failed())},
+	}
+	_, err = s.aws.post("application/x-www-form-urlencoded; charset=utf-8", nil, []byte(form.Encode()))
+	return err
+}
+
+// eventBridgeNotifier puts every finished run on an EventBridge bus.
+type eventBridgeNotifier struct {
+	bus string
+	aws *awsClient
+}
+
+func newEventBridgeNotifier(bus string) (*eventBridgeNotifier, error) {
+	client, err := newAWSClient("events", bus)
+	if err != nil {
+		return nil, fmt.Errorf("-%s: %w", eventBridgeBusFlag, err)
+	}
+	return &eventBridgeNotifier{bus: bus, aws: client}, nil
+}
+
+func (e *eventBridgeNotifier) name() string {
+	return "EventBridge"
+}
+
+func (e *eventBridgeNotifier) notify(r runResult) error {
+	detail, err := json.Marshal(r.event())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"Entries": []map[string]string{{
+			"Source":       awsEventSource,
+			"DetailType":   awsEventDetailType,
+			"Detail":       string(detail),
+			"EventBusName": e.bus,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.aws.post("application/x-amz-json-1.1", map[string]string{"X-Amz-Target": "AWSEvents.PutEvents"}, body)
+	if err != nil {
+		return err
+	}
+	// PutEvents answers 200 even if the entry was rejected.
+	var result struct {
+		FailedEntryCount int
+		Entries          []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+	if result.FailedEntryCount > 0 && len(result.Entries) > 0 {
+		return fmt.Errorf("events: %s: %s", result.Entries[0].ErrorCode, result.Entries[0].ErrorMessage)
+	}
+	return nil
+}
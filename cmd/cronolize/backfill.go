@@ -0,0 +1,120 @@
+package main
+
+// `cronolize backfill -from 2025-01-01 -to 2025-01-07 JOB` runs a job once for
+// every time its schedule would have fired in the range, one run after the
+// other and oldest first, e.g. to reprocess the days missed during an outage.
+// The time each run stands in for is in CRONOLIZE_SCHEDULED_TIME (RFC 3339)
+// and the scheduled time of its summary line, so a job written as
+//
+//	command: report --date "$(date -d "${CRONOLIZE_SCHEDULED_TIME:-now}" +%F)"
+//
+// processes the right day. -from and -to are dates or RFC 3339 times in the
+// time zone of the schedules, a range including from but not to, except that
+// a date as -to includes that whole day. The range must be in the past. A
+// backfill stops when the job is disabled or maintenance mode is turned on.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	backfillCommand string = "backfill"
+	// maxBackfillRuns guards against a range far too long for a frequent
+	// schedule.
+	maxBackfillRuns int = 10000
+	// scheduledTimeEnvVar is the time a backfilled run stands in for.
+	scheduledTimeEnvVar string = "CRONOLIZE_SCHEDULED_TIME"
+)
+
+// parseBackfillTime parses a date or RFC 3339 time in loc, a date as the end
+// of the range is the end of that day.
+func parseBackfillTime(s string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date (YYYY-MM-DD) nor an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// backfillTimes returns the times schedule fires at from from until to.
+func backfillTimes(schedule cron.Schedule, from, to time.Time) ([]time.Time, error) {
+	var times []time.Time
+	// Next is strictly after the time it is given.
+	for t := schedule.Next(from.Add(-time.Nanosecond)); !t.IsZero() && t.Before(to); t = schedule.Next(t) {
+		if len(times) == maxBackfillRuns {
+			return nil, fmt.Errorf("more than %d runs in the range", maxBackfillRuns)
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// backfillRange parses and checks the range of a backfill request.
+func backfillRange(req controlRequest, loc *time.Location, now time.Time) (from, to time.Time, err error) {
+	if from, err = parseBackfillTime(req.From, loc, false); err != nil {
+		return
+	}
+	if to, err = parseBackfillTime(req.To, loc, true); err != nil {
+		return
+	}
+	switch {
+	case !from.Before(to):
+		err = errors.New("the range is empty, -from must be before -to")
+	case to.After(now):
+		err = errors.New("the range must be in the past")
+	}
+	return
+}
+
+// backfillJobCmd implements `cronolize backfill [-pid PID] -from FROM -to TO
+// [-param KEY=VALUE]... JOB`.
+func backfillJobCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(backfillCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	from := cmdFlags.String("from", "", "Start of the range, a date (YYYY-MM-DD) or RFC 3339 time")
+	to := cmdFlags.String("to", "", "End of the range (not included), a date (the whole day is included) or RFC 3339 time")
+	var params stringList
+	cmdFlags.Var(&params, "param", "Add `KEY=VALUE` to the environment of the runs (repeatable)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] -from FROM -to TO [-param KEY=VALUE]... JOB", os.Args[0], backfillCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 1 || len(*from) == 0 || len(*to) == 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	for _, s := range []string{*from, *to} {
+		if _, err := parseBackfillTime(s, time.Local, false); err != nil {
+			fatalf("Syntax error: %v", err)
+		}
+	}
+	for _, param := range params {
+		if err := checkParam(param); err != nil {
+			fatalf("Syntax error: %v", err)
+		}
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	req := controlRequest{Command: backfillCommand, Job: name, From: *from, To: *to, Params: params}
+	if err := sendControl(status.ControlSocket, req); err != nil {
+		fatal(err)
+	}
+}
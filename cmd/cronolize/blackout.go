@@ -0,0 +1,166 @@
+package main
+
+// Blackout windows (-blackout WINDOW, repeatable) suppress the runs of every
+// job falling within them even though the schedule matches, e.g. to keep
+// noisy jobs quiet at night or over the weekend:
+//
+//	-blackout SAT,SUN                 all of Saturday and Sunday
+//	-blackout 22:00-06:00             every night from 22:00 until 06:00
+//	-blackout "MON-FRI 08:00-09:30"   weekday mornings
+//
+// A window is a list of weekdays (or ranges of them), a time range in local
+// time, or both. A time range past midnight belongs to the day it starts on,
+// so "FRI 22:00-06:00" ends on Saturday morning. Suppressed runs are logged
+// and counted as skipped, runs requested using `cronolize run` are not
+// suppressed.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const blackoutFlag string = "blackout"
+
+// timeWindow is a parsed window of -blackout or -business-hours, from and to
+// are the time of day it starts and ends at (both zero for the whole day).
+type timeWindow struct {
+	spec     string
+	weekdays uint64
+	from, to time.Duration
+}
+
+// blackoutWindows is a flag.Value for -blackout.
+type blackoutWindows []timeWindow
+
+func (b *blackoutWindows) String() string {
+	if b == nil {
+		return ""
+	}
+	specs := make([]string, 0, len(*b))
+	for _, w := range *b {
+		specs = append(specs, w.spec)
+	}
+	return strings.Join(specs, ", ")
+}
+
+func (b *blackoutWindows) Set(s string) error {
+	w, err := parseTimeWindow(s)
+	if err != nil {
+		return err
+	}
+	*b = append(*b, w)
+	return nil
+}
+
+// parseTimeWindow parses a window such as SAT,SUN, 22:00-06:00 or
+// MON-FRI 08:00-09:30.
+func parseTimeWindow(spec string) (timeWindow, error) {
+	w := timeWindow{spec: strings.TrimSpace(spec), weekdays: 1<<7 - 1}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid window %q, expected WEEKDAYS, HH:MM-HH:MM or both", spec)
+	}
+	haveWeekdays, haveTime := false, false
+	for _, field := range fields {
+		var err error
+		switch {
+		case strings.Contains(field, ":") && !haveTime:
+			haveTime = true
+			from, to, ok := strings.Cut(field, "-")
+			if !ok {
+				return w, fmt.Errorf("invalid window %q, %q is not a time range", spec, field)
+			}
+			if w.from, err = parseTimeOfDay(from); err != nil {
+				return w, fmt.Errorf("invalid window %q: %v", spec, err)
+			}
+			if w.to, err = parseTimeOfDay(to); err != nil {
+				return w, fmt.Errorf("invalid window %q: %v", spec, err)
+			}
+			if w.from == w.to {
+				return w, fmt.Errorf("invalid window %q, the time range is empty", spec)
+			}
+		case !strings.Contains(field, ":") && !haveWeekdays && !haveTime:
+			haveWeekdays = true
+			if w.weekdays, err = parseCalendarWeekdays(strings.ReplaceAll(field, "-", "..")); err != nil {
+				return w, fmt.Errorf("invalid window %q: %v", spec, err)
+			}
+		default:
+			return w, fmt.Errorf("invalid window %q, unexpected %q", spec, field)
+		}
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses HH:MM into the duration since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day (HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether t is within the window.
+func (w timeWindow) contains(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	onDay := func(d time.Weekday) bool {
+		return w.weekdays&(1<<uint(d)) != 0
+	}
+	switch {
+	case w.from == w.to:
+		return onDay(t.Weekday())
+	case w.from < w.to:
+		return onDay(t.Weekday()) && sinceMidnight >= w.from && sinceMidnight < w.to
+	case sinceMidnight >= w.from:
+		return onDay(t.Weekday())
+	case sinceMidnight < w.to:
+		// The part after midnight belongs to the day before.
+		return onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// end returns when the window ends that started before t, which must be
+// within it.
+func (w timeWindow) end(t time.Time) time.Time {
+	y, m, d := t.Date()
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	switch {
+	case w.from == w.to:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	case w.from > w.to && sinceMidnight >= w.from:
+		// The window ends after midnight.
+		d++
+	}
+	return time.Date(y, m, d, int(w.to/time.Hour), int(w.to%time.Hour/time.Minute), 0, 0, t.Location())
+}
+
+// end returns when the windows t is within end, t if it is not within any,
+// or the zero time if they cover the whole week.
+func (b blackoutWindows) end(t time.Time) time.Time {
+	for limit := t.AddDate(0, 0, 8); t.Before(limit); {
+		within := false
+		for _, w := range b {
+			if w.contains(t) {
+				t = w.end(t)
+				within = true
+			}
+		}
+		if !within {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// check returns an error naming the window t is within, nil if it is not in
+// any.
+func (b blackoutWindows) check(t time.Time) error {
+	for _, w := range b {
+		if w.contains(t) {
+			return fmt.Errorf("in the blackout window %s", w.spec)
+		}
+	}
+	return nil
+}
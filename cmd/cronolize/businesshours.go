@@ -0,0 +1,72 @@
+package main
+
+// With -business-hours WINDOW, every schedule only fires within a window of
+// local time such as "MON-FRI 08:00-18:00" (written like a -blackout window),
+// so "*/10 * * * *" runs every 10 minutes during office hours without
+// spelling them out in the expression. Unlike -blackout, the runs outside the
+// window are not scheduled at all, so -next and the next run shown by
+// `cronolize status` leave them out.
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const businessHoursFlag string = "business-hours"
+
+// businessHoursParser parses the schedules of parser limited to window.
+type businessHoursParser struct {
+	parser cron.ScheduleParser
+	window timeWindow
+}
+
+func (p businessHoursParser) Parse(spec string) (cron.Schedule, error) {
+	schedule, err := p.parser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return businessHoursSchedule{schedule: schedule, window: p.window}, nil
+}
+
+// businessHoursSchedule runs at the times of schedule within window.
+type businessHoursSchedule struct {
+	schedule cron.Schedule
+	window   timeWindow
+}
+
+func (s businessHoursSchedule) Next(t time.Time) time.Time {
+	for {
+		next := s.schedule.Next(t)
+		if next.IsZero() || s.window.contains(next.Local()) {
+			return next
+		}
+		if next.Year() > calendarMaxYear {
+			// The schedule never fires within the window.
+			return time.Time{}
+		}
+		// Continue from where the window opens next instead of going
+		// through every run outside it.
+		opens := s.window.opens(next.Local())
+		if opens.IsZero() {
+			return time.Time{}
+		}
+		t = opens.Add(-time.Nanosecond)
+	}
+}
+
+// opens returns the first time after t the window opens, zero if it never
+// does.
+func (w timeWindow) opens(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, 0, 0, 0, 0, t.Location())
+		if w.weekdays&(1<<uint(day.Weekday())) == 0 {
+			continue
+		}
+		from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, int(w.from/time.Second), 0, day.Location())
+		if from.After(t) {
+			return from
+		}
+	}
+	return time.Time{}
+}
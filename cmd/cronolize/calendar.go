@@ -0,0 +1,339 @@
+package main
+
+// With -calendar, schedules are systemd OnCalendar expressions (see
+// systemd.time(7)) instead of cron expressions, so the timers of systemd can
+// be moved over as they are:
+//
+//	Mon..Fri *-*-* 06:00:00    weekdays at 06:00
+//	*-*-01 00:00               the first of every month
+//	*-02~03                    the third last day of February
+//	Sat,Sun 10:00 Europe/Paris weekends at 10:00 in Paris
+//	*:0/15                     every 15 minutes
+//	daily                      also minutely, hourly, weekly, monthly, yearly,
+//	                           quarterly and semiannually
+//
+// An expression is [WEEKDAYS] [DATE] [TIME] [TIMEZONE]. A date is
+// [YEAR-]MONTH-DAY, where DAY may be ~N for the Nth last day of the month,
+// and a time HOUR:MINUTE[:SECOND]. Every component is *, a value, a range
+// such as 1..5 or a repetition such as 0/15 or 1..10/3, or a comma separated
+// list of them. An omitted date is *-*-*, an omitted time 00:00:00 and an
+// omitted second 00. Descriptors such as @every 10s and the astronomical
+// schedules still work.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const calendarFlag string = "calendar"
+
+const (
+	calendarMinYear int = 1970
+	calendarMaxYear int = 2199
+)
+
+var calendarShorthands = map[string]string{
+	"minutely":     "*-*-* *:*:00",
+	"hourly":       "*-*-* *:00:00",
+	"daily":        "*-*-* 00:00:00",
+	"weekly":       "Mon *-*-* 00:00:00",
+	"monthly":      "*-*-01 00:00:00",
+	"quarterly":    "*-01,04,07,10-01 00:00:00",
+	"semiannually": "*-01,07-01 00:00:00",
+	"yearly":       "*-01-01 00:00:00",
+	"annually":     "*-01-01 00:00:00",
+}
+
+var calendarWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// calendarSchedule is a parsed OnCalendar expression. years is indexed from
+// calendarMinYear, the other sets by value. lastDays are days counted from
+// the end of the month (1 is the last day), a day matches if it is in days or
+// lastDays.
+type calendarSchedule struct {
+	years    []bool
+	months   uint64
+	days     uint64
+	lastDays uint64
+	weekdays uint64
+	hours    uint64
+	minutes  uint64
+	seconds  uint64
+	location *time.Location
+}
+
+func (s calendarSchedule) Next(t time.Time) time.Time {
+	return s.next(t, s.matchDay)
+}
+
+// next returns the first time after t matching the schedule, with matchDay
+// deciding which days do.
+func (s calendarSchedule) next(t time.Time, matchDay func(time.Time) bool) time.Time {
+	if s.location != nil {
+		t = t.In(s.location)
+	}
+	loc := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	for t.Year() <= calendarMaxYear {
+		if t.Year() < calendarMinYear || !s.years[t.Year()-calendarMinYear] {
+			t = time.Date(t.Year()+1, time.January, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second + time.Hour)
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(-time.Duration(t.Second())*time.Second + time.Minute)
+			continue
+		}
+		if s.seconds&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s calendarSchedule) matchDay(t time.Time) bool {
+	if s.weekdays&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+	if s.days&(1<<uint(t.Day())) != 0 {
+		return true
+	}
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return s.lastDays&(1<<uint(lastDay-t.Day()+1)) != 0
+}
+
+// parseCalendarSchedule parses an OnCalendar expression.
+func parseCalendarSchedule(spec string) (cron.Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if full, ok := calendarShorthands[strings.ToLower(expr)]; ok {
+		expr = full
+	}
+	s := calendarSchedule{years: make([]bool, calendarMaxYear-calendarMinYear+1)}
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%q: empty calendar expression", spec)
+	}
+	var err error
+	if last := fields[len(fields)-1]; len(fields) > 1 && isCalendarTimezone(last) {
+		if s.location, err = time.LoadLocation(last); err != nil {
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+		fields = fields[:len(fields)-1]
+	}
+	s.weekdays = 1<<7 - 1
+	if c := fields[0][0]; (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		if s.weekdays, err = parseCalendarWeekdays(fields[0]); err != nil {
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+		fields = fields[1:]
+	}
+	date, clock := "*-*-*", "00:00:00"
+	var haveDate, haveTime bool
+	for _, field := range fields {
+		switch {
+		case strings.Contains(field, ":") && !haveTime:
+			clock, haveTime = field, true
+		case strings.ContainsAny(field, "-~") && !haveDate && !haveTime:
+			date, haveDate = field, true
+		default:
+			return nil, fmt.Errorf("%q: unexpected %q", spec, field)
+		}
+	}
+	if err := s.parseDate(date); err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	if err := s.parseTime(clock); err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	return s, nil
+}
+
+// isCalendarTimezone reports whether the last field of an expression is a
+// timezone rather than a weekday, date or time.
+func isCalendarTimezone(field string) bool {
+	if _, weekday := calendarWeekdays[strings.ToLower(strings.SplitN(field, ",", 2)[0])]; weekday {
+		return false
+	}
+	if strings.Contains(field, "..") {
+		return false
+	}
+	c := field[0]
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parseCalendarWeekdays parses a list of weekdays and ranges of them such as
+// Mon..Fri,Sun.
+func parseCalendarWeekdays(field string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		from, to, isRange := strings.Cut(item, "..")
+		first, ok := calendarWeekdays[strings.ToLower(from)]
+		if !ok {
+			return 0, fmt.Errorf("%q is not a weekday", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = calendarWeekdays[strings.ToLower(to)]; !ok {
+				return 0, fmt.Errorf("%q is not a weekday", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			set |= 1 << uint(d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return set, nil
+}
+
+func (s *calendarSchedule) parseDate(date string) error {
+	parts := strings.Split(date, "-")
+	lastDays := false
+	if i := strings.Index(date, "~"); i >= 0 {
+		parts = append(strings.Split(date[:i], "-"), date[i+1:])
+		lastDays = true
+	}
+	switch len(parts) {
+	case 2:
+		parts = append([]string{"*"}, parts...)
+	case 3:
+	default:
+		return fmt.Errorf("%q is not a date", date)
+	}
+	years, err := parseCalendarComponent(parts[0], "year", calendarMinYear, calendarMaxYear, false)
+	if err != nil {
+		return err
+	}
+	for _, year := range years {
+		s.years[year-calendarMinYear] = true
+	}
+	if s.months, err = calendarSet(parts[1], "month", 1, 12, false); err != nil {
+		return err
+	}
+	if lastDays {
+		s.lastDays, err = calendarSet(parts[2], "day", 1, 31, true)
+	} else {
+		s.days, err = calendarSet(parts[2], "day", 1, 31, false)
+	}
+	return err
+}
+
+func (s *calendarSchedule) parseTime(clock string) error {
+	parts := strings.Split(clock, ":")
+	switch len(parts) {
+	case 2:
+		parts = append(parts, "00")
+	case 3:
+	default:
+		return fmt.Errorf("%q is not a time", clock)
+	}
+	var err error
+	if s.hours, err = calendarSet(parts[0], "hour", 0, 23, false); err != nil {
+		return err
+	}
+	if s.minutes, err = calendarSet(parts[1], "minute", 0, 59, false); err != nil {
+		return err
+	}
+	s.seconds, err = calendarSet(parts[2], "second", 0, 59, false)
+	return err
+}
+
+func calendarSet(component, name string, min, max int, reverse bool) (uint64, error) {
+	values, err := parseCalendarComponent(component, name, min, max, reverse)
+	var set uint64
+	for _, v := range values {
+		set |= 1 << uint(v)
+	}
+	return set, err
+}
+
+// parseCalendarComponent returns the values of a component such as *, 5,
+// 1..5, 0/15 or a list of them. With reverse (days counted from the end of
+// the month), a repetition such as 7/1 counts down to min.
+func parseCalendarComponent(component, name string, min, max int, reverse bool) ([]int, error) {
+	value := func(s string) (int, error) {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return 0, fmt.Errorf("%s %q is not within %d..%d", name, s, min, max)
+		}
+		return v, nil
+	}
+	var values []int
+	for _, item := range strings.Split(component, ",") {
+		item, repetition, repeated := strings.Cut(item, "/")
+		step := 1
+		if repeated {
+			var err error
+			if step, err = strconv.Atoi(repetition); err != nil || step <= 0 {
+				return nil, fmt.Errorf("%q is not a repetition", repetition)
+			}
+		}
+		from, to := min, max
+		if item != "*" {
+			first, last, isRange := strings.Cut(item, "..")
+			var err error
+			if from, err = value(first); err != nil {
+				return nil, err
+			}
+			switch {
+			case isRange:
+				if to, err = value(last); err != nil {
+					return nil, err
+				}
+				if from > to && !reverse {
+					return nil, fmt.Errorf("%q is not a range of %ss", item, name)
+				}
+			case !repeated:
+				to = from
+			case reverse:
+				to = min
+			}
+		}
+		if from > to {
+			step = -step
+		}
+		for v := from; (step > 0 && v <= to) || (step < 0 && v >= to); v += step {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// calendarParser parses OnCalendar expressions, and descriptors (starting
+// with @) and recurrence rules using parser.
+type calendarParser struct {
+	parser cron.ScheduleParser
+}
+
+func (p calendarParser) Parse(spec string) (cron.Schedule, error) {
+	if strings.HasPrefix(strings.TrimSpace(spec), "@") || isRRule(spec) {
+		return p.parser.Parse(spec)
+	}
+	return parseCalendarSchedule(spec)
+}
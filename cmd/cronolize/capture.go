@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultCaptureMemory is the default amount of a run's captured output
+	// kept in memory before spilling to disk.
+	defaultCaptureMemory byteSize = 1024 * 1024
+	// captureTailSize is the size of the ring buffer keeping the tail of
+	// the captured output.
+	captureTailSize int = 64 * 1024
+)
+
+// byteSize is a flag.Value for sizes such as 512, 64K, 10MB or 1GiB (all
+// multiples are powers of 1024).
+type byteSize int64
+
+func (b *byteSize) String() string {
+	if b == nil {
+		return "0"
+	}
+	n := int64(*b)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if n >= unit.size && n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// parseByteSize parses a size such as 512, 64K, 10MB or 1GiB.
+func parseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	multiplier := int64(1)
+	if len(str) > 0 {
+		switch str[len(str)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			str = strings.TrimSpace(str[:len(str)-1])
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// ringBuffer keeps the last size bytes written to it.
+type ringBuffer struct {
+	buf   []byte
+	start int
+	full  bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, 0, size)}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	size := cap(r.buf)
+	if len(p) >= size {
+		r.buf = append(r.buf[:0], p[len(p)-size:]...)
+		r.start = 0
+		r.full = true
+		return n, nil
+	}
+	for len(p) > 0 {
+		if !r.full {
+			free := size - len(r.buf)
+			if len(p) < free {
+				free = len(p)
+			}
+			r.buf = append(r.buf, p[:free]...)
+			p = p[free:]
+			r.full = len(r.buf) == size
+			continue
+		}
+		copied := copy(r.buf[r.start:], p)
+		p = p[copied:]
+		r.start = (r.start + copied) % size
+	}
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered bytes in the order they were written.
+func (r *ringBuffer) Bytes() []byte {
+	out := make([]byte, 0, len(r.buf))
+	if !r.full {
+		return append(out, r.buf...)
+	}
+	out = append(out, r.buf[r.start:]...)
+	return append(out, r.buf[:r.start]...)
+}
+
+// outputCapture captures a run's output keeping at most threshold bytes in
+// memory, anything beyond that is spilled to a temporary file. A checksum and
+// the tail of the output are maintained as output is written. Close removes
+// the spill file.
+type outputCapture struct {
+	mu        sync.Mutex
+	threshold int64
+	mem       bytes.Buffer
+	spill     *os.File
+	size      int64
+	sum       hash.Hash
+	tail      *ringBuffer
+	err       error
+}
+
+func newOutputCapture(threshold int64) *outputCapture {
+	return &outputCapture{
+		threshold: threshold,
+		sum:       sha256.New(),
+		tail:      newRingBuffer(captureTailSize),
+	}
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size += int64(len(p))
+	c.sum.Write(p)
+	c.tail.Write(p)
+	if c.err != nil {
+		return len(p), nil
+	}
+	if c.spill == nil && int64(c.mem.Len()+len(p)) > c.threshold {
+		f, err := os.CreateTemp("", "cronolize-output-*")
+		if err == nil {
+			_, err = f.Write(c.mem.Bytes())
+		}
+		if err != nil {
+			// Keep going with what is in memory, the output is
+			// reported as truncated.
+			c.err = err
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+			return len(p), nil
+		}
+		c.mem.Reset()
+		c.spill = f
+	}
+	if c.spill != nil {
+		if _, err := c.spill.Write(p); err != nil {
+			c.err = err
+		}
+		return len(p), nil
+	}
+	c.mem.Write(p)
+	return len(p), nil
+}
+
+// Size returns the total number of bytes written.
+func (c *outputCapture) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Sum returns the SHA-256 checksum of all output written.
+func (c *outputCapture) Sum() [sha256.Size]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sum [sha256.Size]byte
+	copy(sum[:], c.sum.Sum(nil))
+	return sum
+}
+
+// Tail returns the last (up to captureTailSize) bytes of output.
+func (c *outputCapture) Tail() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tail.Bytes()
+}
+
+// WriteTo writes all captured output to w. If spilling to disk failed, the
+// output captured up to that point is written followed by a note.
+func (c *outputCapture) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	if c.spill != nil {
+		if _, err := c.spill.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		written, err := io.Copy(w, c.spill)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	} else {
+		written, err := w.Write(c.mem.Bytes())
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	if c.err != nil {
+		written, err := fmt.Fprintf(w, "[output truncated: %v]\n", c.err)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *outputCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mem.Reset()
+	if c.spill == nil {
+		return nil
+	}
+	err := c.spill.Close()
+	os.Remove(c.spill.Name())
+	c.spill = nil
+	return err
+}
@@ -0,0 +1,213 @@
+package main
+
+// Preconditions are checked before every run of a job, a run whose
+// preconditions are not met is skipped instead of started. Skipped runs are
+// logged, counted separately from failures in the status and alerted about
+// by the channels alerting about failures (except Sentry), but they are not
+// failures: they neither count towards -escalate nor end a failure streak
+// with a recovery alert. A job with
+//
+//	min_free_disk: 5G /backups
+//
+// in a config is only run if the filesystem of /backups has at least 5G
+// available, so a backup job does not make an outage worse by filling the disk.
+// Other checks are listed in preconditions, each with one of:
+//
+//	preconditions:
+//	  - file_exists: /mnt/backup/.mounted
+//	  - tcp: db.example.com:5432
+//	  - ping: nas.local
+//	  - command: pg_isready -q
+//	  - min_free_disk: 5G /backups
+//
+// Postconditions are checked after every run that exited 0, a run whose
+// postconditions are not met has failed, catching jobs that break silently:
+//
+//	postconditions:
+//	  - file_newer: /backups/latest.tar.gz
+//	  - command: test -s /backups/latest.tar.gz
+//
+// file_newer requires the file to have been modified since the run started,
+// the other checks are the same as for preconditions. Commands are run using
+// the job's shell, as the job's user and with the job's environment, and have
+// to exit 0.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// conditionTimeout is how long tcp and ping checks may take.
+	conditionTimeout time.Duration = 5 * time.Second
+	// conditionCommandTimeout is how long a check command may run.
+	conditionCommandTimeout time.Duration = time.Minute
+)
+
+// condition is an entry of a job's preconditions or postconditions, exactly
+// one field is set.
+type condition struct {
+	FileExists  string `yaml:"file_exists"`
+	FileNewer   string `yaml:"file_newer"`
+	TCP         string `yaml:"tcp"`
+	Ping        string `yaml:"ping"`
+	Command     string `yaml:"command"`
+	MinFreeDisk string `yaml:"min_free_disk"`
+
+	minFreeDisk *diskSpace
+}
+
+// prepare checks that exactly one check is given, post is true for
+// postconditions.
+func (p *condition) prepare(post bool) error {
+	set := 0
+	for _, field := range []string{p.FileExists, p.FileNewer, p.TCP, p.Ping, p.Command, p.MinFreeDisk} {
+		if len(field) > 0 {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of file_exists, file_newer, tcp, ping, command or min_free_disk is required")
+	}
+	if len(p.FileNewer) > 0 && !post {
+		return errors.New("file_newer is only a postcondition")
+	}
+	if len(p.TCP) > 0 {
+		if _, _, err := net.SplitHostPort(p.TCP); err != nil {
+			return fmt.Errorf("tcp: %v", err)
+		}
+	}
+	if len(p.MinFreeDisk) > 0 {
+		d, err := parseMinFreeDisk(p.MinFreeDisk)
+		if err != nil {
+			return fmt.Errorf("min_free_disk: %v", err)
+		}
+		p.minFreeDisk = d
+	}
+	return nil
+}
+
+// check returns why the condition is not met, nil if it is. shell and args
+// are how j's commands are run, started is when the run started.
+func (p *condition) check(j *job, shell string, args []string, started time.Time) error {
+	switch {
+	case len(p.FileExists) > 0:
+		if _, err := os.Stat(p.FileExists); err != nil {
+			return err
+		}
+	case len(p.FileNewer) > 0:
+		fi, err := os.Stat(p.FileNewer)
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().Before(started) {
+			return fmt.Errorf("%s was last modified %s, before the run started", p.FileNewer, fi.ModTime().Format(time.RFC3339))
+		}
+	case len(p.TCP) > 0:
+		conn, err := net.DialTimeout("tcp", p.TCP, conditionTimeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	case len(p.Ping) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), conditionTimeout)
+		defer cancel()
+		if err := exec.CommandContext(ctx, "ping", "-c", "1", p.Ping).Run(); err != nil {
+			return fmt.Errorf("ping %s: %w", p.Ping, err)
+		}
+	case len(p.Command) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), conditionCommandTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, shell, append(append([]string{}, args...), p.Command)...)
+		if j.cred != nil {
+			cmd.Env = append(os.Environ(), j.cred.env()...)
+			if j.cred.cred != nil {
+				cmd.SysProcAttr = &syscall.SysProcAttr{Credential: j.cred.cred}
+			}
+		}
+		if len(j.Env) > 0 {
+			if cmd.Env == nil {
+				cmd.Env = os.Environ()
+			}
+			cmd.Env = append(cmd.Env, j.Env...)
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", p.Command, err)
+		}
+	case p.minFreeDisk != nil:
+		return p.minFreeDisk.check()
+	}
+	return nil
+}
+
+// diskSpace is the min_free_disk condition.
+type diskSpace struct {
+	path string
+	min  int64
+}
+
+// parseMinFreeDisk parses SIZE PATH, e.g. 5GB /backups.
+func parseMinFreeDisk(s string) (*diskSpace, error) {
+	fields := strings.SplitN(strings.TrimSpace(s), " ", 2)
+	if len(fields) != 2 || len(strings.TrimSpace(fields[1])) == 0 {
+		return nil, fmt.Errorf("%q is not SIZE PATH", s)
+	}
+	n, err := parseByteSize(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	return &diskSpace{path: strings.TrimSpace(fields[1]), min: n}, nil
+}
+
+func (d *diskSpace) check() error {
+	free, err := freeDiskSpace(d.path)
+	if err != nil {
+		return err
+	}
+	if free < d.min {
+		return fmt.Errorf("only %s free on %s, %s required", formatBytes(free), d.path, formatBytes(d.min))
+	}
+	return nil
+}
+
+// checkPreconditions returns why the job can not run now, nil if it can. It
+// may block while waiting for the network.
+// shell and args are how the job's commands are run, the command of a command
+// check is appended to args.
+func (j *job) checkPreconditions(shell string, args []string) error {
+	if j.network != nil {
+		if err := j.network.waitFor(j.logger); err != nil {
+			return err
+		}
+	}
+	if j.minFreeDisk != nil {
+		if err := j.minFreeDisk.check(); err != nil {
+			return err
+		}
+	}
+	for i := range j.Preconditions {
+		if err := j.Preconditions[i].check(j, shell, args, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPostconditions returns why a run started at started that exited 0 has
+// failed anyway, nil if it succeeded. shell and args are as for
+// checkPreconditions.
+func (j *job) checkPostconditions(shell string, args []string, started time.Time) error {
+	for i := range j.Postconditions {
+		if err := j.Postconditions[i].check(j, shell, args, started); err != nil {
+			return fmt.Errorf("postcondition not met: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+// Config mode (-config FILE) runs several jobs from a YAML file in a single
+// daemon instead of one cronolize process per job...
+//
+//	log: /var/log/cronolize.log
+//	jobs:
+//	  - name: rotate
+//	    schedule: "@hourly"
+//	    command: logrotate /etc/logrotate.conf
+//	    log: /var/log/rotate.log
+//	    tags: [maintenance, hourly]
+//	    priority: 10
+//	    pushgateway: http://pushgateway:9091
+//	  - name: cleanup
+//	    schedule: "*/15 * * * *"
+//	    command: find /tmp -mtime +1 -delete
+//	    user: nobody
+//	    group: nogroup
+//
+// The top-level log is the default for jobs without a log of their own (the
+// -log option takes precedence). Jobs with a user and/or group run as that
+// user and group (the user's primary group if only user is given, the
+// daemon's user if only group is given), which requires running as root.
+//
+// A schedule changed at runtime using `cronolize set-schedule` is written back
+// to the config file.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configFlag string = "config"
+
+type config struct {
+	Log  string `yaml:"log"`
+	Jobs []*job `yaml:"jobs"`
+
+	// positions are the positions of the jobs in the jobs list of the file
+	// and expanded whether they were expanded from a matrix, see
+	// matrix.go. Without positions a job's position is its index in Jobs.
+	positions []int
+	expanded  []bool
+}
+
+// loadConfig reads and validates a YAML config file. Jobs without a name are
+// named after their position in the jobs list, starting at 1.
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Jobs with a matrix are expanded in the document before decoding
+	// it, which still rejects unknown fields (but the line numbers in
+	// errors are those of the expanded document).
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	positions, expanded, err := expandMatrices(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if positions != nil {
+		if data, err = yaml.Marshal(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	cfg := config{positions: positions, expanded: expanded}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate checks the jobs read from path, naming jobs without a name after
+// their position in the jobs list.
+func (cfg *config) validate(path string) error {
+	if len(cfg.Jobs) == 0 {
+		return fmt.Errorf("%s: no jobs defined", path)
+	}
+	names := make(map[string]bool)
+	for i, j := range cfg.Jobs {
+		if j == nil {
+			return fmt.Errorf("%s: job %d is empty", path, i+1)
+		}
+		j.index = i
+		if cfg.positions != nil {
+			j.index = cfg.positions[i]
+			j.expanded = cfg.expanded[i]
+		}
+		if len(j.Name) == 0 {
+			j.Name = strconv.Itoa(j.index + 1)
+		}
+		if names[j.Name] {
+			return fmt.Errorf("%s: job name %q is not unique", path, j.Name)
+		}
+		names[j.Name] = true
+		j.definition = j.fingerprint()
+		if len(j.Schedule) == 0 && len(j.Watch) == 0 {
+			return fmt.Errorf("%s: job %q has no schedule (or watch)", path, j.Name)
+		}
+		if len(j.Command) == 0 && len(j.Wasm) == 0 {
+			return fmt.Errorf("%s: job %q has no command (or wasm)", path, j.Name)
+		}
+	}
+	return nil
+}
+
+// editConfig lets fn modify the jobs list (a YAML sequence node) of the config
+// file at path and writes the result back. The file is edited as a YAML node
+// tree, so comments and the other jobs are preserved (the indentation is
+// normalized).
+func editConfig(path string, fn func(jobs *yaml.Node) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("%s: no jobs defined", path)
+	}
+	jobs := mappingValue(doc.Content[0], "jobs")
+	if jobs == nil || jobs.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s: no jobs defined", path)
+	}
+	if err := fn(jobs); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// Replace the target of a symlinked config rather than the link.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return writeFileAtomic(path, buf.Bytes(), info.Mode().Perm())
+}
+
+// saveSchedule sets the schedule of the job at index in the jobs list of the
+// config file at path.
+func saveSchedule(path string, index int, schedule string) error {
+	return editConfig(path, func(jobs *yaml.Node) error {
+		if index < 0 || index >= len(jobs.Content) {
+			return fmt.Errorf("job %d not found", index+1)
+		}
+		value := mappingValue(jobs.Content[index], "schedule")
+		if value == nil {
+			return fmt.Errorf("job %d has no schedule", index+1)
+		}
+		// Keep any comments on the value.
+		value.Kind = yaml.ScalarNode
+		value.Tag = "!!str"
+		value.Style = yaml.DoubleQuotedStyle
+		value.Value = schedule
+		return nil
+	})
+}
+
+// saveAddedJob appends j to the jobs list of the config file at path and
+// returns its index.
+func saveAddedJob(path string, j *job) (int, error) {
+	var index int
+	err := editConfig(path, func(jobs *yaml.Node) error {
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		add := func(key string, value *yaml.Node) {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		}
+		add("name", quotedScalar(j.Name))
+		add("schedule", quotedScalar(j.Schedule))
+		add("command", quotedScalar(j.Command))
+		if len(j.Log) > 0 {
+			add("log", quotedScalar(j.Log))
+		}
+		if len(j.Tags) > 0 {
+			tags := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+			for _, tag := range j.Tags {
+				tags.Content = append(tags.Content, quotedScalar(tag))
+			}
+			add("tags", tags)
+		}
+		if len(j.User) > 0 {
+			add("user", quotedScalar(j.User))
+		}
+		if len(j.Group) > 0 {
+			add("group", quotedScalar(j.Group))
+		}
+		index = len(jobs.Content)
+		jobs.Content = append(jobs.Content, node)
+		return nil
+	})
+	return index, err
+}
+
+// saveRemovedJob deletes the job at index from the jobs list of the config
+// file at path.
+func saveRemovedJob(path string, index int) error {
+	return editConfig(path, func(jobs *yaml.Node) error {
+		if index < 0 || index >= len(jobs.Content) {
+			return fmt.Errorf("job %d not found", index+1)
+		}
+		jobs.Content = append(jobs.Content[:index], jobs.Content[index+1:]...)
+		return nil
+	})
+}
+
+// quotedScalar returns a double quoted YAML string node.
+func quotedScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.DoubleQuotedStyle, Value: value}
+}
+
+// mappingValue returns the value of key in the YAML mapping node, nil if node
+// is not a mapping or has no such key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,498 @@
+package main
+
+// The cron process listens on a unix domain socket in the runtime directory
+// (named after its PID, advertised in the status file) for control commands,
+// or on the path given by -control-socket. The protocol is a single JSON
+// request line answered by a single JSON response line, after which the
+// connection is closed. The status command answers with the same structure as
+// the status file, so a daemon can be managed through its socket alone using
+// -socket of the client commands.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	enableCommand      string        = "enable"
+	disableCommand     string        = "disable"
+	setScheduleCommand string        = "set-schedule"
+	addCommand         string        = "add"
+	removeCommand      string        = "remove"
+	controlSocketFlag  string        = "control-socket"
+	socketFlag         string        = "socket"
+	controlSocketExt   string        = ".sock"
+	controlIOTimeout   time.Duration = 10 * time.Second
+	controlMaxRequest  int           = 64 * 1024
+)
+
+type controlRequest struct {
+	Command string `json:"command"`
+	Job     string `json:"job,omitempty"`
+	// Schedule is the argument of the set-schedule command.
+	Schedule string `json:"schedule,omitempty"`
+	// Definition is the job to add, Persist writes an added or removed job
+	// to the config file.
+	Definition *job `json:"definition,omitempty"`
+	Persist    bool `json:"persist,omitempty"`
+	// On and Duration are the arguments of the maintenance command, Duration
+	// is also -since of the retry-failed command.
+	On       bool          `json:"on,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	// RunID and Signal are the arguments of the kill command.
+	RunID  int    `json:"run_id,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	// Params are the KEY=VALUE arguments of the run and backfill commands.
+	Params []string `json:"params,omitempty"`
+	// From and To are the range of the backfill command.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// peerUID is the uid of the client, -1 if unknown.
+	peerUID int
+}
+
+type controlResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Status is the answer to the status command.
+	Status *daemonStatus `json:"status,omitempty"`
+}
+
+type controlHandler func(req controlRequest) error
+
+// controlQuery is the handler of a command answered with the status of the
+// daemon.
+type controlQuery func(req controlRequest) (*daemonStatus, error)
+
+// controlServer accepts control commands on a unix domain socket.
+type controlServer struct {
+	mu       sync.Mutex
+	listener net.Listener
+	path     string
+	handlers map[string]controlHandler
+	queries  map[string]controlQuery
+	// socket is the socket file as created, so it is not removed on close
+	// if another process has replaced it.
+	socket os.FileInfo
+}
+
+// controlSocketOverride is the -socket option of the client commands.
+var controlSocketOverride string
+
+// addControlSocketFlag registers -socket on fs.
+func addControlSocketFlag(fs *flag.FlagSet) {
+	fs.StringVar(&controlSocketOverride, socketFlag, "", "Talk to the daemon listening on this control socket `path` instead of those with a status file in the runtime directory")
+}
+
+func controlSocketPath(pid int) string {
+	return filepath.Join(runtimeDir(), strconv.Itoa(pid)+controlSocketExt)
+}
+
+// newControlServer listens on path, replacing a socket left behind by a
+// previous process with the same PID. Only the owner may connect.
+func newControlServer(path string) (*controlServer, error) {
+	if err := makeRuntimeDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	socket, err := os.Lstat(path)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	// The socket is removed by close, see there.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	return &controlServer{
+		listener: listener,
+		path:     path,
+		handlers: make(map[string]controlHandler),
+		queries:  make(map[string]controlQuery),
+		socket:   socket,
+	}, nil
+}
+
+// controlSocketInUse reports whether a process is listening on path.
+func controlSocketInUse(path string) bool {
+	conn, err := net.DialTimeout("unix", path, controlIOTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// handle registers fn as the handler of command.
+func (s *controlServer) handle(command string, fn controlHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = fn
+}
+
+// query registers fn as the handler of command answered with a status.
+func (s *controlServer) query(command string, fn controlQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[command] = fn
+}
+
+// serve accepts connections until the listener is closed.
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Control socket: %v", err)
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *controlServer) serveConn(conn net.Conn) {
+	defer reportPanic()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlIOTimeout))
+	var resp controlResponse
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), controlMaxRequest)
+	if scanner.Scan() {
+		req := controlRequest{peerUID: peerUID(conn)}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			s.mu.Lock()
+			fn, ok := s.handlers[req.Command]
+			query, isQuery := s.queries[req.Command]
+			s.mu.Unlock()
+			switch {
+			case isQuery:
+				status, err := query(req)
+				if err != nil {
+					resp.Error = err.Error()
+				} else {
+					resp.OK, resp.Status = true, status
+				}
+			case !ok:
+				resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+			default:
+				if err := fn(req); err != nil {
+					resp.Error = err.Error()
+				} else {
+					resp.OK = true
+				}
+			}
+		}
+	} else {
+		resp.Error = "no request"
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (s *controlServer) close() {
+	s.listener.Close()
+	// After an upgrade, the new process listens on a socket of its own at
+	// the same path.
+	if info, err := os.Lstat(s.path); err == nil && os.SameFile(info, s.socket) {
+		os.Remove(s.path)
+	}
+}
+
+// sendControl sends req to the control socket at path and returns the error
+// reported by the daemon, if any.
+func sendControl(path string, req controlRequest) error {
+	_, err := roundTripControl(path, req)
+	return err
+}
+
+// queryStatus returns the status of the daemon listening on path.
+func queryStatus(path string) (daemonStatus, error) {
+	resp, err := roundTripControl(path, controlRequest{Command: statusCommand})
+	if err != nil {
+		return daemonStatus{}, err
+	}
+	if resp.Status == nil {
+		return daemonStatus{}, fmt.Errorf("%s: no status in the response", path)
+	}
+	return *resp.Status, nil
+}
+
+// roundTripControl sends req to the control socket at path and returns the
+// response, or the error reported by the daemon.
+func roundTripControl(path string, req controlRequest) (controlResponse, error) {
+	var resp controlResponse
+	conn, err := net.DialTimeout("unix", path, controlIOTimeout)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlIOTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// findJobDaemon returns the status of the daemon running a job named name. If
+// several daemons have a job with that name, pid must be given to select one.
+func findJobDaemon(name string, pid int) (daemonStatus, error) {
+	statuses, err := readStatuses()
+	if err != nil {
+		return daemonStatus{}, err
+	}
+	var found []daemonStatus
+	for _, status := range statuses {
+		if pid != 0 && status.PID != pid {
+			continue
+		}
+		for _, js := range status.Jobs {
+			if js.Name == name {
+				found = append(found, status)
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return daemonStatus{}, fmt.Errorf("no running job named %q", name)
+	case 1:
+		if len(found[0].ControlSocket) == 0 {
+			return daemonStatus{}, fmt.Errorf("process %d has no control socket", found[0].PID)
+		}
+		return found[0], nil
+	default:
+		return daemonStatus{}, fmt.Errorf("several processes run a job named %q, select one using -pid", name)
+	}
+}
+
+// findDaemon returns the status of the daemon with pid, or the only daemon
+// running if pid is 0.
+func findDaemon(pid int) (daemonStatus, error) {
+	statuses, err := readStatuses()
+	if err != nil {
+		return daemonStatus{}, err
+	}
+	var found []daemonStatus
+	for _, status := range statuses {
+		if pid == 0 || status.PID == pid {
+			found = append(found, status)
+		}
+	}
+	switch {
+	case len(found) == 0 && pid != 0:
+		return daemonStatus{}, fmt.Errorf("no cronolize process with PID %d", pid)
+	case len(found) == 0:
+		return daemonStatus{}, errors.New("no cronolize processes are running")
+	case len(found) > 1:
+		return daemonStatus{}, errors.New("several cronolize processes are running, select one using -pid")
+	case len(found[0].ControlSocket) == 0:
+		return daemonStatus{}, fmt.Errorf("process %d has no control socket", found[0].PID)
+	}
+	return found[0], nil
+}
+
+// jobControlCmd implements `cronolize <command> [-pid PID] JOB|-tag tag...`
+// for commands operating on a job or the jobs with tags, enable and disable.
+func jobControlCmd(command string, args []string) {
+	cmdFlags := flag.NewFlagSet(command, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	var tags stringList
+	cmdFlags.Var(&tags, "tag", "Instead of JOB, "+command+" every job with this `tag` (repeatable, jobs must have all tags)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] JOB|-tag tag...", os.Args[0], command)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if (len(tags) > 0) == (cmdFlags.NArg() == 1) || cmdFlags.NArg() > 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	if len(tags) > 0 {
+		done := "Enabled"
+		if command == disableCommand {
+			done = "Disabled"
+		}
+		controlTagged(controlRequest{Command: command}, tags, *pid, done)
+		return
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	if err := sendControl(status.ControlSocket, controlRequest{Command: command, Job: name}); err != nil {
+		fatal(err)
+	}
+}
+
+// controlTagged sends req for every job tagged with all of tags, of every
+// cronolize process or only the one with pid if not 0, printing done with the
+// job for each. It exits with an error if there are no such jobs or any
+// request failed.
+func controlTagged(req controlRequest, tags []string, pid int, done string) {
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	found, failed := false, false
+	for _, status := range filterByTags(statuses, tags) {
+		if pid != 0 && status.PID != pid {
+			continue
+		}
+		found = true
+		if len(status.ControlSocket) == 0 {
+			pe("%s process %d has no control socket", colorize("Error:", ansiBold, ansiRed), status.PID)
+			failed = true
+			continue
+		}
+		for _, js := range status.Jobs {
+			req.Job = js.Name
+			if err := sendControl(status.ControlSocket, req); err != nil {
+				pe("%s job %q of process %d: %v", colorize("Error:", ansiBold, ansiRed), js.Name, status.PID, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%s job %q of process %d\n", done, js.Name, status.PID)
+		}
+	}
+	if !found {
+		fatalf("Error: no running jobs tagged %s", strings.Join(tags, ", "))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// setScheduleCmd implements `cronolize set-schedule [-pid PID] JOB SCHEDULE`.
+func setScheduleCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(setScheduleCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] JOB SCHEDULE", os.Args[0], setScheduleCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 2 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	req := controlRequest{Command: setScheduleCommand, Job: name, Schedule: cmdFlags.Arg(1)}
+	if err := sendControl(status.ControlSocket, req); err != nil {
+		fatal(err)
+	}
+}
+
+// addJobCmd implements `cronolize add [-pid PID] [-persist] [options]
+// cronSpec command` adding a job to a running daemon.
+func addJobCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(addCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process to add the job to (if several are running)")
+	persist := cmdFlags.Bool("persist", false, "Also add the job to the config file of the process")
+	name := cmdFlags.String("name", "", "Name of the job (default the next free number)")
+	logfile := cmdFlags.String("log", "", "Log output of the job to this `file`")
+	var tags stringList
+	cmdFlags.Var(&tags, "tag", "Tag the job with this `tag` (repeatable)")
+	username := cmdFlags.String("user", "", "Run the job as this `user` (requires the process to run as root)")
+	group := cmdFlags.String("group", "", "Run the job as this `group` (requires the process to run as root)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] [-persist] [options] cronSpec command", os.Args[0], addCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 2 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	status, err := findDaemon(*pid)
+	if err != nil {
+		fatal(err)
+	}
+	// The process may be running in another directory.
+	if len(*logfile) > 0 {
+		if *logfile, err = filepath.Abs(*logfile); err != nil {
+			fatal(err)
+		}
+	}
+	req := controlRequest{
+		Command: addCommand,
+		Definition: &job{
+			Name:     *name,
+			Schedule: cmdFlags.Arg(0),
+			Command:  cmdFlags.Arg(1),
+			Log:      *logfile,
+			Tags:     tags,
+			User:     *username,
+			Group:    *group,
+		},
+		Persist: *persist,
+	}
+	if err := sendControl(status.ControlSocket, req); err != nil {
+		fatal(err)
+	}
+}
+
+// removeJobCmd implements `cronolize remove [-pid PID] [-persist] JOB`.
+func removeJobCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(removeCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	persist := cmdFlags.Bool("persist", false, "Also remove the job from the config file of the process")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] [-persist] JOB", os.Args[0], removeCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	if err := sendControl(status.ControlSocket, controlRequest{Command: removeCommand, Job: name, Persist: *persist}); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+// Jobs can be run as another user (the user column of a system crontab), the
+// privileges are dropped in the child process only so a daemon running as
+// root can host jobs of several users.
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// credential is who a job's process runs as, cred is nil if that is who the
+// daemon already runs as.
+type credential struct {
+	username string
+	home     string
+	cred     *syscall.Credential
+}
+
+// lookupCredential resolves username (a name or numeric uid) and group (a
+// name or numeric gid, the user's primary group if empty) into process
+// credentials including the user's supplementary groups. Running as anyone
+// but the current user requires root.
+func lookupCredential(username, group string) (*credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if u, err = user.LookupId(username); err != nil {
+			return nil, fmt.Errorf("unknown user %q", username)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: uid %q is not numeric", username, u.Uid)
+	}
+	gidString := u.Gid
+	if len(group) > 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("unknown group %q", group)
+			}
+		}
+		gidString = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidString, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q: gid %q is not numeric", username, gidString)
+	}
+	var groups []uint32
+	if groupIds, err := u.GroupIds(); err == nil {
+		for _, id := range groupIds {
+			if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(n))
+			}
+		}
+	}
+	c := &credential{
+		username: u.Username,
+		home:     u.HomeDir,
+	}
+	if os.Geteuid() != 0 {
+		if int(uid) != os.Geteuid() || int(gid) != os.Getegid() {
+			return nil, fmt.Errorf("running jobs as user %q requires root", username)
+		}
+		// Already running as the user, there is nothing to drop.
+		return c, nil
+	}
+	c.cred = &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}
+	return c, nil
+}
+
+// env returns the login variables cron sets for the user.
+func (c *credential) env() []string {
+	return []string{
+		"HOME=" + c.home,
+		"USER=" + c.username,
+		"LOGNAME=" + c.username,
+	}
+}
@@ -16,16 +16,21 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"log"
-	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
 )
 
 var (
@@ -33,35 +38,45 @@ var (
 )
 
 const (
+	// cronolizerEnvVar is set to envVarValueExpected in the environment of
+	// the background cron process by cronolizer.Daemonize.
 	cronolizerEnvVar    string = "__CRONOLIZER__"
 	envVarValueExpected string = "INSTANTIATED"
 	logFlag             string = "log"
 	foregroundFlag      string = "fg"
+	nowFlag             string = "now"
+	maxRunsFlag         string = "max-runs"
+	defaultShell        string = "/bin/sh"
 	helpMsg             string = `
-cronSpec is a five field CRON expression. See below or refer to
+cronSpec is a five field CRON expression (six with -seconds). See below or refer to
 https://pkg.go.dev/github.com/robfig/cron/v3 for details.
 
-command is the command string to execute via /bin/sh -c (by default). See -h
-for more information.
+command is the command string to execute via $SHELL -c (by default, /bin/sh if
+SHELL is not set). See -h for more information.
 
 Examples:
-cronolize -log /var/log/minute.log "* * * * *" 'date ; echo Hello world'
+` + examplesMsg + `
+Cron format:
+` + cronFormatMsg + `
+Predefined schedules:
+` + predefinedMsg + `
+` + daemonMsg
+	examplesMsg string = `cronolize -log /var/log/minute.log "* * * * *" 'date ; echo Hello world'
 cronolize -shell /bin/bash "@hourly" 'echo "Last run on $(date)" > /var/opt/output'
 cronolize -log out.log "CRON_TZ=Europe/Stockholm 37 13 * * *" 'touch /var/opt/touchable ; echo "Touched file at $(date)"'
 cronolize -log /var/logs/nightlyRestart.log "@daily" "echo \"Restarting myservice\" ; supervisorctl restart myservice"
-
-Cron format:
-
+`
+	cronFormatMsg string = `
 Field name   | Mandatory? | Allowed values  | Allowed special characters
 ----------   | ---------- | --------------  | --------------------------
+Seconds      | -seconds   | 0-59            | * / , -
 Minutes      | Yes        | 0-59            | * / , -
 Hours        | Yes        | 0-23            | * / , -
 Day of month | Yes        | 1-31            | * / , - ?
 Month        | Yes        | 1-12 or JAN-DEC | * / , -
 Day of week  | Yes        | 0-6 or SUN-SAT  | * / , - ?
-
-Predefined schedules:
-
+`
+	predefinedMsg string = `
 Entry                  | Description                                | Equivalent To
 -----                  | -----------                                | -------------
 @yearly (or @annually) | Run once a year, midnight, Jan. 1st        | 0 0 1 1 *
@@ -69,20 +84,23 @@ Entry                  | Description                                | Equivalent
 @weekly                | Run once a week, midnight between Sat/Sun  | 0 0 * * 0
 @daily (or @midnight)  | Run once a day, midnight                   | 0 0 * * *
 @hourly                | Run once an hour, beginning of hour        | 0 * * * *
-
-The parent process will start a copy of itself in the background and exit while
+@reboot                | Run once when cronolize starts             | -
+`
+	daemonMsg string = `The parent process will start a copy of itself in the background and exit while
 the copy (child process) will run cron (unless the -fg option is issued) and
-block indefinitely until killed.
+block indefinitely until killed. The arguments are passed to the copy over a
+pipe so ps does not show them (only the commands of jobs while they run).
 `
 )
 
 // fatal() sends a message to stderr prepended with "Error:" and terminates with
 // exit code 1 (fatalf() does not prepend any text, works like log.Fatalf).
 func fatal(a ...any) {
-	prepend := "Error:"
+	prepend := colorize("Error:", ansiBold, ansiRed)
 	// any or interface{} is the question...
 	a = append([]interface{}{prepend}, a...)
 	fmt.Fprintln(os.Stderr, a...)
+	runAtExit()
 	os.Exit(1)
 }
 
@@ -91,10 +109,23 @@ func fatalf(format string, a ...any) {
 		fatal(format)
 	} else {
 		fmt.Fprintln(os.Stderr, fmt.Sprintf(format, a...))
+		runAtExit()
 		os.Exit(1)
 	}
 }
 
+// fatalLog is like fatal() but writes to the standard logger, used by the
+// cron process where stderr is not the log file.
+func fatalLog(a ...any) {
+	a = append([]interface{}{colorize("Error:", ansiBold, ansiRed)}, a...)
+	log.Println(a...)
+	atExitMu.Lock()
+	exitReason = fmt.Sprint(a[1:]...)
+	atExitMu.Unlock()
+	runAtExit()
+	os.Exit(1)
+}
+
 func p(format string, a ...any) {
 	if len(a) < 1 {
 		fmt.Println(format)
@@ -111,6 +142,83 @@ func pe(format string, a ...any) {
 	}
 }
 
+// quietLevel is a flag.Value for -q. Used as a boolean flag (-q) it sets
+// quietRuns for backward compatibility, -q=N sets the level explicitly. The
+// levels are cumulative and apply the same way in the foreground as in the
+// background.
+type quietLevel int
+
+const (
+	quietNone quietLevel = iota // Print everything
+	quietPID                    // Don't print the PID message
+	quietRuns                   // ...nor the per-run "Running:" log entry
+	quietAll                    // ...nor job output on stdout, only failures
+)
+
+func (q *quietLevel) String() string {
+	if q == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*q))
+}
+
+func (q *quietLevel) Set(s string) error {
+	if s == "true" {
+		*q = quietRuns
+		return nil
+	}
+	if s == "false" {
+		*q = quietNone
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < int(quietNone) || n > int(quietAll) {
+		return fmt.Errorf("quiet level must be between %d and %d", quietNone, quietAll)
+	}
+	*q = quietLevel(n)
+	return nil
+}
+
+func (q *quietLevel) IsBoolFlag() bool {
+	return true
+}
+
+// usage prints the welcome message and syntax help to stderr and exits.
+func usage() {
+	pe("Welcome to cronolize %s (C) 2022 SA6MWA https://github.com/sa6mwa/cronolizer", version)
+	pe("")
+	pe("Syntax: %s [options] cronSpec command", os.Args[0])
+	pe("        %s [options] -%s file", os.Args[0], configFlag)
+	pe("        %s [options] -%s|-%s file", os.Args[0], crontabFlag, systemCrontabFlag)
+	pe("        %s [options] -%s command", os.Args[0], onStartFlag)
+	pe("        %s start [options] cronSpec command", os.Args[0])
+	pe("        %s stop [-statedir directory] [-all] [-wait DURATION] [PID...]", os.Args[0])
+	pe("        %s list [-json] [-tag tag] [-statedir directory]", os.Args[0])
+	pe("        %s status [-json] [-tag tag] [-recent N] [-statedir directory]", os.Args[0])
+	pe("        %s running [-json] [-statedir directory]", os.Args[0])
+	pe("        %s ps [-json] [-statedir directory]", os.Args[0])
+	pe("        %s run [-pid PID] [-statedir directory] [-param KEY=VALUE]... JOB|-tag tag...", os.Args[0])
+	pe("        %s backfill [-pid PID] [-statedir directory] -from FROM -to TO [-param KEY=VALUE]... JOB", os.Args[0])
+	pe("        %s retry-failed [-pid PID] [-statedir directory] [-since DURATION] [JOB]", os.Args[0])
+	pe("        %s kill [-pid PID] [-statedir directory] [-run-id ID] [-signal SIGNAL] JOB", os.Args[0])
+	pe("        %s freeze|thaw [-pid PID] [-statedir directory] [-run-id ID] JOB", os.Args[0])
+	pe("        %s enable|disable [-pid PID] [-statedir directory] JOB|-tag tag...", os.Args[0])
+	pe("        %s set-schedule [-pid PID] [-statedir directory] JOB SCHEDULE", os.Args[0])
+	pe("        %s add [-pid PID] [-statedir directory] [-persist] [-name NAME] [-log file] [-tag tag] [-user user] [-group group] cronSpec command", os.Args[0])
+	pe("        %s remove [-pid PID] [-statedir directory] [-persist] JOB", os.Args[0])
+	pe("        %s maintenance [-pid PID] [-statedir directory] on [DURATION] | off", os.Args[0])
+	pe("        %s upgrade [-pid PID] [-statedir directory]", os.Args[0])
+	pe("        %s wasm [-mount HOST[:GUEST][:ro]]... file [args...]", os.Args[0])
+	pe("        %s doctor [-statedir directory] [-shell shell] [-config file|-crontab file]", os.Args[0])
+	pe("        %s describe [-json]", os.Args[0])
+	pe("        %s explain [-seconds] cronSpec", os.Args[0])
+	pe("        %s man", os.Args[0])
+	pe("")
+	flag.Usage()
+	pe(helpMsg)
+	os.Exit(1)
+}
+
 func main() {
 	var isCronProcess bool
 
@@ -123,24 +231,246 @@ func main() {
 	if hasEnvVar {
 		os.Unsetenv(cronolizerEnvVar)
 	}
+	if isCronProcess {
+		if err := cronolizer.ReadBackgroundArgs(); err != nil {
+			fatal(err)
+		}
+	}
 
-	logfile := flag.String(logFlag, os.DevNull, "Log output from stdout and stderr to this file")
-	shell := flag.String("shell", "/bin/sh", "Full path to shell used to execute command")
+	logfile := flag.String(logFlag, os.DevNull, "Log output from stdout and stderr to this file, strftime conversions such as %Y-%m-%d start a new file when they change")
+	shell := flag.String("shell", "", "Full path to shell used to execute command (default $SHELL or "+defaultShell+")")
 	shellCommandOption := flag.String("shellCommandOption", "-c", "Command option used by the shell, usually -c")
 	truncateLog := flag.Bool("truncate", false, "Truncate instead of appending to the log file")
-	quiet := flag.Bool("q", false, "Quiet, don't print the PID message at the end or the log entry in the log file")
+	utcLogs := flag.Bool("utc", false, "Log timestamps (and the times of summary lines) in UTC instead of the local time zone")
+	logTimeLayout := flag.String(logTimeFormatFlag, "", "Log timestamps (and the times of summary lines) as rfc3339, rfc3339nano, epoch or in this Go time `layout`")
+	var logMaxSize byteSize
+	flag.Var(&logMaxSize, logMaxSizeFlag, "Rotate log files when they would grow larger than this `size`, renaming them with an index (0 never rotates)")
+	logMaxFiles := flag.Int(logMaxFilesFlag, defaultLogMaxFiles, "Number of rotated log files kept by -log-max-size, the oldest are removed")
+	logCompress := flag.Bool(logCompressFlag, false, "Gzip log files rotated by -log-max-size or left behind by a strftime pattern in their path")
+	logBuffer := flag.Duration("log-buffer", 0, "Buffer writes to log files in memory and flush them asynchronously at this interval, e.g. 1s (0 writes directly)")
+	var quiet quietLevel
+	flag.Var(&quiet, "q", "Quiet level, -q=1 don't print the PID message at the end, -q or -q=2 also don't log each run, -q=3 only output failures")
 	foreground := flag.Bool(foregroundFlag, false, "Run cron in the foreground instead of as a background daemon process")
+	configFile := flag.String(configFlag, "", "Run all jobs in this YAML file instead of a single cronSpec and command")
+	crontabFile := flag.String(crontabFlag, "", "Run all jobs in this crontab file instead of a single cronSpec and command")
+	systemCrontabFile := flag.String(systemCrontabFlag, "", "Run all jobs in this system crontab file (with a user column, like /etc/crontab) instead of a single cronSpec and command")
+	collapseRepeats := flag.Bool("collapse-repeats", false, "Collapse identical consecutive lines in log files into \"last message repeated N times\"")
+	sampleInterval := flag.Duration("sample-interval", defaultSampleInterval, "Sample the CPU and memory usage of running jobs at this interval (0 disables sampling and -max-memory)")
+	var maxMemory byteSize
+	flag.Var(&maxMemory, "max-memory", "Kill a run when the processes of the job use more than this `size` of memory (0 is unlimited)")
+	nextRuns := flag.Int(nextFlag, 0, "Print the next `N` times the jobs are scheduled at and exit instead of starting")
+	quartz := flag.Bool(quartzFlag, false, "Schedules are Quartz expressions with seconds, optionally a year and L, W and # for the days, such as \"0 0 18 ? * 6L\" (the last Friday of the month at 18:00)")
+	calendar := flag.Bool(calendarFlag, false, "Schedules are systemd OnCalendar expressions such as \"Mon..Fri *-*-* 06:00:00\" instead of cron expressions")
+	flag.BoolVar(&withSeconds, secondsFlag, false, "Allow a seconds field before the minutes, so six field expressions such as \"*/10 * * * * *\" run every 10 seconds")
+	jitter := flag.Duration(jitterFlag, 0, "Wait a random time up to this long before every scheduled run, so hosts running the same schedule don't all start at once, e.g. 5m")
+	overlap := flag.String(overlapFlag, string(cronolizer.OverlapAllow), "What to do when a job fires while a run of it has not finished: allow another run, skip the new run, queue it until the previous run has finished, or kill the previous run")
+	retries := flag.Int(retriesFlag, 0, "Run a failed run again up to this many times before it counts as failed")
+	retryBackoff := flag.Duration(retryBackoffFlag, defaultRetryBackoff, "How long to wait before the first retry of a failed run, doubled for every retry")
+	jobTimeout := flag.Duration(timeoutFlag, 0, "Kill a run (its command and everything it started) taking longer than this, e.g. 2h (0 is no limit)")
+	captureMemory := defaultCaptureMemory
+	flag.Var(&captureMemory, "capture-memory", "Maximum `size` of a run's captured output kept in memory, the rest is spilled to a temporary file")
+	tagOutput := flag.Bool(tagOutputFlag, false, "Prefix every line of output in logs with [out] or [err] for the stream it was written to, keeping lines whole")
+	suppressUnchanged := flag.Bool("suppress-unchanged", false, "Don't log the output of a run if it is identical to the previous run's output, only the number of unchanged runs")
+	serializePriorities := flag.Bool("serialize-priorities", false, "When several jobs fire at the same time, run each priority level to completion before starting the next")
+	workers := flag.Int("workers", 0, "Run jobs using a pool of this many workers (0 starts every run immediately)")
+	fairQueue := flag.Bool(fairFlag, false, "With -workers, give queued runs to workers round-robin per job (the job that got a worker least recently first) instead of in queue order, so frequently firing jobs can't starve the others")
+	queueLimit := flag.Int("queue-limit", 0, "Maximum number of runs waiting for a worker, runs beyond this are dropped (0 is unlimited)")
+	dryRun := flag.Bool("dry-run", false, "Schedule as usual, but only log the command that would have been run instead of executing it")
+	location := flag.String(locationFlag, "", "Where the sun and moon are seen from for astronomical schedules such as @civil-dusk, as `LAT,LON` in degrees or a Maidenhead locator")
+	latitude := flag.String(latFlag, "", "Latitude in `degrees` (north positive) the sun and moon are seen from for astronomical schedules, with -lon instead of -location")
+	longitude := flag.String(lonFlag, "", "Longitude in `degrees` (east positive) the sun and moon are seen from for astronomical schedules, with -lat instead of -location")
+	splayWindow := flag.Duration(splayFlag, 0, "Offset all schedules by a duration within this window derived from the hostname, so hosts sharing a config don't all run their jobs at once, e.g. 30m")
+	systemdScope := flag.Bool(systemdScopeFlag, false, "Run every job in a transient systemd scope of its own, so systemd tracks and accounts for its processes")
+	notBeforeTime := flag.String(notBeforeFlag, "", "Don't run jobs before this `time`, a date (YYYY-MM-DD) or RFC 3339 time")
+	untilTime := flag.String(untilFlag, "", "Don't run jobs from this `time` on and exit when it has passed, a date (YYYY-MM-DD) or RFC 3339 time")
+	onStart := flag.Bool(onStartFlag, false, "Run command once when cronolize starts and never again, the same as the cronSpec @reboot (which is left out)")
+	runAtStart := flag.Bool(nowFlag, false, "Run every job once right away at startup, before its first scheduled run")
+	var blackouts blackoutWindows
+	flag.Var(&blackouts, blackoutFlag, "Don't run jobs within this `window` of local time even if scheduled, e.g. SAT,SUN or 22:00-06:00 or both (repeatable)")
+	businessHours := flag.String(businessHoursFlag, "", "Only run jobs within this `window` of local time, written like a -blackout window, e.g. \"MON-FRI 08:00-18:00\"")
+	holidaysFile := flag.String(holidaysFlag, "", "Leave out the runs scheduled on the days listed in this `file`, one date (YYYY-MM-DD) per line or an iCalendar (.ics) file")
+	onHoliday := flag.String(onHolidayFlag, string(holidaySkip), "What to do about the runs scheduled on a day listed by -holidays: skip them, or move them to the same time on the next-business-day")
+	maxRuns := flag.Int(maxRunsFlag, 0, "Stop scheduling and exit once the commands have been run this many times in total, when the last run has finished (0 is unlimited)")
+	runOnResume := flag.Bool(runOnResumeFlag, false, "Run the jobs that came due while the system was suspended right away on resume instead of skipping them")
+	clockJump := flag.String(clockJumpFlag, string(clockJumpSkip), "What to do about the runs that came due when the clock is stepped forward: skip them, run every job that came due once, or catch-up on every run")
+	addStateDirFlag(flag.CommandLine)
+	controlSocket := flag.String(controlSocketFlag, "", "Listen for control commands on this unix domain socket `path` instead of one named after the PID in the runtime directory")
+	sentryDSN := flag.String(sentryDSNFlag, "", "Report failed runs and panics to Sentry using this `DSN` (default $"+sentryDSNEnvVar+")")
+	pushgatewayURL := flag.String(pushgatewayFlag, "", "Push the metrics of every run to the Prometheus Pushgateway at this `URL`")
+	snsTopic := flag.String(snsTopicFlag, "", "Publish an event for every finished run to the AWS SNS topic with this `ARN`")
+	eventBridgeBus := flag.String(eventBridgeBusFlag, "", "Put an event for every finished run on the AWS EventBridge bus with this `name` or ARN")
+	teamsWebhook := flag.String(teamsWebhookFlag, "", "Post a card about every failed run to this Microsoft Teams incoming webhook `URL`")
+	googleChatWebhook := flag.String(googleChatWebhookFlag, "", "Post a card about every failed run to this Google Chat incoming webhook `URL`")
+	mailFailures := flag.String(mailFailuresFlag, "", "Mail a report with the last lines of output about every failed run to these comma separated `addresses`")
+	mailTailLines := flag.Int("mail-tail-lines", defaultMailTailLines, "Number of output lines included in failure reports mailed by -"+mailFailuresFlag)
+	attachOutput := flag.Bool("attach-output", false, "Save the complete output of failed runs and attach it to mailed failure reports (and refer to it in other notifications) when it is longer than the tail shown inline")
+	notifyWindow := flag.Duration("notify-window", 0, "Alert about identical failures of a job at most once per this duration, followed by a \"still failing, N occurrences\" alert (0 alerts every failure)")
+	escalation := make(escalationRules)
+	flag.Var(escalation, escalateFlag, "Alert `channel=N` (sentry, mail, teams or google-chat) only from the Nth consecutive failure of a job (repeatable)")
+	notifyRecovery := flag.Bool(notifyRecoveryFlag, false, "Alert the channels that were alerted about a failing job when it succeeds again")
+	auditLogFile := flag.String("audit-log", "", "Append lifecycle events (start, stop, jobs enabled or disabled, maintenance) as JSON lines to this `file`")
+	simulateSpeed := flag.Float64(simulateSpeedFlag, 0, "Simulate the schedule in the foreground on a virtual clock running this many times faster than real time, implies -dry-run")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		printDefaults(flag.CommandLine)
+	}
+
+	// Subcommands, `cronolize man` renders a roff man page from the flags
+	// defined above.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case manCommand:
+			if len(os.Args) == 2 {
+				writeManPage(os.Stdout, flag.CommandLine)
+				return
+			}
+		case startCommand:
+			// The same as without a subcommand.
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case stopCommand:
+			stopCmd(os.Args[2:])
+			return
+		case listCommand:
+			listCmd(os.Args[2:])
+			return
+		case statusCommand:
+			statusCmd(os.Args[2:])
+			return
+		case runningCommand:
+			runningCmd(os.Args[2:])
+			return
+		case psCommand:
+			psCmd(os.Args[2:])
+			return
+		case enableCommand, disableCommand:
+			jobControlCmd(os.Args[1], os.Args[2:])
+			return
+		case setScheduleCommand:
+			setScheduleCmd(os.Args[2:])
+			return
+		case addCommand:
+			addJobCmd(os.Args[2:])
+			return
+		case removeCommand:
+			removeJobCmd(os.Args[2:])
+			return
+		case killCommand:
+			killJobCmd(os.Args[2:])
+			return
+		case freezeCommand, thawCommand:
+			freezeJobCmd(os.Args[1], os.Args[2:])
+			return
+		case runCommand:
+			runJobCmd(os.Args[2:])
+			return
+		case backfillCommand:
+			backfillJobCmd(os.Args[2:])
+			return
+		case retryFailedCommand:
+			retryFailedCmd(os.Args[2:])
+			return
+		case maintenanceCommand:
+			maintenanceCmd(os.Args[2:])
+			return
+		case upgradeCommand:
+			upgradeCmd(os.Args[2:])
+			return
+		case wasmCommand:
+			wasmCmd(os.Args[2:])
+			return
+		case doctorCommand:
+			doctorCmd(os.Args[2:])
+			return
+		case describeCommand:
+			describeCmd(os.Args[2:])
+			return
+		case explainCommand:
+			explainCmd(os.Args[2:])
+			return
+		}
+	}
 
 	flag.Parse()
 
-	if len(flag.Args()) != 2 {
-		pe("Welcome to cronolize %s (C) 2022 SA6MWA https://github.com/sa6mwa/cronolizer", version)
-		pe("")
-		pe("Syntax: %s [options] cronSpec command", os.Args[0])
-		pe("")
-		flag.Usage()
-		pe(helpMsg)
-		os.Exit(1)
+	// The background process inherits the working directory, but the paths
+	// end up in the status file.
+	if len(stateDirOverride) > 0 {
+		dir, err := filepath.Abs(stateDirOverride)
+		if err != nil {
+			fatal(err)
+		}
+		stateDirOverride = dir
+	}
+	if len(*controlSocket) > 0 {
+		path, err := filepath.Abs(*controlSocket)
+		if err != nil {
+			fatal(err)
+		}
+		*controlSocket = path
+	}
+
+	var jobs []*job
+	var cfg *config
+	// jobsFile is the config or crontab file, if any.
+	var jobsFile string
+	var jobFiles []string
+	for name, file := range map[string]string{configFlag: *configFile, crontabFlag: *crontabFile, systemCrontabFlag: *systemCrontabFile} {
+		if len(file) > 0 {
+			jobFiles = append(jobFiles, name)
+		}
+	}
+	if len(jobFiles) > 1 {
+		sort.Strings(jobFiles)
+		fatalf("Syntax error: you can not combine the -%s and the -%s option.", jobFiles[0], jobFiles[1])
+	}
+	if *calendar && *quartz {
+		fatalf("Syntax error: you can not combine the -%s and the -%s option.", calendarFlag, quartzFlag)
+	}
+	for name, set := range map[string]bool{calendarFlag: *calendar, quartzFlag: *quartz} {
+		if set && len(jobFiles) > 0 && jobFiles[0] != configFlag {
+			// Crontab lines are split into fields, the number of
+			// which varies in these expressions.
+			fatalf("Syntax error: you can not combine the -%s and the -%s option.", name, jobFiles[0])
+		}
+	}
+	// loadJobsFile reads the config or crontab file, also when it is
+	// reloaded on SIGHUP.
+	loadJobsFile := func() (*config, error) {
+		switch {
+		case len(*configFile) > 0:
+			return loadConfig(*configFile)
+		case len(*crontabFile) > 0:
+			return loadCrontab(*crontabFile, false)
+		}
+		return loadCrontab(*systemCrontabFile, true)
+	}
+	if *onStart && len(jobFiles) > 0 {
+		fatalf("Syntax error: you can not combine the -%s and the -%s option, use %s as the schedule of the job instead.", onStartFlag, jobFiles[0], rebootDescriptor)
+	}
+	if len(jobFiles) > 0 {
+		if len(flag.Args()) != 0 {
+			usage()
+		}
+		jobsFile = *configFile + *crontabFile + *systemCrontabFile
+		var err error
+		cfg, err = loadJobsFile()
+		if err != nil {
+			fatal(err)
+		}
+		jobs = cfg.Jobs
+	} else {
+		args := flag.Args()
+		if *onStart {
+			args = append([]string{rebootDescriptor}, args...)
+		}
+		if len(args) != 2 {
+			usage()
+		}
+		jobs = []*job{{
+			Name:     "1",
+			Schedule: args[0],
+			Command:  args[1],
+		}}
 	}
 
 	hasLogFlag := false
@@ -158,93 +488,1233 @@ func main() {
 		fatalf("Syntax error: you can not combine the -%s and the -%s option.", logFlag, foregroundFlag)
 	}
 
+	// A simulation always runs in the foreground and never executes
+	// anything.
+	var clock cronolizer.Clock = cronolizer.RealClock()
+	if *simulateSpeed < 0 {
+		fatalf("Syntax error: -%s must be positive.", simulateSpeedFlag)
+	}
+	if *simulateSpeed > 0 {
+		if hasLogFlag {
+			fatalf("Syntax error: you can not combine the -%s and the -%s option.", logFlag, simulateSpeedFlag)
+		}
+		*foreground = true
+		*dryRun = true
+		clock = cronolizer.NewScaledClock(time.Now(), *simulateSpeed)
+	}
+	jumpPolicy, jumpErr := parseClockJumpPolicy(*clockJump)
+	if jumpErr != nil {
+		fatalf("Syntax error: -%s: %v", clockJumpFlag, jumpErr)
+	}
+	defaultOverlap, overlapErr := cronolizer.ParseOverlapPolicy(*overlap)
+	if overlapErr != nil {
+		fatalf("Syntax error: -%s: %v", overlapFlag, overlapErr)
+	}
+	var notBefore, until time.Time
+	for _, t := range []struct {
+		flag  string
+		value string
+		time  *time.Time
+	}{{notBeforeFlag, *notBeforeTime, &notBefore}, {untilFlag, *untilTime, &until}} {
+		if len(t.value) == 0 {
+			continue
+		}
+		parsed, err := parseBackfillTime(t.value, time.Local, false)
+		if err != nil {
+			fatalf("Syntax error: -%s: %v", t.flag, err)
+		}
+		*t.time = parsed
+	}
+	if !until.IsZero() && !until.After(notBefore) {
+		fatalf("Syntax error: -%s must be after -%s.", untilFlag, notBeforeFlag)
+	}
+	if !until.IsZero() && !until.After(clock.Now()) {
+		fatalf("Error: -%s %s has already passed.", untilFlag, *untilTime)
+	}
+	if *nextRuns < 0 {
+		fatalf("Syntax error: -%s must be positive.", nextFlag)
+	}
+	if *logMaxFiles < 0 {
+		fatalf("Syntax error: -%s must be positive.", logMaxFilesFlag)
+	}
+	if *maxRuns < 0 {
+		fatalf("Syntax error: -%s must be positive.", maxRunsFlag)
+	}
+	if *jitter < 0 {
+		fatalf("Syntax error: -%s must be positive.", jitterFlag)
+	}
+	if *retries < 0 {
+		fatalf("Syntax error: -%s must not be negative.", retriesFlag)
+	}
+	if *retryBackoff <= 0 {
+		fatalf("Syntax error: -%s must be positive.", retryBackoffFlag)
+	}
+	if *jobTimeout < 0 {
+		fatalf("Syntax error: -%s must be positive.", timeoutFlag)
+	}
+	if *fairQueue && *workers <= 0 {
+		fatalf("Syntax error: -%s requires -workers.", fairFlag)
+	}
+	holidayAction, holidayErr := parseHolidayPolicy(*onHoliday)
+	if holidayErr != nil {
+		fatalf("Syntax error: -%s: %v", onHolidayFlag, holidayErr)
+	}
+	if holidayAction != holidaySkip && len(*holidaysFile) == 0 {
+		fatalf("Syntax error: -%s requires -%s.", onHolidayFlag, holidaysFlag)
+	}
+	var geo *geoLocation
+	if len(*location) > 0 {
+		if len(*latitude) > 0 || len(*longitude) > 0 {
+			fatalf("Syntax error: -%s can not be combined with -%s or -%s.", locationFlag, latFlag, lonFlag)
+		}
+		var err error
+		if geo, err = parseGeoLocation(*location); err != nil {
+			fatalf("Syntax error: -%s: %v", locationFlag, err)
+		}
+	} else if len(*latitude) > 0 || len(*longitude) > 0 {
+		if len(*latitude) == 0 || len(*longitude) == 0 {
+			fatalf("Syntax error: -%s and -%s must be given together.", latFlag, lonFlag)
+		}
+		var err error
+		if geo, err = parseLatLon(*latitude, *longitude); err != nil {
+			fatalf("Syntax error: %v", err)
+		}
+	}
+	var parser cron.ScheduleParser = astroParser{location: geo}
+	if *calendar {
+		parser = calendarParser{parser: parser}
+	}
+	if *quartz {
+		parser = quartzParser{parser: parser}
+	}
+	splay := splayOffset(*splayWindow)
+	if splay > 0 {
+		parser = splayParser{parser: parser, offset: splay}
+	}
+	if len(*businessHours) > 0 {
+		window, err := parseTimeWindow(*businessHours)
+		if err != nil {
+			fatalf("Syntax error: -%s: %v", businessHoursFlag, err)
+		}
+		parser = businessHoursParser{parser: parser, window: window}
+	}
+	if len(*holidaysFile) > 0 {
+		days, err := loadHolidays(*holidaysFile)
+		if err != nil {
+			fatal(err)
+		}
+		parser = holidayParser{parser: parser, holidays: days, policy: holidayAction}
+	}
+	if !notBefore.IsZero() || !until.IsZero() {
+		parser = windowParser{parser: parser, notBefore: notBefore, until: until}
+	}
+	if *systemdScope {
+		if err := checkSystemdRun(); err != nil {
+			fatalf("Error: -%s: %v", systemdScopeFlag, err)
+		}
+	}
+	// Log entries are stamped by a clockWriter rather than the log
+	// package when simulating or with -log-time-format.
+	stampFormat := logTimeFormat{layout: defaultLogTimeLayout, utc: *utcLogs}
+	summaryFormat := logTimeFormat{layout: summaryTimeFormat, utc: *utcLogs}
+	if len(*logTimeLayout) > 0 {
+		f, err := parseLogTimeFormat(*logTimeLayout, *utcLogs)
+		if err != nil {
+			fatalf("Syntax error: -%s: %v", logTimeFormatFlag, err)
+		}
+		stampFormat, summaryFormat = f, f
+	}
+	stamped := *simulateSpeed > 0 || len(*logTimeLayout) > 0
+	logWriter := func(w io.Writer) io.Writer {
+		if stamped {
+			return newClockWriter(w, clock, stampFormat)
+		}
+		return w
+	}
+	switch {
+	case stamped:
+		log.SetFlags(0)
+		log.SetOutput(logWriter(os.Stderr))
+	case *utcLogs:
+		log.SetFlags(log.LstdFlags | log.LUTC)
+	}
+
+	// Honor SHELL unless -shell was given and make sure the shell exists now
+	// rather than failing at the first run.
+	if len(*shell) == 0 {
+		*shell = os.Getenv("SHELL")
+		if len(*shell) == 0 {
+			*shell = defaultShell
+		}
+	}
+	if _, err := exec.LookPath(*shell); err != nil {
+		fatalf("Error: shell %s can not be used: %v", *shell, err)
+	}
+	for _, j := range jobs {
+		if err := j.prepare(); err != nil {
+			fatalf("Error: job %s: %v", j.Name, err)
+		}
+	}
+	if *nextRuns > 0 {
+		if err := printNextRuns(os.Stdout, parser, jobs, *nextRuns, clock.Now(), blackouts, cfg != nil); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	// The log in the config file is the default unless -log was given, it is
+	// ignored in the foreground just as -log is not allowed there.
+	if cfg != nil && len(cfg.Log) > 0 && !hasLogFlag {
+		*logfile = cfg.Log
+	}
+
+	// With -log-max-size, log files are rotated, with -log-buffer they are
+	// written asynchronously by a flusher and with -collapse-repeats
+	// identical consecutive lines are collapsed. Both are flushed on exit.
+	// Only the cron process writes to log files.
+	// Log paths may be strftime patterns, see logdate.go.
+	logNow := func() time.Time {
+		if *utcLogs {
+			return clock.Now().UTC()
+		}
+		return clock.Now()
+	}
+	openLogPath := func(path string) (*os.File, error) {
+		if isLogPattern(path) {
+			f, _, err := openDatedLog(path, logNow(), *truncateLog)
+			return f, err
+		}
+		return openLog(path, *truncateLog)
+	}
+	rotation := logRotation{maxSize: int64(logMaxSize), maxFiles: *logMaxFiles, compress: *logCompress}
+	wrapLog := func(f *os.File, path string) io.Writer {
+		if !isCronProcess && !*foreground {
+			return f
+		}
+		var w io.Writer = f
+		switch {
+		case isLogPattern(path):
+			w = newDatedLog(f, f.Name(), path, logNow, rotation)
+		case logMaxSize > 0:
+			w = newRotatingLog(f, path, rotation)
+		}
+		if *logBuffer > 0 {
+			aw := newAsyncWriter(w, *logBuffer)
+			atExit(func() { aw.Close() })
+			w = aw
+		}
+		if *collapseRepeats {
+			rc := newRepeatCollapser(w)
+			atExit(func() { rc.Close() })
+			w = rc
+		}
+		return w
+	}
+
+	// Job output and the daemon's own log entries go to stdout and stderr,
+	// except in the background cron process where both go to the log file.
+	// The writers are handed to each command and logger, os.Stdout and
+	// os.Stderr are left untouched.
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
 	if !*foreground {
-		cleanedPath := filepath.Clean(*logfile)
-		evaluatedPath, err := filepath.EvalSymlinks(cleanedPath)
+		evaluatedPath, err := resolveLogPath(*logfile)
 		if err != nil {
-			if !errors.Is(err, fs.ErrNotExist) {
-				fatal(err)
-			} else {
-				evaluatedPath = cleanedPath
-			}
+			fatal(err)
 		}
 		logfile = &evaluatedPath
 
-		var flags int
-		if *truncateLog {
-			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND | os.O_TRUNC
-		} else {
-			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
-		}
-		logfileFD, err := os.OpenFile(*logfile, flags, 0666)
+		logfileFD, err := openLogPath(*logfile)
 		if err != nil {
 			fatal(err)
 		}
 
 		if isCronProcess {
-			os.Stdout = logfileFD
-			os.Stderr = logfileFD
-			log.SetOutput(logfileFD)
+			w := wrapLog(logfileFD, *logfile)
+			stdout = w
+			stderr = w
+			log.SetOutput(logWriter(w))
 		} else {
 			logfileFD.Close()
 		}
 	}
 
-	c := cron.New()
-	_, err := c.AddFunc(flag.Args()[0], func() {
-		var cmd *exec.Cmd
-		if len(*shellCommandOption) != 0 {
-			if !*quiet {
-				log.Printf("Running: %s", strings.Join([]string{*shell, *shellCommandOption, flag.Args()[1]}, " "))
+	// Jobs with a log of their own write both output and log entries there,
+	// also in the foreground. Each file is only opened once.
+	openLogs := make(map[string]*os.File)
+	jobLogs := make(map[string]io.Writer)
+	setupJob := func(j *job, prefixed bool) error {
+		j.stdout = stdout
+		j.stderr = stderr
+		if len(j.Log) > 0 {
+			evaluatedPath, err := resolveLogPath(j.Log)
+			if err != nil {
+				return err
 			}
-			cmd = exec.Command(*shell, *shellCommandOption, flag.Args()[1])
-		} else {
-			if !*quiet {
-				log.Printf("Running: %s", strings.Join([]string{*shell, flag.Args()[1]}, " "))
+			j.Log = evaluatedPath
+			f, ok := openLogs[j.Log]
+			if !ok {
+				f, err = openLogPath(j.Log)
+				if err != nil {
+					return err
+				}
+				openLogs[j.Log] = f
+				jobLogs[j.Log] = wrapLog(f, j.Log)
 			}
-			cmd = exec.Command(*shell, flag.Args()[1])
+			j.stdout = jobLogs[j.Log]
+			j.stderr = jobLogs[j.Log]
 		}
-		if !*foreground {
-			cmd.Stdin = os.Stdin
-		} else {
-			cmd.Stdin = nil
+		if j.Enabled != nil {
+			j.setEnabled(*j.Enabled)
+		}
+		var prefix string
+		if prefixed {
+			prefix = j.Name + ": "
+		}
+		j.logger = log.New(logWriter(j.stderr), prefix, log.Flags()|log.Lmsgprefix)
+		return nil
+	}
+	for _, j := range jobs {
+		if err := setupJob(j, cfg != nil); err != nil {
+			fatal(err)
 		}
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
+	}
+	if !isCronProcess && !*foreground {
+		for _, f := range openLogs {
+			f.Close()
+		}
+	}
+
+	// In the foreground on a terminal, colorize job headers and failures and
+	// show a live countdown to the next run (except when simulating, the
+	// countdown is in real time).
+	interactive := *foreground && isTerminal(os.Stdout)
+	if interactive {
+		_, noColor := os.LookupEnv("NO_COLOR")
+		useColor = !noColor
+	}
+	var cd *countdown
+	var sf *statusFile
+
+	// Notifiers are handed every finished run, alerts about failures are
+	// throttled by -notify-window and escalated by -escalate.
+	var notifiers []notifier
+	alerts := newAlertState(*notifyWindow, *notifyRecovery)
+	if len(*sentryDSN) == 0 {
+		*sentryDSN = os.Getenv(sentryDSNEnvVar)
+	}
+	if len(*sentryDSN) > 0 {
+		sentry, err := newSentryNotifier(*sentryDSN)
+		if err != nil {
+			fatal(err)
+		}
+		notifiers = append(notifiers, sentry)
+		onPanic(sentry.reportPanic)
+	}
+	usesPushgateway := len(*pushgatewayURL) > 0
+	for _, j := range jobs {
+		usesPushgateway = usesPushgateway || len(j.Pushgateway) > 0
+	}
+	if usesPushgateway {
+		notifiers = append(notifiers, newPushgatewayNotifier(*pushgatewayURL))
+	}
+	if len(*snsTopic) > 0 {
+		sns, err := newSNSNotifier(*snsTopic)
 		if err != nil {
 			fatal(err)
 		}
+		notifiers = append(notifiers, sns)
+	}
+	if len(*eventBridgeBus) > 0 {
+		eventBridge, err := newEventBridgeNotifier(*eventBridgeBus)
+		if err != nil {
+			fatal(err)
+		}
+		notifiers = append(notifiers, eventBridge)
+	}
+	mailsFailures := len(*mailFailures) > 0
+	for _, j := range jobs {
+		mailsFailures = mailsFailures || len(j.MailFailures) > 0
+	}
+	if mailsFailures {
+		notifiers = append(notifiers, &mailNotifier{to: *mailFailures, tailLines: *mailTailLines})
+	}
+	if len(*teamsWebhook) > 0 {
+		notifiers = append(notifiers, newTeamsNotifier(*teamsWebhook))
+	}
+	if len(*googleChatWebhook) > 0 {
+		notifiers = append(notifiers, newGoogleChatNotifier(*googleChatWebhook))
+	}
+	escalated, escalateErr := escalate(notifiers, escalation)
+	if escalateErr != nil {
+		fatal(escalateErr)
+	}
+	notifiers = escalated
+
+	schedulerOptions := []cronolizer.Option{cronolizer.WithClock(clock), cronolizer.WithParser(parser)}
+	// onJump handles the clock jumping once everything is set up, see
+	// resume.go.
+	var onJump func(jump time.Duration)
+	if *simulateSpeed == 0 {
+		schedulerOptions = append(schedulerOptions, cronolizer.WithJumpDetection(jumpCheckInterval, jumpThreshold, func(jump time.Duration) {
+			if onJump != nil {
+				onJump(jump)
+			}
+		}))
+	}
+	jumps := newJumpSplitter()
+	c := cronolizer.NewScheduler(schedulerOptions...)
+	maint := &maintenanceMode{}
+	runs := newActiveRuns()
+	jobRunner := &runner{
+		runOptions: runOptions{
+			quiet:             quiet,
+			shell:             *shell,
+			shellOption:       *shellCommandOption,
+			dryRun:            *dryRun,
+			foreground:        *foreground,
+			jitter:            *jitter,
+			overlap:           defaultOverlap,
+			retries:           *retries,
+			retryBackoff:      *retryBackoff,
+			timeout:           *jobTimeout,
+			maxMemory:         int64(maxMemory),
+			sampleInterval:    *sampleInterval,
+			maxRuns:           *maxRuns,
+			systemdScope:      *systemdScope,
+			suppressUnchanged: *suppressUnchanged,
+			tagOutput:         *tagOutput,
+			attachOutput:      *attachOutput,
+			captureMemory:     int64(captureMemory),
+			blackouts:         blackouts,
+			summaryFormat:     summaryFormat,
+		},
+		clock:     clock,
+		scheduler: c,
+		maint:     maint,
+		runs:      runs,
+		notifiers: notifiers,
+		alerts:    alerts,
+	}
+	// Jobs fired at the same instant are started in priority order, by a
+	// bounded worker pool if -workers is given. When simulating, the window
+	// collecting simultaneous fires shrinks with the speed of the clock.
+	window := dispatchWindow
+	if *simulateSpeed > 0 {
+		window = time.Duration(float64(dispatchWindow) / *simulateSpeed)
+	}
+	d := newDispatcher(jobRunner.run, window, *serializePriorities, *workers, *queueLimit, *fairQueue, func(stats poolStats) {
+		if sf != nil {
+			setPoolStats(sf, stats)
+		}
 	})
-	if err != nil {
-		fatal(err)
+	for _, j := range jobs {
+		j := j
+		id, err := j.scheduleOn(c, func() { d.submit(j, c.Entry(j.id).Prev) })
+		if err != nil {
+			if cfg != nil {
+				fatalf("Error: job %s: %v", j.Name, err)
+			}
+			fatal(err)
+		}
+		j.id = id
 	}
 
 	if isCronProcess || *foreground {
-		// Start cron and wait forever.
-		c.Start()
+		defer reportPanic()
+		var audit *auditLog
+		if len(*auditLogFile) > 0 {
+			path, err := resolveLogPath(*auditLogFile)
+			if err != nil {
+				fatalLog(err)
+			}
+			if audit, err = openAuditLog(path); err != nil {
+				fatalLog(err)
+			}
+			atExit(audit.close)
+			atExit(func() {
+				audit.record(auditEntry{Event: auditStop, Detail: getExitReason()})
+			})
+		}
+		if interactive && *simulateSpeed == 0 {
+			cd = newCountdown(os.Stdout, func() time.Time { return nextRun(c) })
+			jobRunner.cd = cd
+		}
+		// Jobs may be added and removed by control commands, jobsMu guards
+		// the jobs list from then on.
+		var jobsMu sync.Mutex
+		var removedJobs []string
+		jobNamed := func(name string) *job {
+			for _, j := range jobs {
+				if j.Name == name {
+					return j
+				}
+			}
+			return nil
+		}
+		// scheduleJob sets up and schedules a prepared job at runtime,
+		// jobsMu must be held.
+		scheduleJob := func(j *job) error {
+			if err := setupJob(j, true); err != nil {
+				return err
+			}
+			id, err := j.scheduleOn(c, func() { d.submit(j, c.Entry(j.id).Prev) })
+			if err != nil {
+				return fmt.Errorf("invalid schedule %q: %w", j.Schedule, err)
+			}
+			if err := j.watch(d); err != nil {
+				c.Remove(id)
+				return err
+			}
+			j.id = id
+			jobs = append(jobs, j)
+			return nil
+		}
+		// addJob schedules a job added at runtime (named after the next
+		// free number if it has no name), jobsMu must be held.
+		addJob := func(j *job) error {
+			if len(j.Name) == 0 {
+				for n := len(jobs) + 1; len(j.Name) == 0; n++ {
+					if jobNamed(strconv.Itoa(n)) == nil {
+						j.Name = strconv.Itoa(n)
+					}
+				}
+			} else if jobNamed(j.Name) != nil {
+				return fmt.Errorf("there already is a job named %q", j.Name)
+			}
+			j.index = -1
+			j.added = true
+			if err := j.prepare(); err != nil {
+				return err
+			}
+			return scheduleJob(j)
+		}
+		// removeJob unschedules j, a removed job that is not persisted is
+		// remembered for an upgrade. jobsMu must be held.
+		removeJob := func(j *job, persisted bool) {
+			c.Remove(j.id)
+			j.unwatch()
+			remaining := make([]*job, 0, len(jobs))
+			for _, other := range jobs {
+				if other == j {
+					continue
+				}
+				if persisted && other.index > j.index {
+					other.index--
+				}
+				remaining = append(remaining, other)
+			}
+			jobs = remaining
+			if !persisted {
+				removedJobs = append(removedJobs, j.Name)
+			}
+		}
+		// A process started by an upgrade takes over the state of the
+		// process it replaces, see upgrade.go.
+		takeover, err := readHandover()
+		if err != nil {
+			fatalLog(err)
+		}
+		next := make(map[cronolizer.EntryID]time.Time)
+		if takeover != nil {
+			for _, name := range takeover.Removed {
+				if j := jobNamed(name); j != nil {
+					removeJob(j, false)
+				}
+			}
+			for _, j := range takeover.Added {
+				if err := addJob(j); err != nil {
+					log.Printf("Unable to take over job %s: %v", j.Name, err)
+				}
+			}
+			for _, j := range jobs {
+				js := takeover.job(j.Name)
+				if js == nil {
+					continue
+				}
+				j.setEnabled(js.Enabled)
+				// A schedule changed in the config file wins over the
+				// schedule of the old process.
+				persisted := len(*configFile) > 0 && j.index >= 0
+				if js.Spec != j.spec() && !persisted {
+					if err := c.Reschedule(j.id, js.Spec); err == nil {
+						j.setSpec(js.Spec)
+					}
+				}
+				if js.Spec == j.spec() && js.Next != nil {
+					next[j.id] = *js.Next
+				}
+			}
+			maint.restore(takeover.Maintenance)
+		}
+		status := daemonStatus{
+			PID:        os.Getpid(),
+			Version:    version,
+			Started:    time.Now(),
+			Foreground: *foreground,
+			Workers:    *workers,
+			QueueLimit: *queueLimit,
+			Jobs:       make([]jobStatus, 0, len(jobs)),
+		}
+		if !*foreground {
+			status.Logfile = *logfile
+		}
+		status.User = usernameForUID(os.Geteuid())
+		if len(jobsFile) > 0 {
+			if path, err := filepath.Abs(jobsFile); err == nil {
+				status.Config = path
+			}
+		}
+		for _, j := range jobs {
+			js := j.status()
+			if takeover != nil {
+				if old := takeover.job(j.Name); old != nil {
+					js.Prev = old.Prev
+					js.Runs = old.Runs
+					js.Successes = old.Successes
+					js.Failures = old.Failures
+					js.Cancelled = old.Cancelled
+					js.Skipped = old.Skipped
+					js.ConsecutiveFailures = old.ConsecutiveFailures
+					js.AverageDurationSeconds = old.AverageDurationSeconds
+					js.Recent = old.Recent
+				}
+			}
+			status.Jobs = append(status.Jobs, js)
+		}
+		if takeover != nil && maint.active() {
+			status.Maintenance = takeover.Maintenance
+		}
+		// Control commands are accepted on a unix domain socket.
+		socketPath := controlSocketPath(os.Getpid())
+		if len(*controlSocket) > 0 {
+			socketPath = *controlSocket
+			// An upgraded process takes the socket over from the one
+			// it replaces.
+			if takeover == nil && controlSocketInUse(socketPath) {
+				fatalLog(fmt.Sprintf("-%s %s: in use by another process", controlSocketFlag, socketPath))
+			}
+		}
+		ctl, err := newControlServer(socketPath)
+		if err != nil {
+			fatalLog(err)
+		}
+		atExit(ctl.close)
+		status.ControlSocket = ctl.path
+		sf, err = newStatusFile(status)
+		if err != nil {
+			fatalLog(err)
+		}
+		atExit(sf.remove)
+		jobRunner.status = sf
+		// The process exits on these signals, or with the reason sent to
+		// exiting once it is done (after handing over to an upgraded
+		// process), handled by the main loop below.
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		// The config or crontab file is reloaded on SIGHUP.
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		exiting := make(chan string, 1)
+		setEnabled := func(enabled bool) controlHandler {
+			return func(req controlRequest) error {
+				jobsMu.Lock()
+				defer jobsMu.Unlock()
+				j := jobNamed(req.Job)
+				if j == nil {
+					return fmt.Errorf("no job named %q", req.Job)
+				}
+				j.setEnabled(enabled)
+				setJobEnabled(sf, j.id, enabled)
+				event := auditDisable
+				if enabled {
+					event = auditEnable
+					j.logger.Print("Enabled via control socket")
+				} else {
+					j.logger.Print("Disabled via control socket")
+				}
+				audit.record(auditEntry{Event: event, User: controlPeer(req), Job: j.Name})
+				return nil
+			}
+		}
+		ctl.handle(enableCommand, setEnabled(true))
+		ctl.handle(disableCommand, setEnabled(false))
+		// A schedule changed at runtime is written back to the config, it
+		// lasts until the daemon is restarted for jobs given on the
+		// command line or in a crontab.
+		ctl.handle(setScheduleCommand, func(req controlRequest) error {
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			j := jobNamed(req.Job)
+			if j == nil {
+				return fmt.Errorf("no job named %q", req.Job)
+			}
+			if len(*configFile) > 0 && j.expanded {
+				return fmt.Errorf("job %q is expanded from a %s, change the schedule of its template in %s", j.Name, matrixKey, *configFile)
+			}
+			old := j.spec()
+			if err := c.Reschedule(j.id, req.Schedule); err != nil {
+				return fmt.Errorf("invalid schedule %q: %w", req.Schedule, err)
+			}
+			if len(*configFile) > 0 && j.index >= 0 {
+				if err := saveSchedule(*configFile, j.index, req.Schedule); err != nil {
+					c.Reschedule(j.id, old)
+					return err
+				}
+			}
+			j.setSpec(req.Schedule)
+			setJobSpec(sf, c, j.id, req.Schedule)
+			j.logger.Printf("Schedule changed from %q to %q via control socket", old, req.Schedule)
+			audit.record(auditEntry{Event: auditSetSchedule, User: controlPeer(req), Job: j.Name, Detail: fmt.Sprintf("%q to %q", old, req.Schedule)})
+			return nil
+		})
+		// Added jobs are written to the config with -persist, otherwise
+		// they only last until the daemon is restarted. The same goes for
+		// removed jobs.
+		ctl.handle(addCommand, func(req controlRequest) error {
+			j := req.Definition
+			if j == nil || len(j.Schedule) == 0 || len(j.Command) == 0 {
+				return errors.New("a job needs a schedule and a command")
+			}
+			if req.Persist && len(*configFile) == 0 {
+				return fmt.Errorf("process %d was not started with -%s, there is no config to persist the job in", os.Getpid(), configFlag)
+			}
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			if err := addJob(j); err != nil {
+				return err
+			}
+			if req.Persist {
+				index, err := saveAddedJob(*configFile, j)
+				if err != nil {
+					c.Remove(j.id)
+					jobs = jobs[:len(jobs)-1]
+					return err
+				}
+				j.index = index
+			}
+			addJobStatus(sf, c, j)
+			j.logger.Printf("Added via control socket: %s %s", j.Schedule, j.Command)
+			audit.record(auditEntry{Event: auditAdd, User: controlPeer(req), Job: j.Name, Detail: fmt.Sprintf("%s %s", j.Schedule, j.Command)})
+			return nil
+		})
+		ctl.handle(removeCommand, func(req controlRequest) error {
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			j := jobNamed(req.Job)
+			if j == nil {
+				return fmt.Errorf("no job named %q", req.Job)
+			}
+			if req.Persist {
+				if len(*configFile) == 0 || j.index < 0 {
+					return fmt.Errorf("job %q is not in a config file", j.Name)
+				}
+				if j.expanded {
+					return fmt.Errorf("job %q is expanded from a %s, remove its value from %s", j.Name, matrixKey, *configFile)
+				}
+				if err := saveRemovedJob(*configFile, j.index); err != nil {
+					return err
+				}
+			}
+			removeJob(j, req.Persist)
+			removeJobStatus(sf, j.id)
+			d.retain(jobs)
+			j.logger.Print("Removed via control socket")
+			audit.record(auditEntry{Event: auditRemove, User: controlPeer(req), Job: j.Name})
+			return nil
+		})
+		// A run requested using `cronolize run` goes through the
+		// dispatcher like a fire of its own, so the workers and the
+		// drain before exiting after an upgrade cover it.
+		ctl.handle(runCommand, func(req controlRequest) error {
+			for _, param := range req.Params {
+				if err := checkParam(param); err != nil {
+					return err
+				}
+			}
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			j := jobNamed(req.Job)
+			if j == nil {
+				return fmt.Errorf("no job named %q", req.Job)
+			}
+			if !j.isEnabled() {
+				return fmt.Errorf("job %q is disabled", j.Name)
+			}
+			if maint.active() {
+				return errors.New("maintenance mode is on")
+			}
+			detail := strings.Join(req.Params, " ")
+			if len(detail) > 0 {
+				j.logger.Print("Run requested via control socket with ", detail)
+			} else {
+				j.logger.Print("Run requested via control socket")
+			}
+			audit.record(auditEntry{Event: auditRun, User: controlPeer(req), Job: j.Name, Detail: detail})
+			d.runNow(j, req.Params)
+			return nil
+		})
+		ctl.handle(backfillCommand, func(req controlRequest) error {
+			for _, param := range req.Params {
+				if err := checkParam(param); err != nil {
+					return err
+				}
+			}
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			j := jobNamed(req.Job)
+			if j == nil {
+				return fmt.Errorf("no job named %q", req.Job)
+			}
+			if !j.isEnabled() {
+				return fmt.Errorf("job %q is disabled", j.Name)
+			}
+			if maint.active() {
+				return errors.New("maintenance mode is on")
+			}
+			if len(j.Schedule) == 0 {
+				return fmt.Errorf("job %q has no schedule", j.Name)
+			}
+			from, to, err := backfillRange(req, c.Location(), c.Clock().Now())
+			if err != nil {
+				return err
+			}
+			times, err := backfillTimes(c.Entry(j.id).Schedule, from, to)
+			if err != nil {
+				return err
+			}
+			if len(times) == 0 {
+				return fmt.Errorf("job %q is not scheduled from %s to %s", j.Name, req.From, req.To)
+			}
+			detail := fmt.Sprintf("%d runs from %s to %s", len(times), times[0].Format(time.RFC3339), times[len(times)-1].Format(time.RFC3339))
+			j.logger.Print("Backfill of ", detail, " requested via control socket")
+			audit.record(auditEntry{Event: auditBackfill, User: controlPeer(req), Job: j.Name, Detail: detail})
+			go func() {
+				for i, t := range times {
+					if !j.isEnabled() || maint.active() {
+						j.logger.Printf("Backfill stopped after %d of %d runs", i, len(times))
+						return
+					}
+					params := append([]string{scheduledTimeEnvVar + "=" + t.Format(time.RFC3339)}, req.Params...)
+					d.runAndWait(fire{j: j, scheduled: t, params: params})
+				}
+				j.logger.Printf("Backfill of %d runs finished", len(times))
+			}()
+			return nil
+		})
+		ctl.handle(retryFailedCommand, func(req controlRequest) error {
+			if req.Duration <= 0 {
+				return errors.New("invalid duration")
+			}
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			if maint.active() {
+				return errors.New("maintenance mode is on")
+			}
+			since := c.Clock().Now().Add(-req.Duration)
+			total := 0
+			for _, j := range jobs {
+				if len(req.Job) > 0 && j.Name != req.Job {
+					continue
+				}
+				if !j.isEnabled() {
+					if len(req.Job) > 0 {
+						return fmt.Errorf("job %q is disabled", j.Name)
+					}
+					continue
+				}
+				failed := takeFailedRuns(sf, j.id, since)
+				if len(failed) == 0 {
+					continue
+				}
+				total += len(failed)
+				j.logger.Printf("Retry of %d failed runs requested via control socket", len(failed))
+				audit.record(auditEntry{Event: auditRetry, User: controlPeer(req), Job: j.Name, Detail: fmt.Sprintf("%d runs", len(failed))})
+				go func(j *job) {
+					for _, run := range failed {
+						if !j.isEnabled() || maint.active() {
+							return
+						}
+						d.runAndWait(run.retryFire(j))
+					}
+				}(j)
+			}
+			if total == 0 {
+				return fmt.Errorf("no failed runs within %s", req.Duration)
+			}
+			return nil
+		})
+		ctl.handle(killCommand, func(req controlRequest) error {
+			sig, err := parseSignal(req.Signal)
+			if err != nil {
+				return err
+			}
+			run, err := runs.kill(req.Job, req.RunID, sig, controlPeer(req))
+			if err != nil {
+				return err
+			}
+			audit.record(auditEntry{Event: auditKill, User: controlPeer(req), Job: req.Job, Detail: fmt.Sprintf("run %d (PID %d) with %s", run.id, run.process.Pid, sigName(sig))})
+			return nil
+		})
+		setFrozen := func(frozen bool) controlHandler {
+			return func(req controlRequest) error {
+				run, err := runs.freeze(req.Job, req.RunID, frozen)
+				if err != nil {
+					return err
+				}
+				event, state := auditThaw, "Thawed"
+				if frozen {
+					event, state = auditFreeze, "Frozen"
+				}
+				run.job.logger.Printf("%s run %d via control socket", state, run.id)
+				setActiveRunFrozen(sf, run.id, frozen)
+				audit.record(auditEntry{Event: event, User: controlPeer(req), Job: req.Job, Detail: fmt.Sprintf("run %d (PID %d)", run.id, run.process.Pid)})
+				return nil
+			}
+		}
+		ctl.handle(freezeCommand, setFrozen(true))
+		ctl.handle(thawCommand, setFrozen(false))
+		upgrading := false
+		ctl.handle(upgradeCommand, func(req controlRequest) error {
+			if *foreground {
+				return errors.New("a process running in the foreground can not be upgraded")
+			}
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			if upgrading {
+				return errors.New("already upgraded, waiting for running jobs to finish")
+			}
+			c.Stop()
+			snapshot := sf.snapshot()
+			h := handover{
+				PID:         os.Getpid(),
+				Removed:     removedJobs,
+				Maintenance: snapshot.Maintenance,
+			}
+			next := make(map[cronolizer.EntryID]time.Time)
+			for _, js := range snapshot.Jobs {
+				if entry := c.Entry(cronolizer.EntryID(js.ID)); !entry.Next.IsZero() {
+					js.Next = &entry.Next
+					next[entry.ID] = entry.Next
+				}
+				h.Jobs = append(h.Jobs, js)
+			}
+			for _, j := range jobs {
+				if j.added && j.index < 0 {
+					h.Added = append(h.Added, j)
+				}
+			}
+			for _, j := range jobs {
+				j.unwatch()
+			}
+			pid, err := startUpgrade(h)
+			if err != nil {
+				for _, j := range jobs {
+					if err := j.watch(d); err != nil {
+						j.logger.Print(colorize("Error:", ansiBold, ansiRed), " watching ", j.Watch, ": ", err)
+					}
+				}
+				c.StartAt(next)
+				log.Printf("Upgrade failed, resuming: %v", err)
+				return err
+			}
+			upgrading = true
+			log.Printf("Upgraded, PID %d has taken over, exiting when the running jobs have finished", pid)
+			audit.record(auditEntry{Event: auditUpgrade, User: controlPeer(req), Detail: fmt.Sprintf("taken over by PID %d", pid)})
+			go func() {
+				d.drain()
+				exiting <- fmt.Sprintf("upgraded, taken over by PID %d", pid)
+			}()
+			return nil
+		})
+		maint.changed = func(m *maintenanceStatus, by, detail string) {
+			setMaintenance(sf, m)
+			event := auditMaintenanceOff
+			if m != nil {
+				event = auditMaintenanceOn
+				log.Printf("Maintenance mode on %s", detail)
+			} else {
+				log.Printf("Maintenance mode off %s", detail)
+			}
+			audit.record(auditEntry{Event: event, User: by, Detail: detail})
+		}
+		ctl.handle(maintenanceCommand, func(req controlRequest) error {
+			if req.On {
+				maint.start(req.Duration, controlPeer(req))
+			} else {
+				maint.stop(controlPeer(req))
+			}
+			return nil
+		})
+		// On SIGHUP the config or crontab file is read again and the
+		// jobs in it that were added, changed or removed are added to,
+		// replaced in or removed from the scheduler. Unchanged jobs keep
+		// their entries and next runs, jobs added at runtime without
+		// -persist are left alone. Nothing is changed if the file is
+		// invalid.
+		reload := func() {
+			if len(jobsFile) == 0 {
+				log.Print("Received SIGHUP, but there is no config or crontab file to reload")
+				return
+			}
+			reloaded, err := loadJobsFile()
+			if err == nil {
+				for _, j := range reloaded.Jobs {
+					if err = j.prepare(); err != nil {
+						err = fmt.Errorf("job %s: %w", j.Name, err)
+						break
+					}
+				}
+			}
+			if err != nil {
+				log.Print(colorize("Error:", ansiBold, ansiRed), " reloading ", jobsFile, ", keeping the jobs as they are: ", err)
+				return
+			}
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			if upgrading {
+				log.Printf("Received SIGHUP while upgrading, %s is read by the new process", jobsFile)
+				return
+			}
+			fresh := make(map[string]*job)
+			for _, j := range reloaded.Jobs {
+				fresh[j.Name] = j
+			}
+			var added, changed, removed []string
+			replaced := make(map[string]bool)
+			for _, j := range append([]*job(nil), jobs...) {
+				if j.index < 0 {
+					continue
+				}
+				f, ok := fresh[j.Name]
+				switch {
+				case !ok:
+					removed = append(removed, j.Name)
+				case f.definition == j.definition:
+					j.index, j.expanded = f.index, f.expanded
+					delete(fresh, j.Name)
+					continue
+				default:
+					changed = append(changed, j.Name)
+					replaced[j.Name] = true
+				}
+				removeJob(j, true)
+				removeJobStatus(sf, j.id)
+			}
+			remaining := removedJobs[:0]
+			for _, name := range removedJobs {
+				if _, ok := fresh[name]; !ok {
+					remaining = append(remaining, name)
+				}
+			}
+			removedJobs = remaining
+			for _, j := range reloaded.Jobs {
+				if fresh[j.Name] != j {
+					continue
+				}
+				if jobNamed(j.Name) != nil {
+					log.Print(colorize("Error:", ansiBold, ansiRed), " reloading ", jobsFile, ": job ", j.Name, " not added, there already is a job with that name added at runtime")
+					continue
+				}
+				if err := scheduleJob(j); err != nil {
+					log.Print(colorize("Error:", ansiBold, ansiRed), " reloading ", jobsFile, ": job ", j.Name, ": ", err)
+					continue
+				}
+				addJobStatus(sf, c, j)
+				if !replaced[j.Name] {
+					added = append(added, j.Name)
+				}
+			}
+			// The jobs changed keep their turn with -fair, those removed
+			// are forgotten.
+			d.retain(jobs)
+			count := func(names []string, what string) string {
+				if len(names) == 0 {
+					return "0 " + what
+				}
+				return fmt.Sprintf("%d %s (%s)", len(names), what, strings.Join(names, ", "))
+			}
+			detail := count(added, "added") + ", " + count(changed, "changed") + ", " + count(removed, "removed")
+			log.Printf("Reloaded %s, %s", jobsFile, detail)
+			audit.record(auditEntry{Event: auditReload, Detail: detail})
+		}
+		ctl.query(statusCommand, func(req controlRequest) (*daemonStatus, error) {
+			status := sf.snapshot()
+			return &status, nil
+		})
+		ctl.handle(stopCommand, func(req controlRequest) error {
+			log.Printf("Stopping, requested by %s", controlPeer(req))
+			select {
+			case exiting <- "stopped by " + controlPeer(req):
+			default:
+			}
+			return nil
+		})
+		go ctl.serve()
+		startDetail := fmt.Sprintf("version %s, %d job(s), %s", version, len(jobs), strings.Join(os.Args, " "))
+		if takeover != nil {
+			startDetail += fmt.Sprintf(", taking over from PID %d", takeover.PID)
+			if err := reportTakeover(); err != nil {
+				fatalLog(err)
+			}
+			log.Printf("Took over from PID %d", takeover.PID)
+		}
+		audit.record(auditEntry{Event: auditStart, Detail: startDetail})
+		if splay > 0 {
+			log.Printf("Schedules are offset by %s (-%s %s)", splay, splayFlag, *splayWindow)
+		}
+		jobsMu.Lock()
+		for _, j := range jobs {
+			if err := j.watch(d); err != nil {
+				fatalLog(fmt.Sprintf("job %s: %v", j.Name, err))
+			}
+		}
+		jobsMu.Unlock()
+		// skipMissed skips the runs that came due and returns the names of
+		// their jobs.
+		skipMissed := func() []string {
+			skipped := c.SkipMissed()
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			var names []string
+			for _, entry := range skipped {
+				for _, j := range jobs {
+					if j.id == entry.ID {
+						names = append(names, j.Name)
+						updateJobStatus(sf, c, j.id)
+					}
+				}
+			}
+			return names
+		}
+		// catchUp makes up for every run that came due like a backfill,
+		// one run after the other per job.
+		catchUp := func() {
+			now := c.Clock().Now()
+			skipped := c.SkipMissed()
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			for _, entry := range skipped {
+				for _, j := range jobs {
+					if j.id != entry.ID {
+						continue
+					}
+					updateJobStatus(sf, c, j.id)
+					times, err := backfillTimes(entry.Schedule, entry.Next, now.Add(time.Nanosecond))
+					if err != nil {
+						j.logger.Print(colorize("Error:", ansiBold, ansiRed), " catching up: ", err)
+						continue
+					}
+					go func(j *job) {
+						for _, t := range times {
+							d.runAndWait(fire{j: j, scheduled: t, params: []string{scheduledTimeEnvVar + "=" + t.Format(time.RFC3339)}})
+						}
+					}(j)
+				}
+			}
+		}
+		onJump = func(jump time.Duration) {
+			suspended, stepped := jumps.split(jump)
+			// Whatever is still due when this returns is run by the
+			// scheduler.
+			if suspended > jumpThreshold {
+				suspended = suspended.Round(time.Second)
+				if *runOnResume {
+					log.Printf("Resumed after about %s, running the jobs that came due while suspended", suspended)
+				} else if names := skipMissed(); len(names) > 0 {
+					log.Printf("Resumed after about %s, skipped the runs that came due while suspended: %s", suspended, strings.Join(names, ", "))
+				} else {
+					log.Printf("Resumed after about %s", suspended)
+				}
+			}
+			switch stepped = stepped.Round(time.Second); {
+			case stepped < -jumpThreshold:
+				log.Printf("Clock stepped back by %s, no runs are repeated", -stepped)
+			case stepped <= jumpThreshold:
+			case jumpPolicy == clockJumpOnce:
+				log.Printf("Clock stepped forward by %s, running the jobs that came due once", stepped)
+			case jumpPolicy == clockJumpCatchUp:
+				log.Printf("Clock stepped forward by %s, catching up on the runs that came due", stepped)
+				catchUp()
+			default:
+				if names := skipMissed(); len(names) > 0 {
+					log.Printf("Clock stepped forward by %s, skipped the runs that came due: %s", stepped, strings.Join(names, ", "))
+				} else {
+					log.Printf("Clock stepped forward by %s", stepped)
+				}
+			}
+		}
+		jobRunner.maxRunsReached = func() {
+			log.Printf("Reached -%s %d, exiting when the running jobs have finished", maxRunsFlag, *maxRuns)
+			go func() {
+				jobsMu.Lock()
+				c.Stop()
+				for _, j := range jobs {
+					j.unwatch()
+				}
+				jobsMu.Unlock()
+				d.drain()
+				select {
+				case exiting <- fmt.Sprintf("-%s %d reached", maxRunsFlag, *maxRuns):
+				default:
+				}
+			}()
+		}
+		// Start cron, it runs the jobs in goroutines of its own as does
+		// the control socket, the main loop only wakes up to exit.
+		c.StartAt(next)
+		jobsMu.Lock()
+		for _, j := range jobs {
+			updateJobStatus(sf, c, j.id)
+		}
+		// With -now every job, otherwise the jobs scheduled @reboot,
+		// are run once at startup (but not again by a process taking
+		// over after an upgrade) as if requested using `cronolize run`,
+		// so without -jitter.
+		for _, j := range jobs {
+			if takeover != nil || !*runAtStart && !isReboot(j.spec()) {
+				continue
+			}
+			if quiet < quietRuns {
+				j.logger.Print("Running at startup")
+			}
+			d.runNow(j, nil)
+		}
+		jobsMu.Unlock()
+		if cd != nil {
+			cd.start()
+		}
+		// With -until, the daemon exits once it has passed.
+		var untilPassed <-chan time.Time
+		if !until.IsZero() {
+			untilPassed = clock.NewTimer(until.Sub(clock.Now())).C()
+		}
 		for {
-			time.Sleep(time.Duration(math.MaxInt64))
+			select {
+			case sig := <-sigs:
+				exitOnSignal(sig)
+			case <-untilPassed:
+				log.Printf("Exiting, -%s %s has passed", untilFlag, *untilTime)
+				setExitReason("-" + untilFlag + " " + *untilTime + " passed")
+				runAtExit()
+				os.Exit(0)
+			case <-hup:
+				reload()
+			case reason := <-exiting:
+				setExitReason(reason)
+				runAtExit()
+				os.Exit(0)
+			}
 		}
 	}
 
-	// Set the environment variable that signal the next execution to start cron
-	// and wait forever instead of executing itself.
-	err = os.Setenv(cronolizerEnvVar, envVarValueExpected)
-	if err != nil {
-		fatal(err)
-	}
-
-	// Run myself again with the same arguments, but with the added environment variable.
-	cmd := exec.Command(os.Args[0], os.Args[1:]...)
-	cmd.Stdin = nil
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	err = cmd.Start()
+	// Run myself again with the same arguments, but with the environment
+	// variable that signals the next execution to start cron and wait
+	// forever instead of executing itself.
+	pid, err := cronolizer.Daemonize()
 	if err != nil {
 		fatal(err)
 	}
-	if !*quiet {
-		p("Running cron job as PID %d", cmd.Process.Pid)
+	if quiet < quietPID {
+		p("Running cron job as PID %d", pid)
 	}
 }
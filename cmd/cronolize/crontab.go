@@ -0,0 +1,176 @@
+package main
+
+// Crontab mode (-crontab FILE) runs the jobs of a crontab(5) file in a single
+// daemon, as an alternative to a YAML config. Environment lines apply to the
+// jobs that follow them, like in cron:
+//
+//	SHELL=/bin/bash
+//	PATH=/usr/local/bin:/usr/bin:/bin
+//	CRON_TZ=Europe/Stockholm
+//	MAILTO=ops@example.com
+//	# m h dom mon dow command
+//	*/15 * * * * find /tmp -mtime +1 -delete
+//	@hourly logrotate /etc/logrotate.conf
+//
+// SHELL sets the shell the following jobs are run with, CRON_TZ the time zone
+// their schedules are interpreted in and MAILTO where their output is mailed
+// (an empty MAILTO turns mailing off again). Every assignment except CRON_TZ
+// is also set in the environment of the following jobs, so PATH and any other
+// variable work as expected. Jobs are named after their position in the file,
+// starting at 1.
+//
+// With -system-crontab the file is in the format of /etc/crontab and
+// /etc/cron.d where a user column follows the schedule, the job is run as that
+// user which requires the daemon to run as root:
+//
+//	17 * * * * root cd / && run-parts --report /etc/cron.hourly
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	crontabFlag       string = "crontab"
+	systemCrontabFlag string = "system-crontab"
+)
+
+// crontabEnvLine matches a NAME=value line, the value may be quoted.
+var crontabEnvLine = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// loadCrontab reads a crontab file into a config, a system crontab if system
+// is true.
+func loadCrontab(path string, system bool) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cfg config
+	var shell, mailTo, tz string
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if m := crontabEnvLine.FindStringSubmatch(line); m != nil {
+			name, value := m[1], unquoteCrontabValue(strings.TrimSpace(m[2]))
+			switch name {
+			case "CRON_TZ":
+				if len(value) > 0 {
+					if _, err := time.LoadLocation(value); err != nil {
+						return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+					}
+				}
+				tz = value
+				continue
+			case "SHELL":
+				shell = value
+			case "MAILTO":
+				mailTo = value
+			}
+			env = setEnv(env, name, value)
+			continue
+		}
+		schedule, username, command, err := splitCrontabLine(line, system)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if len(tz) > 0 {
+			schedule = "CRON_TZ=" + tz + " " + schedule
+		}
+		cfg.Jobs = append(cfg.Jobs, &job{
+			Schedule: schedule,
+			Command:  command,
+			Shell:    shell,
+			MailTo:   mailTo,
+			Env:      append([]string(nil), env...),
+			User:     username,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// splitCrontabLine splits a job line into its schedule (five fields, six with
+// -seconds, a descriptor such as @daily or @every 5m, or a recurrence rule
+// optionally preceded by DTSTART), user (only if system is true) and command.
+func splitCrontabLine(line string, system bool) (schedule, username, command string, err error) {
+	n := 5
+	if withSeconds {
+		n = 6
+	}
+	switch {
+	case strings.HasPrefix(line, "@every"):
+		n = 2
+	case strings.HasPrefix(line, "@"):
+		n = 1
+	case strings.HasPrefix(strings.ToUpper(line), "DTSTART"):
+		n = 2
+	case strings.HasPrefix(strings.ToUpper(line), "RRULE:"):
+		n = 1
+	}
+	if system {
+		n++
+	}
+	fields, command, ok := splitFields(line, n)
+	if !ok {
+		if system {
+			return "", "", "", fmt.Errorf("expected a schedule, a user and a command: %s", line)
+		}
+		return "", "", "", fmt.Errorf("expected a schedule and a command: %s", line)
+	}
+	if system {
+		username = fields[n-1]
+		fields = fields[:n-1]
+	}
+	return strings.Join(fields, " "), username, command, nil
+}
+
+// splitFields splits the first n whitespace separated fields off line and
+// returns them and the rest of the line, ok is false if the rest is empty.
+func splitFields(line string, n int) (fields []string, rest string, ok bool) {
+	rest = line
+	for len(fields) < n {
+		rest = strings.TrimLeft(rest, " \t")
+		i := strings.IndexAny(rest, " \t")
+		if i < 0 {
+			return nil, "", false
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i:]
+	}
+	rest = strings.TrimSpace(rest)
+	return fields, rest, len(rest) > 0
+}
+
+// unquoteCrontabValue removes matching single or double quotes around value.
+func unquoteCrontabValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// setEnv sets name to value in env (a list of NAME=value), replacing an
+// earlier assignment.
+func setEnv(env []string, name, value string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, name+"=") {
+			out = append(out, kv)
+		}
+	}
+	return append(out, name+"="+value)
+}
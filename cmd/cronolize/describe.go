@@ -0,0 +1,234 @@
+package main
+
+// `cronolize describe` lists every field of a config file with its type,
+// default and allowed values. With -json it prints the same model as a JSON
+// Schema, which editors and other tools can use for completion and
+// validation, e.g. with the YAML language server:
+//
+//	cronolize describe -json > cronolize.schema.json
+//	# yaml-language-server: $schema=cronolize.schema.json
+//
+// The model is derived from the config types by reflection, so a field can
+// not be missing from it, the descriptions, defaults and allowed values come
+// from fieldDocs below.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const (
+	describeCommand string = "describe"
+	jsonSchemaDraft string = "http://json-schema.org/draft-07/schema#"
+)
+
+// fieldDoc documents a field of a config file.
+type fieldDoc struct {
+	description string
+	def         any
+	enum        []any
+}
+
+// fieldDocs are keyed by the name of the Go type and the YAML name of the
+// field.
+var fieldDocs = map[string]fieldDoc{
+	"config.log":  {description: "Log file of jobs without a log of their own (-log takes precedence)"},
+	"config.jobs": {description: "The jobs run by the daemon"},
+
+	"job.name":               {description: "Name of the job, its position in the jobs list (from 1) if empty"},
+	"job.schedule":           {description: "Five field cron expression (six with -seconds, an OnCalendar expression with -calendar or a Quartz expression with -quartz) descriptor such as @daily, @reboot or @civil-dusk, or iCalendar recurrence rule such as RRULE:FREQ=MONTHLY;BYDAY=-1FR, may be empty for a job with watch"},
+	"job.command":            {description: "Command run by the shell"},
+	"job.log":                {description: "Log file of the job's output"},
+	"job.tags":               {description: "Tags to select the job by in list, status, enable, disable and run, and a label of its metrics"},
+	"job.enabled":            {description: "Whether the job runs when it fires", def: true},
+	"job.priority":           {description: "Jobs firing at the same time start in priority order, highest first", def: 0},
+	"job.shell":              {description: "Shell running the command", def: "$SHELL or " + defaultShell},
+	"job.env":                {description: "KEY=VALUE added to the environment of every run"},
+	"job.mailto":             {description: "Mail the output of every run to these comma separated addresses"},
+	"job.user":               {description: "User the job runs as (requires root)"},
+	"job.group":              {description: "Group the job runs as (requires root)"},
+	"job.pushgateway":        {description: "Prometheus Pushgateway URL, overrides -pushgateway"},
+	"job.mail_failures":      {description: "Comma separated addresses to mail failure reports to, overrides -mail-failures"},
+	"job.max_memory":         {description: "Kill a run using more than this size of memory, e.g. 512M, overrides -max-memory"},
+	"job.timeout":            {description: "Kill a run taking longer than this, e.g. 2h, overrides -timeout"},
+	"job.jitter":             {description: "Wait a random time up to this long before every scheduled run, e.g. 5m, overrides -jitter (0 turns it off)"},
+	"job.overlap":            {description: "What to do when the job fires while a run of it has not finished, overrides -overlap", def: string(cronolizer.OverlapAllow), enum: []any{"allow", "skip", "queue", "kill"}},
+	"job.retries":            {description: "Run a failed run again up to this many times before it counts as failed, overrides -retries"},
+	"job.retry_backoff":      {description: "How long to wait before the first retry, doubled for every retry, overrides -retry-backoff"},
+	"job.min_free_disk":      {description: "Skip runs unless SIZE is available on the filesystem of PATH, e.g. 5G /backups"},
+	"job.preconditions":      {description: "Checks that must pass before a run, runs are skipped otherwise"},
+	"job.postconditions":     {description: "Checks that must pass after a run exited 0, the run fails otherwise"},
+	"job.wait_for_network":   {description: "Defer runs until host:port, an http(s) URL or any network is reachable"},
+	"job.network_wait":       {description: "How long to wait for the network", def: defaultNetworkWait.String()},
+	"job.wasm":               {description: "WASI module run instead of command"},
+	"job.wasm_args":          {description: "Arguments of the WASI module"},
+	"job.wasm_mounts":        {description: "Directories the WASI module can access, HOST[:GUEST][:ro]"},
+	"job.metrics":            {description: "Values extracted from the output of every run"},
+	"job.cpuset":             {description: "CPUs the job's processes are pinned to, e.g. 0-3,6 (Linux only)"},
+	"job.sched_policy":       {description: "Scheduling policy of the job's processes (Linux only)", def: "other", enum: []any{"other", "batch", "idle"}},
+	"job.systemd_scope":      {description: "Run every run in a transient systemd scope", def: false},
+	"job.systemd_properties": {description: "NAME=VALUE properties of the systemd scope, e.g. MemoryMax=2G"},
+	"job.require_ntp_sync":   {description: "Skip runs while the system clock is not synchronized (Linux only)", def: false},
+	"job.only_on_ac":         {description: "Skip runs while on battery", def: false},
+	"job.min_battery":        {description: "Skip runs while on battery with less charge left, e.g. 40%"},
+	"job.max_load":           {description: "Postpone runs while the 1 minute load average is higher (0 is unlimited)", def: 0},
+	"job.load_wait":          {description: "How long to postpone runs for the load to come down, skipped right away if empty"},
+	"job.watch":              {description: "File or directory whose changes run the job"},
+	"job.debounce":           {description: "How long after the last change of watch the job is run", def: defaultDebounce.String()},
+	"job.matrix":             {description: "Expand the job into one job per value, {{NAME}} is replaced by the value of NAME"},
+
+	"condition.file_exists":   {description: "The file must exist"},
+	"condition.file_newer":    {description: "The file must have been modified since the run started (postconditions only)"},
+	"condition.tcp":           {description: "A TCP connection to host:port must succeed"},
+	"condition.ping":          {description: "The host must answer ping"},
+	"condition.command":       {description: "The command must exit 0"},
+	"condition.min_free_disk": {description: "SIZE must be available on the filesystem of PATH, e.g. 5G /backups"},
+
+	"metricExtractor.name":  {description: "Name of the metric"},
+	"metricExtractor.help":  {description: "Help text of the metric"},
+	"metricExtractor.regex": {description: "Extract the first group (or the match) of this regular expression"},
+	"metricExtractor.json":  {description: "Extract the value at this path of a line that is a JSON object, e.g. stats.bytes"},
+}
+
+// schemaNode is a JSON Schema, or a part of one.
+type schemaNode struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Default              any                    `json:"default,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Properties           map[string]*schemaNode `json:"properties,omitempty"`
+	Items                *schemaNode            `json:"items,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+
+	// order is the order of the properties in the Go type.
+	order []string
+}
+
+// configSchema returns the schema of a config file.
+func configSchema() *schemaNode {
+	schema := typeSchema(reflect.TypeOf(config{}))
+	schema.Schema = jsonSchemaDraft
+	schema.Title = "cronolize config"
+	// The matrix is expanded before the jobs are decoded, see matrix.go.
+	jobs := schema.Properties["jobs"].Items
+	matrix := &schemaNode{
+		Type:                 "object",
+		AdditionalProperties: &schemaNode{Type: "array", Items: &schemaNode{Type: "string"}},
+	}
+	matrix.describe("job", matrixKey)
+	jobs.Properties[matrixKey] = matrix
+	jobs.order = append(jobs.order, matrixKey)
+	return schema
+}
+
+// typeSchema returns the schema of values of t.
+func typeSchema(t reflect.Type) *schemaNode {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return &schemaNode{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &schemaNode{Type: "integer"}
+	case reflect.Float64:
+		return &schemaNode{Type: "number"}
+	case reflect.Slice:
+		return &schemaNode{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Struct:
+		node := &schemaNode{Type: "object", Properties: make(map[string]*schemaNode), AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if len(name) == 0 || name == "-" {
+				continue
+			}
+			field := typeSchema(t.Field(i).Type)
+			field.describe(t.Name(), name)
+			node.Properties[name] = field
+			node.order = append(node.order, name)
+		}
+		return node
+	}
+	return &schemaNode{Type: "string"}
+}
+
+// describe sets the description, default and allowed values of the field name
+// of the Go type typeName.
+func (n *schemaNode) describe(typeName, name string) {
+	doc := fieldDocs[typeName+"."+name]
+	n.Description, n.Default, n.Enum = doc.description, doc.def, doc.enum
+}
+
+// typeName returns the type of n as shown by describe, e.g. array of string.
+func (n *schemaNode) typeName() string {
+	switch {
+	case n.Items != nil:
+		return "array of " + n.Items.typeName()
+	case n.Type == "object" && n.Properties == nil:
+		if values, ok := n.AdditionalProperties.(*schemaNode); ok {
+			return "map of " + values.typeName()
+		}
+	}
+	return n.Type
+}
+
+// printFields prints a line for every field of n and the objects in it, their
+// names prefixed by prefix.
+func (n *schemaNode) printFields(tw *tabwriter.Writer, prefix string) {
+	if n.Items != nil {
+		n.Items.printFields(tw, prefix+"[]")
+		return
+	}
+	for _, name := range n.order {
+		field := n.Properties[name]
+		path := name
+		if len(prefix) > 0 {
+			path = prefix + "." + name
+		}
+		def := "-"
+		if field.Default != nil {
+			def = fmt.Sprint(field.Default)
+		}
+		description := field.Description
+		if len(field.Enum) > 0 {
+			values := make([]string, len(field.Enum))
+			for i, v := range field.Enum {
+				values[i] = fmt.Sprint(v)
+			}
+			description += " (" + strings.Join(values, ", ") + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", path, field.typeName(), def, description)
+		field.printFields(tw, path)
+	}
+}
+
+// describeCmd implements `cronolize describe [-json]`.
+func describeCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(describeCommand, flag.ExitOnError)
+	jsonOutput := cmdFlags.Bool("json", false, "Print the config model as a JSON Schema")
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-json]", os.Args[0], describeCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	schema := configSchema()
+	if *jsonOutput {
+		printJSON(schema)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tTYPE\tDEFAULT\tDESCRIPTION")
+	schema.printFields(tw, "")
+	tw.Flush()
+}
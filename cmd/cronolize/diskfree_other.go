@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// freeDiskSpace is not supported, min_free_disk is only checked on Linux,
+// macOS and FreeBSD.
+func freeDiskSpace(path string) (int64, error) {
+	return 0, errors.New("checking free disk space is not supported on this platform")
+}
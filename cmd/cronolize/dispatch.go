@@ -0,0 +1,352 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// dispatchWindow is how long the dispatcher collects fires after the first
+// one before starting them, jobs scheduled at the same instant are fired by
+// the scheduler within microseconds of each other.
+const dispatchWindow time.Duration = 100 * time.Millisecond
+
+const fairFlag string = "fair"
+
+// fire is a run of a job handed to the dispatcher. scheduled is when the
+// schedule fired it (or the time a backfilled run stands in for), zero for
+// runs triggered otherwise (by a watched path or `cronolize run`). params are added to the environment of a run requested
+// using `cronolize run`.
+type fire struct {
+	j         *job
+	scheduled time.Time
+	params    []string
+	// attempt is the number of times the run has been retried, see
+	// retries.go.
+	attempt int
+	// requested is true for runs not fired by the scheduler.
+	requested bool
+	// jittered is set once the run has waited for its jitter, locked
+	// while it is in the overlap of the job, see runner.go.
+	jittered bool
+	locked   bool
+}
+
+// runFunc runs a job and calls started once the job's process has been
+// started (or when it returns without starting one). It returns a rerun to
+// have the dispatcher run it again later.
+type runFunc func(f fire, started func()) *rerun
+
+// rerun is a run to be run again after delay, or once wait has returned if
+// it is not nil. A worker is not held up meanwhile, the run is queued again
+// when due (regardless of -queue-limit, it was queued already).
+type rerun struct {
+	fire
+	delay time.Duration
+	wait  func()
+}
+
+// poolStats are the worker pool metrics exposed in the status file.
+type poolStats struct {
+	Running int
+	Queued  int
+	Dropped int
+}
+
+// dispatcher receives jobs fired by the scheduler and starts jobs fired at the
+// same instant in priority order (highest first, ties in config order)
+// instead of in whatever order the scheduler's goroutines happen to run. With
+// serialize, each priority level runs to completion before the next (lower)
+// level is started.
+//
+// With workers > 0, runs are executed by a fixed number of worker goroutines
+// reading from a queue holding at most queueLimit runs (0 is unlimited), runs
+// fired when the queue is full are dropped. Otherwise every run gets a
+// goroutine of its own. A run waiting for something (a rerun) gives up its
+// worker and is queued again when it is due. With fair, the workers pick the queued runs
+// round-robin per job instead of in queue order: the next run is one of the
+// job that got a worker least recently (ties in queue order), so the longer a
+// job has waited the sooner it is picked and a frequently firing job can not
+// starve the others.
+type dispatcher struct {
+	fires      chan fire
+	window     time.Duration
+	serialize  bool
+	run        runFunc
+	queueLimit int
+	fair       bool
+	onChange   func(poolStats)
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue []*task
+	stats poolStats
+	// pending is the number of submitted runs that have not finished (or
+	// been dropped) yet, idle is signalled when it drops to 0.
+	pending int
+	idle    *sync.Cond
+	// served is when (in picks) a worker last picked a run of each job by
+	// name, for fair. A job replaced by a reload keeps its turn.
+	served map[string]uint64
+	picks  uint64
+}
+
+// task is a queued run.
+type task struct {
+	fire
+	started func()
+	done    func()
+}
+
+// newDispatcher returns a dispatcher collecting fires for window, normally
+// dispatchWindow.
+func newDispatcher(run runFunc, window time.Duration, serialize bool, workers, queueLimit int, fair bool, onChange func(poolStats)) *dispatcher {
+	d := &dispatcher{
+		fires:      make(chan fire),
+		window:     window,
+		serialize:  serialize,
+		run:        run,
+		queueLimit: queueLimit,
+		fair:       fair,
+		onChange:   onChange,
+		served:     make(map[string]uint64),
+	}
+	d.idle = sync.NewCond(&d.mu)
+	if workers > 0 {
+		d.cond = sync.NewCond(&d.mu)
+		for i := 0; i < workers; i++ {
+			go d.worker()
+		}
+	}
+	go d.loop()
+	return d
+}
+
+// submit hands a fired job to the dispatcher, it is used as the scheduler's
+// job function with the time it was scheduled at.
+func (d *dispatcher) submit(j *job, scheduled time.Time) {
+	d.mu.Lock()
+	d.pending++
+	d.mu.Unlock()
+	d.fires <- fire{j: j, scheduled: scheduled}
+}
+
+// finished marks a submitted run as finished or dropped.
+func (d *dispatcher) finished() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending--
+	if d.pending == 0 {
+		d.idle.Broadcast()
+	}
+}
+
+// drain waits until all submitted runs have finished, the scheduler must have
+// been stopped first.
+func (d *dispatcher) drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.pending > 0 {
+		d.idle.Wait()
+	}
+}
+
+// runNow runs j right away with params added to its environment, outside of
+// its schedule but still by the workers if there is a pool.
+func (d *dispatcher) runNow(j *job, params []string) {
+	d.mu.Lock()
+	d.pending++
+	d.mu.Unlock()
+	go d.start(fire{j: j, params: params, requested: true}, &sync.WaitGroup{})
+}
+
+// runAndWait runs f like runNow and returns when the run has finished (or
+// been dropped).
+func (d *dispatcher) runAndWait(f fire) {
+	d.mu.Lock()
+	d.pending++
+	d.mu.Unlock()
+	var wg sync.WaitGroup
+	f.requested = true
+	d.start(f, &wg)
+	wg.Wait()
+}
+
+func (d *dispatcher) loop() {
+	for f := range d.fires {
+		batch := []fire{f}
+		timer := time.NewTimer(d.window)
+	collect:
+		for {
+			select {
+			case f := <-d.fires:
+				batch = append(batch, f)
+			case <-timer.C:
+				break collect
+			}
+		}
+		go d.dispatch(batch)
+	}
+}
+
+func (d *dispatcher) dispatch(batch []fire) {
+	sort.SliceStable(batch, func(a, b int) bool {
+		if batch[a].j.Priority != batch[b].j.Priority {
+			return batch[a].j.Priority > batch[b].j.Priority
+		}
+		return batch[a].j.index < batch[b].j.index
+	})
+	var wg sync.WaitGroup
+	for i, f := range batch {
+		if d.serialize && i > 0 && f.j.Priority != batch[i-1].j.Priority {
+			wg.Wait()
+		}
+		d.start(f, &wg)
+	}
+}
+
+// start runs the fired job in a goroutine of its own and returns when its
+// process has started, or queues it for the workers which pick runs in queue
+// order.
+func (d *dispatcher) start(f fire, wg *sync.WaitGroup) {
+	started := make(chan struct{})
+	var once sync.Once
+	wg.Add(1)
+	t := &task{
+		fire:    f,
+		started: func() { once.Do(func() { close(started) }) },
+		done: func() {
+			wg.Done()
+			d.finished()
+		},
+	}
+	if d.cond == nil {
+		go d.execute(t)
+		<-started
+		return
+	}
+	if !d.enqueue(t) {
+		f.j.logger.Print(colorize("Dropped, the worker queue is full", ansiBold, ansiRed))
+		t.done()
+	}
+}
+
+// enqueue adds t to the queue unless it is full.
+func (d *dispatcher) enqueue(t *task) bool {
+	d.mu.Lock()
+	if d.queueLimit > 0 && len(d.queue) >= d.queueLimit {
+		d.stats.Dropped++
+		d.mu.Unlock()
+		d.changed()
+		return false
+	}
+	d.queue = append(d.queue, t)
+	d.stats.Queued = len(d.queue)
+	d.cond.Signal()
+	d.mu.Unlock()
+	d.changed()
+	return true
+}
+
+func (d *dispatcher) worker() {
+	for {
+		d.mu.Lock()
+		for len(d.queue) == 0 {
+			d.cond.Wait()
+		}
+		i := d.next()
+		t := d.queue[i]
+		copy(d.queue[i:], d.queue[i+1:])
+		d.queue[len(d.queue)-1] = nil
+		d.queue = d.queue[:len(d.queue)-1]
+		d.stats.Queued = len(d.queue)
+		d.mu.Unlock()
+		d.execute(t)
+	}
+}
+
+// next returns the index in the queue of the run to pick next, d.mu must be
+// held.
+func (d *dispatcher) next() int {
+	if !d.fair {
+		return 0
+	}
+	next := 0
+	for i, t := range d.queue {
+		if d.served[t.j.Name] < d.served[d.queue[next].j.Name] {
+			next = i
+		}
+	}
+	d.picks++
+	d.served[d.queue[next].j.Name] = d.picks
+	return next
+}
+
+// retain forgets when the jobs not in jobs were last served, it is called
+// when jobs have been removed.
+func (d *dispatcher) retain(jobs []*job) {
+	current := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		current[j.Name] = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.served {
+		if !current[name] {
+			delete(d.served, name)
+		}
+	}
+}
+
+func (d *dispatcher) execute(t *task) {
+	defer reportPanic()
+	defer t.started()
+	d.mu.Lock()
+	d.stats.Running++
+	d.mu.Unlock()
+	d.changed()
+	again := d.run(t.fire, t.started)
+	d.mu.Lock()
+	d.stats.Running--
+	d.mu.Unlock()
+	d.changed()
+	if again == nil {
+		t.done()
+		return
+	}
+	// The task is done once the rerun is.
+	t.fire = again.fire
+	if again.wait != nil {
+		go func() {
+			again.wait()
+			d.requeue(t)
+		}()
+		return
+	}
+	time.AfterFunc(again.delay, func() { d.requeue(t) })
+}
+
+// requeue runs t again, it was already accepted into the queue (if there is
+// one) once.
+func (d *dispatcher) requeue(t *task) {
+	if d.cond == nil {
+		go d.execute(t)
+		return
+	}
+	d.mu.Lock()
+	d.queue = append(d.queue, t)
+	d.stats.Queued = len(d.queue)
+	d.cond.Signal()
+	d.mu.Unlock()
+	d.changed()
+}
+
+func (d *dispatcher) changed() {
+	if d.onChange == nil {
+		return
+	}
+	d.mu.Lock()
+	stats := d.stats
+	d.mu.Unlock()
+	d.onChange(stats)
+}
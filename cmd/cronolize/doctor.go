@@ -0,0 +1,353 @@
+package main
+
+// `cronolize doctor` checks the environment cronolize runs in for the
+// problems behind most support requests and prints what to do about them:
+//
+//	OK       shell: /bin/bash
+//	WARNING  runtime directory: /run/user/1000/cronolize is writable by others, run chmod 700 /run/user/1000/cronolize
+//	ERROR    timezone database: not found (unknown time zone Europe/Stockholm), install tzdata or set ZONEINFO (CRON_TZ= schedules fail without it)
+//
+// It checks the shell, the log, runtime and state directories, the timezone
+// database and the system clock, and looks for status files and control
+// sockets left behind by processes that are gone. With -config (or -crontab)
+// the jobs in the file are checked as on startup, including their shells and
+// log files. doctor exits 1 if it found an error.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	doctorCommand string = "doctor"
+	// zoneProbe is a zone looked up to tell if the timezone database is
+	// installed.
+	zoneProbe string = "Europe/Stockholm"
+)
+
+type doctorLevel int
+
+const (
+	doctorOK doctorLevel = iota
+	doctorWarning
+	doctorError
+)
+
+// label returns the level padded to the same width for all levels, colored
+// on a terminal.
+func (l doctorLevel) label() string {
+	switch l {
+	case doctorWarning:
+		return colorize("WARNING", ansiBold, ansiYellow) + "  "
+	case doctorError:
+		return colorize("ERROR", ansiBold, ansiRed) + "    "
+	}
+	return "OK       "
+}
+
+// doctorFinding is the result of a check.
+type doctorFinding struct {
+	level   doctorLevel
+	check   string
+	message string
+}
+
+// doctor collects the findings of the checks.
+type doctor struct {
+	findings []doctorFinding
+}
+
+func (d *doctor) report(level doctorLevel, check, format string, a ...any) {
+	d.findings = append(d.findings, doctorFinding{level: level, check: check, message: fmt.Sprintf(format, a...)})
+}
+
+// checkShell checks that shell can be run.
+func (d *doctor) checkShell(check, shell string) {
+	if _, err := exec.LookPath(shell); err != nil {
+		d.report(doctorError, check, "%s can not be run (%v), set SHELL or -shell to an installed shell", shell, err)
+		return
+	}
+	d.report(doctorOK, check, "%s", shell)
+}
+
+// checkDir checks that dir is a directory this user can write to and no one
+// else can, a directory that does not exist yet must be possible to create.
+func (d *doctor) checkDir(check, dir string) {
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		parent := filepath.Dir(dir)
+		for {
+			if _, err := os.Stat(parent); err == nil || parent == filepath.Dir(parent) {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		if err := checkWritable(parent); err != nil {
+			d.report(doctorError, check, "%s does not exist and can not be created (%v), create it or use -%s", dir, err, stateDirFlag)
+			return
+		}
+		d.report(doctorOK, check, "%s (created when needed)", dir)
+		return
+	}
+	if err != nil {
+		d.report(doctorError, check, "%v", err)
+		return
+	}
+	if !info.IsDir() {
+		d.report(doctorError, check, "%s is not a directory, remove it or use -%s", dir, stateDirFlag)
+		return
+	}
+	if dir == tempRuntimeDir() {
+		if err := makeRuntimeDir(dir); err != nil {
+			d.report(doctorError, check, "%v, remove it or use -%s", err, stateDirFlag)
+			return
+		}
+	}
+	if err := checkWritable(dir); err != nil {
+		d.report(doctorError, check, "%s is not writable (%v), fix its owner or permissions or use -%s", dir, err, stateDirFlag)
+		return
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() {
+		d.report(doctorWarning, check, "%s is owned by uid %d, not by you (uid %d), run chown %d %s", dir, st.Uid, os.Geteuid(), os.Geteuid(), dir)
+		return
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		d.report(doctorWarning, check, "%s is writable by others, run chmod 700 %s", dir, dir)
+		return
+	}
+	d.report(doctorOK, check, "%s", dir)
+}
+
+// checkWritable returns an error unless a file can be created in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, "."+appName+"-doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkLogFile checks that the log file path can be appended to.
+func (d *doctor) checkLogFile(check, path string) {
+	if path == os.DevNull {
+		d.report(doctorOK, check, "%s", path)
+		return
+	}
+	resolved, err := resolveLogPath(path)
+	if err != nil {
+		d.report(doctorError, check, "%s: %v", path, err)
+		return
+	}
+	if isLogPattern(resolved) {
+		// The files and directories of a pattern are created when
+		// needed, below the directory the pattern starts in.
+		dir := filepath.Dir(resolved[:strings.Index(resolved, "%")])
+		if err := checkWritable(dir); err != nil {
+			d.report(doctorError, check, "%s can not be created (%v), make sure %s exists and is writable", resolved, err, dir)
+			return
+		}
+		d.report(doctorOK, check, "%s (created when needed)", resolved)
+		return
+	}
+	f, err := os.OpenFile(resolved, os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := checkWritable(filepath.Dir(resolved)); err != nil {
+			d.report(doctorError, check, "%s can not be created (%v), make sure %s exists and is writable", resolved, err, filepath.Dir(resolved))
+			return
+		}
+		d.report(doctorOK, check, "%s (created when needed)", resolved)
+		return
+	}
+	if err != nil {
+		d.report(doctorError, check, "%s is not writable (%v), fix its owner or permissions", resolved, err)
+		return
+	}
+	f.Close()
+	d.report(doctorOK, check, "%s", resolved)
+}
+
+// checkTimezones checks that the timezone database is installed and that TZ,
+// if set, is a known zone.
+func (d *doctor) checkTimezones() {
+	const check = "timezone database"
+	if _, err := time.LoadLocation(zoneProbe); err != nil {
+		d.report(doctorError, check, "not found (%v), install tzdata or set ZONEINFO (CRON_TZ= schedules fail without it)", err)
+	} else {
+		d.report(doctorOK, check, "found, local time is %s", time.Now().Format("MST -07:00"))
+	}
+	if tz, ok := os.LookupEnv("TZ"); ok && len(tz) > 0 {
+		if _, err := time.LoadLocation(strings.TrimPrefix(tz, ":")); err != nil {
+			d.report(doctorWarning, "TZ", "%q is not a known zone (%v), schedules use UTC, fix or unset TZ", tz, err)
+		}
+	}
+}
+
+// checkClock checks that the clock is not obviously wrong and whether it is
+// synchronized.
+func (d *doctor) checkClock() {
+	const check = "clock"
+	now := time.Now()
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil && now.Before(info.ModTime().Add(-24*time.Hour)) {
+			d.report(doctorError, check, "%s is before %s was built or installed (%s), set the time or enable NTP",
+				now.Format(time.RFC3339), exe, info.ModTime().Format(time.RFC3339))
+			return
+		}
+	}
+	if !clockSyncSupported {
+		d.report(doctorOK, check, "%s", now.Format(time.RFC3339))
+		return
+	}
+	synced, err := clockSynchronized()
+	switch {
+	case err != nil:
+		d.report(doctorWarning, check, "unable to tell if the clock is synchronized: %v", err)
+	case !synced:
+		d.report(doctorWarning, check, "%s, not synchronized, enable NTP (e.g. timedatectl set-ntp true) or jobs with require_ntp_sync are skipped", now.Format(time.RFC3339))
+	default:
+		d.report(doctorOK, check, "%s, synchronized", now.Format(time.RFC3339))
+	}
+}
+
+// checkStaleFiles looks for status files, control sockets and temporary
+// files in dir left behind by processes that are gone.
+func (d *doctor) checkStaleFiles(dir string) {
+	const check = "stale files"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			d.report(doctorWarning, check, "%v", err)
+		}
+		return
+	}
+	var stale []string
+	for _, entry := range entries {
+		name := entry.Name()
+		var pidString string
+		switch {
+		case strings.HasPrefix(name, "."):
+			// Temporary files of writeFileAtomic, .PID.json.RANDOM.
+			pidString, _, _ = strings.Cut(strings.TrimPrefix(name, "."), ".")
+		case strings.HasSuffix(name, statusFileExt):
+			pidString = strings.TrimSuffix(name, statusFileExt)
+		case strings.HasSuffix(name, controlSocketExt):
+			pidString = strings.TrimSuffix(name, controlSocketExt)
+		default:
+			continue
+		}
+		pid, err := strconv.Atoi(pidString)
+		if err != nil || isAlive(pid) {
+			continue
+		}
+		stale = append(stale, filepath.Join(dir, name))
+	}
+	if len(stale) == 0 {
+		d.report(doctorOK, check, "none in %s", dir)
+		return
+	}
+	d.report(doctorWarning, check, "%d left behind by processes that are gone, run rm %s", len(stale), strings.Join(stale, " "))
+}
+
+// checkJobs checks the jobs in a config or crontab file as they are checked on
+// startup.
+func (d *doctor) checkJobs(cfg *config) {
+	if len(cfg.Log) > 0 {
+		d.checkLogFile("log", cfg.Log)
+	}
+	for _, j := range cfg.Jobs {
+		check := "job " + j.Name
+		if err := j.prepare(); err != nil {
+			d.report(doctorError, check, "%v", err)
+			continue
+		}
+		if len(j.Shell) > 0 {
+			d.checkShell(check+" shell", j.Shell)
+		}
+		if len(j.Log) > 0 {
+			d.checkLogFile(check+" log", j.Log)
+		}
+	}
+}
+
+// doctorCmd implements `cronolize doctor [-statedir directory] [-shell shell]
+// [-config file|-crontab file]`.
+func doctorCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(doctorCommand, flag.ExitOnError)
+	shell := cmdFlags.String("shell", "", "Check this shell instead of $SHELL or "+defaultShell)
+	configFile := cmdFlags.String(configFlag, "", "Also check the jobs in this YAML `file`")
+	crontabFile := cmdFlags.String(crontabFlag, "", "Also check the jobs in this crontab `file`")
+	addStateDirFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-statedir directory] [-shell shell] [-config file|-crontab file]", os.Args[0], doctorCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	if len(*configFile) > 0 && len(*crontabFile) > 0 {
+		fatalf("Syntax error: you can not combine the -%s and the -%s option.", configFlag, crontabFlag)
+	}
+	if len(stateDirOverride) > 0 {
+		dir, err := filepath.Abs(stateDirOverride)
+		if err != nil {
+			fatal(err)
+		}
+		stateDirOverride = dir
+	}
+	if isTerminal(os.Stdout) {
+		_, noColor := os.LookupEnv("NO_COLOR")
+		useColor = !noColor
+	}
+
+	d := &doctor{}
+	if len(*shell) == 0 {
+		*shell = os.Getenv("SHELL")
+	}
+	if len(*shell) == 0 {
+		*shell = defaultShell
+	}
+	d.checkShell("shell", *shell)
+	d.checkDir("runtime directory", runtimeDir())
+	if dir := stateDir(); dir != runtimeDir() {
+		d.checkDir("state directory", dir)
+	}
+	d.checkTimezones()
+	d.checkClock()
+	d.checkStaleFiles(runtimeDir())
+	var cfg *config
+	var err error
+	switch {
+	case len(*configFile) > 0:
+		cfg, err = loadConfig(*configFile)
+	case len(*crontabFile) > 0:
+		cfg, err = loadCrontab(*crontabFile, false)
+	}
+	if err != nil {
+		d.report(doctorError, "config", "%v", err)
+	} else if cfg != nil {
+		d.checkJobs(cfg)
+	}
+
+	failed := false
+	for _, f := range d.findings {
+		fmt.Printf("%s%s: %s\n", f.level.label(), f.check, f.message)
+		if f.level == doctorError {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
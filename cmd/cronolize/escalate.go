@@ -0,0 +1,93 @@
+package main
+
+// Escalation rules (-escalate CHANNEL=N, repeatable) hold back a channel's
+// failure alerts until a job has failed N times in a row, e.g. Teams on the
+// first failure but mail only after three. With -notify-recovery, a channel
+// that was alerted about a failure streak is told when the job succeeds
+// again.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	escalateFlag       string = "escalate"
+	notifyRecoveryFlag string = "notify-recovery"
+)
+
+// escalationRules is a flag.Value for -escalate, a map from channel (the
+// notifier's name in lower case with spaces replaced by dashes, e.g. mail,
+// sentry, teams or google-chat) to the number of consecutive failures
+// required before the channel is alerted.
+type escalationRules map[string]int
+
+func (e escalationRules) String() string {
+	rules := make([]string, 0, len(e))
+	for channel, n := range e {
+		rules = append(rules, channel+"="+strconv.Itoa(n))
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, ",")
+}
+
+func (e escalationRules) Set(s string) error {
+	for _, rule := range strings.Split(s, ",") {
+		channel, count, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		n, err := strconv.Atoi(count)
+		if !ok || len(channel) == 0 || err != nil || n < 1 {
+			return fmt.Errorf("invalid escalation rule %q, expected CHANNEL=N where N >= 1", rule)
+		}
+		e[channel] = n
+	}
+	return nil
+}
+
+// channelName returns the name of n used in escalation rules.
+func channelName(n notifier) string {
+	return strings.ReplaceAll(strings.ToLower(n.name()), " ", "-")
+}
+
+// escalatedNotifier alerts the wrapped notifier from the after:th consecutive
+// failure, and about a recovery only if the failure streak reached it.
+type escalatedNotifier struct {
+	notifier
+	after int
+}
+
+func (e escalatedNotifier) notify(r runResult) error {
+	switch {
+	case r.recovered && r.previousFailures < e.after:
+		return nil
+	case r.failed() && r.consecutiveFailures < e.after:
+		return nil
+	case r.failed() && r.consecutiveFailures == e.after:
+		// The first alert this channel gets about the streak is sent even
+		// if identical failures are being throttled.
+		r.suppressed = false
+	}
+	return e.notifier.notify(r)
+}
+
+// escalate wraps the notifiers that have an escalation rule and returns an
+// error if a rule names a channel that is not configured.
+func escalate(notifiers []notifier, rules escalationRules) ([]notifier, error) {
+	known := make(map[string]bool)
+	out := make([]notifier, 0, len(notifiers))
+	for _, n := range notifiers {
+		channel := channelName(n)
+		known[channel] = true
+		if after, ok := rules[channel]; ok && after > 1 {
+			n = escalatedNotifier{notifier: n, after: after}
+		}
+		out = append(out, n)
+	}
+	for channel := range rules {
+		if !known[channel] {
+			return nil, fmt.Errorf("-%s: no notification channel %q is configured", escalateFlag, channel)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+var (
+	atExitMu    sync.Mutex
+	atExitFuncs []func()
+	// exitReason says why the process is exiting, for atExit functions.
+	exitReason string
+)
+
+// atExit registers fn to be run when the process is interrupted, terminated
+// or exits via fatal(). Functions run in reverse order of registration.
+func atExit(fn func()) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	atExitFuncs = append(atExitFuncs, fn)
+}
+
+// getExitReason returns why the process is exiting, empty if unknown.
+func getExitReason() string {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	return exitReason
+}
+
+// setExitReason records why the process is exiting.
+func setExitReason(reason string) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	exitReason = reason
+}
+
+// runAtExit runs (and forgets) all functions registered with atExit().
+func runAtExit() {
+	atExitMu.Lock()
+	funcs := atExitFuncs
+	atExitFuncs = nil
+	atExitMu.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
+
+// exitOnSignal runs the atExit functions after sig was received, then
+// re-raises it to exit the way the process would have without handling it.
+func exitOnSignal(sig os.Signal) {
+	setExitReason("received " + sig.String())
+	runAtExit()
+	signal.Reset(sig)
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(sig)
+	}
+	time.Sleep(time.Second)
+	os.Exit(1)
+}
@@ -0,0 +1,386 @@
+package main
+
+// `cronolize explain cronSpec` describes a schedule in English, e.g. "37 13 *
+// * 1-5" is "At 13:37 on Monday through Friday", and warns about patterns
+// that are likely mistakes: the day of the month and of the week both being
+// restricted (cron runs the job when either matches), * as the minute of a
+// job meant to run once an hour, steps that do not divide their field evenly
+// and days that do not exist in the months given.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const explainCommand string = "explain"
+
+// cronField describes a field of a cron expression for explain.
+type cronField struct {
+	unit     string
+	min, max int
+	names    map[string]int
+	format   func(v int) string
+}
+
+var (
+	secondField = cronField{unit: "second", min: 0, max: 59, format: strconv.Itoa}
+	minuteField = cronField{unit: "minute", min: 0, max: 59, format: strconv.Itoa}
+	hourField   = cronField{unit: "hour", min: 0, max: 23, format: func(v int) string { return fmt.Sprintf("%02d:00", v) }}
+	domField    = cronField{unit: "day", min: 1, max: 31, format: strconv.Itoa}
+	monthField  = cronField{unit: "month", min: 1, max: 12, names: quartzMonths, format: func(v int) string { return time.Month(v).String() }}
+	dowField    = cronField{unit: "day", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}, format: func(v int) string { return time.Weekday(v % 7).String() }}
+)
+
+// cronItem is an element of a comma separated field: * or a value or range
+// (from and to), every step from the first.
+type cronItem struct {
+	all      bool
+	from, to int
+	step     int
+}
+
+func (f cronField) items(field string) []cronItem {
+	var items []cronItem
+	for _, s := range strings.Split(field, ",") {
+		s, step, stepped := strings.Cut(s, "/")
+		it := cronItem{step: 1}
+		if stepped {
+			it.step, _ = strconv.Atoi(step)
+		}
+		if s == "*" || s == "?" {
+			it.all, it.from, it.to = true, f.min, f.max
+			items = append(items, it)
+			continue
+		}
+		from, to, isRange := strings.Cut(s, "-")
+		it.from = f.value(from)
+		switch {
+		case isRange:
+			it.to = f.value(to)
+		case stepped:
+			it.to = f.max
+		default:
+			it.to = it.from
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+func (f cronField) value(s string) int {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v
+	}
+	v, _ := strconv.Atoi(s)
+	return v
+}
+
+// singles returns the values of a field that lists values only.
+func singles(items []cronItem) ([]int, bool) {
+	var values []int
+	for _, it := range items {
+		if it.all || it.from != it.to {
+			return nil, false
+		}
+		values = append(values, it.from)
+	}
+	return values, true
+}
+
+// describe describes the items of a field, e.g. "every 15 minutes" or
+// "minutes 0 and 30" (with of, "days 1 and 15 of the month"), and whether it
+// starts with every. Months, weekdays and hours are described by name only.
+func (f cronField) describe(items []cronItem, of string) (string, bool) {
+	named := f.names != nil || f.unit == "hour"
+	if values, ok := singles(items); ok {
+		names := joinEnglish(formatAll(values, f.format), "and")
+		if named {
+			return names, false
+		}
+		if len(values) > 1 {
+			return f.unit + "s " + names + of, false
+		}
+		return f.unit + " " + names + of, false
+	}
+	var phrases []string
+	for _, it := range items {
+		every := "every " + f.unit
+		if it.step > 1 {
+			every = fmt.Sprintf("every %d %ss", it.step, f.unit)
+		}
+		switch {
+		case it.all:
+			phrases = append(phrases, every+of)
+		case it.from == it.to && named:
+			phrases = append(phrases, f.format(it.from))
+		case it.from == it.to:
+			phrases = append(phrases, f.unit+" "+f.format(it.from))
+		case f.unit == "hour" && it.step == 1:
+			phrases = append(phrases, fmt.Sprintf("between %s and %02d:59", f.format(it.from), it.to))
+		case f.unit == "hour":
+			phrases = append(phrases, fmt.Sprintf("%s between %s and %02d:59", every, f.format(it.from), it.to))
+		case it.step == 1 && named:
+			phrases = append(phrases, f.format(it.from)+" through "+f.format(it.to))
+		case it.step == 1 && len(of) > 0:
+			phrases = append(phrases, fmt.Sprintf("%ss %s through %s%s", f.unit, f.format(it.from), f.format(it.to), of))
+		default:
+			phrases = append(phrases, fmt.Sprintf("%s%s from %s through %s", every, of, f.format(it.from), f.format(it.to)))
+		}
+	}
+	return joinEnglish(phrases, "and"), strings.HasPrefix(phrases[0], "every")
+}
+
+// clause describes the items of a field preceded by prefix, unless the
+// description starts with every or prefix is empty.
+func (f cronField) clause(prefix string, items []cronItem, of string) string {
+	phrase, every := f.describe(items, of)
+	if every || len(prefix) == 0 {
+		return phrase
+	}
+	return prefix + " " + phrase
+}
+
+// restricted reports whether a field is anything but *.
+func restricted(items []cronItem) bool {
+	return len(items) > 1 || !items[0].all || items[0].step > 1
+}
+
+// appendClause appends a clause about the days or months to sentence,
+// separated by a comma if it starts with every.
+func appendClause(sentence, clause string) string {
+	if strings.HasPrefix(clause, "every") {
+		return sentence + ", " + clause
+	}
+	return sentence + " " + clause
+}
+
+// joinEnglish joins words as "a, b and c".
+func joinEnglish(words []string, and string) string {
+	if len(words) < 2 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " " + and + " " + words[len(words)-1]
+}
+
+// explainSchedule describes spec in English and returns warnings about
+// likely mistakes in it.
+func explainSchedule(spec string) (string, []string, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("Every %s, counted from when the job was scheduled", d), nil, nil
+	}
+	if isReboot(spec) {
+		return "Once when cronolize starts, never again", nil, nil
+	}
+	if schedule, ok, err := parseAstroSchedule(spec, &geoLocation{}); ok {
+		if err != nil {
+			return "", nil, err
+		}
+		var offset time.Duration
+		if s, ok := schedule.(splaySchedule); ok {
+			schedule, offset = s.schedule, s.offset
+		}
+		when, event := "At", ""
+		switch s := schedule.(type) {
+		case elevationSchedule:
+			switch {
+			case s.elevation == sunriseElevation && s.rising:
+				event = "sunrise"
+			case s.elevation == sunriseElevation:
+				event = "sunset"
+			case s.rising:
+				when, event = "When", fmt.Sprintf("the sun rises above %g degrees", s.elevation)
+			default:
+				when, event = "When", fmt.Sprintf("the sun sets below %g degrees", s.elevation)
+			}
+		case moonPhaseSchedule:
+			for name, elongation := range moonPhases {
+				if elongation == s.elongation {
+					event = strings.ReplaceAll(name, "-", " ")
+				}
+			}
+		}
+		switch {
+		case offset > 0:
+			return fmt.Sprintf("%s after %s", offset, event), nil, nil
+		case offset < 0:
+			return fmt.Sprintf("%s before %s", -offset, event), nil, nil
+		}
+		return when + " " + event, nil, nil
+	}
+	parsed, err := astroParser{}.Parse(spec)
+	if err != nil {
+		return "", nil, err
+	}
+	schedule, ok := parsed.(*cron.SpecSchedule)
+	if !ok {
+		return "", nil, fmt.Errorf("%s can not be explained", spec)
+	}
+	fields := strings.Fields(spec)
+	var zone string
+	if strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=") {
+		_, zone, _ = strings.Cut(fields[0], "=")
+		fields = fields[1:]
+	}
+	if equivalent, ok := map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}[fields[0]]; ok {
+		fields = strings.Fields(equivalent)
+	}
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	seconds, minutes, hours := secondField.items(fields[0]), minuteField.items(fields[1]), hourField.items(fields[2])
+	days, months, weekdays := domField.items(fields[3]), monthField.items(fields[4]), dowField.items(fields[5])
+
+	var clauses []string
+	secondValues, secondsListed := singles(seconds)
+	minuteValues, minutesListed := singles(minutes)
+	hourValues, hoursListed := singles(hours)
+	if secondsListed && len(secondValues) == 1 && minutesListed && hoursListed && len(minuteValues)*len(hourValues) <= 6 {
+		var times []string
+		for _, h := range hourValues {
+			for _, m := range minuteValues {
+				t := fmt.Sprintf("%02d:%02d", h, m)
+				if secondValues[0] != 0 {
+					t += fmt.Sprintf(":%02d", secondValues[0])
+				}
+				times = append(times, t)
+			}
+		}
+		clauses = append(clauses, "at "+joinEnglish(times, "and"))
+	} else {
+		if !secondsListed || len(secondValues) != 1 || secondValues[0] != 0 {
+			clauses = append(clauses, secondField.clause("at", seconds, ""))
+		}
+		if restricted(minutes) || len(clauses) == 0 {
+			clauses = append(clauses, minuteField.clause("at", minutes, ""))
+		}
+		switch {
+		case !restricted(hours):
+		case hoursListed && len(hourValues) == 1:
+			clauses = append(clauses, fmt.Sprintf("between %s and %02d:59", hourField.format(hourValues[0]), hourValues[0]))
+		case hoursListed:
+			clauses = append(clauses, "during the "+joinEnglish(formatAll(hourValues, hourField.format), "and")+" hours")
+		default:
+			clauses = append(clauses, hourField.clause("", hours, ""))
+		}
+	}
+	sentence := strings.Join(clauses, ", ")
+	domRestricted := restricted(days)
+	dowRestricted := restricted(weekdays)
+	var dayClauses []string
+	if domRestricted {
+		dayClauses = append(dayClauses, domField.clause("on", days, " of the month"))
+	}
+	if dowRestricted {
+		dayClauses = append(dayClauses, dowField.clause("on", weekdays, " of the week"))
+	}
+	if len(dayClauses) > 0 {
+		sentence = appendClause(sentence, strings.Join(dayClauses, " or "))
+	}
+	if restricted(months) {
+		sentence = appendClause(sentence, monthField.clause("in", months, " of the year"))
+	}
+	if len(zone) > 0 {
+		sentence += " (" + zone + " time)"
+	}
+	sentence = strings.ToUpper(sentence[:1]) + sentence[1:]
+
+	var warnings []string
+	if domRestricted && dowRestricted {
+		warnings = append(warnings, "Both the day of the month and the day of the week are restricted, cron runs the job on days matching either of them, not only on days matching both.")
+	}
+	if !restricted(minutes) && restricted(hours) {
+		warnings = append(warnings, "The minute is *, so the job runs every minute of the hours given. Use 0 to run it once an hour.")
+	}
+	for _, f := range []struct {
+		field cronField
+		items []cronItem
+		of    string
+	}{{secondField, seconds, "minute"}, {minuteField, minutes, "hour"}, {hourField, hours, "day"}, {domField, days, "month"}} {
+		for _, it := range f.items {
+			if it.step > 1 && it.all && (f.field.max-f.field.min+1)%it.step != 0 {
+				warnings = append(warnings, fmt.Sprintf("A step of %d does not divide %d %ss evenly, the last interval of every %s is shorter.", it.step, f.field.max-f.field.min+1, f.field.unit, f.of))
+			}
+		}
+	}
+	if domRestricted && !dowRestricted {
+		var missing []string
+		for m := time.January; m <= time.December; m++ {
+			if schedule.Month&(1<<uint(m)) == 0 {
+				continue
+			}
+			// February has a 29th in leap years.
+			length := time.Date(2024, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+			if schedule.Dom&(1<<uint(length+1)-2) == 0 {
+				missing = append(missing, m.String())
+			}
+		}
+		switch {
+		case len(missing) == 0:
+		case len(missing) == bitCount(schedule.Month&(1<<13-2)):
+			warnings = append(warnings, "None of the months given have the days given, the job never runs.")
+		default:
+			warnings = append(warnings, fmt.Sprintf("The job does not run in %s, which do not have the days given.", joinEnglish(missing, "and")))
+		}
+	}
+	return sentence, warnings, nil
+}
+
+func formatAll(values []int, format func(int) string) []string {
+	var s []string
+	for _, v := range values {
+		s = append(s, format(v))
+	}
+	return s
+}
+
+func bitCount(bits uint64) int {
+	n := 0
+	for ; bits != 0; bits &= bits - 1 {
+		n++
+	}
+	return n
+}
+
+// explainCmd implements `cronolize explain [-seconds] cronSpec`.
+func explainCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(explainCommand, flag.ExitOnError)
+	cmdFlags.BoolVar(&withSeconds, secondsFlag, false, "Allow a seconds field before the minutes")
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-%s] cronSpec", os.Args[0], explainCommand, secondsFlag)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	sentence, warnings, err := explainSchedule(cmdFlags.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	p("%s", sentence)
+	for _, warning := range warnings {
+		p("%s %s", colorize("Warning:", ansiBold, ansiYellow), warning)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+// `cronolize freeze JOB` pauses a running job by stopping its command and
+// everything it started with SIGSTOP, e.g. to take the load of a heavy run off
+// the host during an incident without losing its progress. `cronolize thaw
+// JOB` continues it with SIGCONT. Like kill, -run-id selects the run if the
+// job is running more than once. Killing a frozen run thaws it so the signal
+// is delivered. On systems other than Linux, where the processes it started
+// can not be found, only those still in its process group are stopped.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	freezeCommand string = "freeze"
+	thawCommand   string = "thaw"
+)
+
+// freeze stops (or with frozen false continues) the run with runID of the job
+// named name, or the only run of the job if runID is 0, and returns it.
+func (a *activeRuns) freeze(name string, runID int, frozen bool) (*activeRun, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	run, err := a.find(name, runID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case frozen && run.frozen:
+		return nil, fmt.Errorf("run %d of job %q is already frozen", run.id, name)
+	case !frozen && !run.frozen:
+		return nil, fmt.Errorf("run %d of job %q is not frozen", run.id, name)
+	}
+	sig := syscall.SIGCONT
+	if frozen {
+		sig = syscall.SIGSTOP
+	}
+	if err := run.process.Signal(sig); err != nil {
+		return nil, err
+	}
+	run.frozen = frozen
+	return run, nil
+}
+
+// freezeJobCmd implements `cronolize freeze|thaw [-pid PID] [-run-id ID] JOB`.
+func freezeJobCmd(command string, args []string) {
+	cmdFlags := flag.NewFlagSet(command, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	runID := cmdFlags.Int("run-id", 0, "ID of the run to "+command+" as shown by running (if the job is running more than once)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] [-run-id ID] JOB", os.Args[0], command)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	if err := sendControl(status.ControlSocket, controlRequest{Command: command, Job: name, RunID: *runID}); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+// With -holidays FILE, runs scheduled on the days listed in FILE are left
+// out, or with -on-holiday next-business-day moved to the same time on the
+// next business day (Monday to Friday and not a holiday). FILE is either a
+// list of dates, one per line and optionally followed by a name,
+//
+//	# Public holidays
+//	2026-12-25 Christmas Day
+//	2026-12-26 Boxing Day
+//
+// or an iCalendar (.ics) file such as a holiday calendar exported from a
+// calendar application, where every event (VEVENT) is a holiday from its
+// DTSTART up to but not including its DTEND. The day of a run is the one in
+// the time zone of its schedule.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	holidaysFlag  string = "holidays"
+	onHolidayFlag string = "on-holiday"
+	holidayLayout string = "2006-01-02"
+	// maxHolidayStreak is the most days in a row that are searched for a
+	// business day.
+	maxHolidayStreak int = 366
+)
+
+type holidayPolicy string
+
+const (
+	holidaySkip            holidayPolicy = "skip"
+	holidayNextBusinessDay holidayPolicy = "next-business-day"
+)
+
+func parseHolidayPolicy(s string) (holidayPolicy, error) {
+	switch p := holidayPolicy(s); p {
+	case holidaySkip, holidayNextBusinessDay:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q is not skip or next-business-day", s)
+}
+
+// holidays is a set of days formatted using holidayLayout.
+type holidays map[string]bool
+
+func (h holidays) contains(t time.Time) bool {
+	return h[t.Format(holidayLayout)]
+}
+
+// isBusinessDay reports whether the day of t is Monday to Friday and not a
+// holiday.
+func (h holidays) isBusinessDay(t time.Time) bool {
+	weekday := t.Weekday()
+	return weekday != time.Saturday && weekday != time.Sunday && !h.contains(t)
+}
+
+// loadHolidays reads a list of dates or an iCalendar file.
+func loadHolidays(path string) (holidays, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	start, err := r.Peek(len("BEGIN:VCALENDAR"))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var h holidays
+	if strings.EqualFold(string(start), "BEGIN:VCALENDAR") {
+		h, err = parseICalHolidays(r)
+	} else {
+		h, err = parseHolidayList(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// parseHolidayList parses one date (YYYY-MM-DD) per line, followed by
+// anything such as the name of the holiday. Empty lines and lines starting
+// with # are ignored.
+func parseHolidayList(r io.Reader) (holidays, error) {
+	h := make(holidays)
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		day, err := time.Parse(holidayLayout, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q is not a date (YYYY-MM-DD)", lineNumber, fields[0])
+		}
+		h[day.Format(holidayLayout)] = true
+	}
+	return h, scanner.Err()
+}
+
+// parseICalHolidays adds the days of every event in an iCalendar file.
+func parseICalHolidays(r io.Reader) (holidays, error) {
+	h := make(holidays)
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Long lines are folded by starting the continuation with a
+		// space or a tab.
+		if len(lines) > 0 && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var inEvent bool
+	var start, end time.Time
+	for _, line := range lines {
+		name, value, _ := strings.Cut(line, ":")
+		// Parameters such as ;VALUE=DATE follow the property name.
+		name, _, _ = strings.Cut(strings.ToUpper(name), ";")
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent = true
+			start, end = time.Time{}, time.Time{}
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+			if start.IsZero() {
+				return nil, fmt.Errorf("event without DTSTART")
+			}
+			if !end.After(start) {
+				end = start.AddDate(0, 0, 1)
+			}
+			for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+				h[day.Format(holidayLayout)] = true
+			}
+		case inEvent && (name == "DTSTART" || name == "DTEND"):
+			// Only the date of a date-time such as 20261224T120000Z
+			// matters.
+			if len(value) < 8 {
+				return nil, fmt.Errorf("%s %q is not a date", name, value)
+			}
+			day, err := time.Parse("20060102", value[:8])
+			if err != nil {
+				return nil, fmt.Errorf("%s %q is not a date", name, value)
+			}
+			if name == "DTSTART" {
+				start = day
+			} else if len(value) > 8 {
+				// An event ending at a time of day includes that day.
+				end = day.AddDate(0, 0, 1)
+			} else {
+				end = day
+			}
+		}
+	}
+	return h, nil
+}
+
+// holidayParser parses the schedules of parser without the runs on holidays,
+// or with them moved to the next business day.
+type holidayParser struct {
+	parser   cron.ScheduleParser
+	holidays holidays
+	policy   holidayPolicy
+}
+
+func (p holidayParser) Parse(spec string) (cron.Schedule, error) {
+	schedule, err := p.parser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return holidaySchedule{schedule: schedule, holidays: p.holidays, policy: p.policy}, nil
+}
+
+type holidaySchedule struct {
+	schedule cron.Schedule
+	holidays holidays
+	policy   holidayPolicy
+}
+
+func (s holidaySchedule) Next(t time.Time) time.Time {
+	from := t
+	if s.policy == holidayNextBusinessDay {
+		// A run moved from a holiday before t may still be due after
+		// t, so the search starts on the first of the days off (holidays
+		// and weekends) leading up to the day of t (the day before, in
+		// case the schedule is in another time zone).
+		from = time.Date(t.Year(), t.Month(), t.Day()-1, 0, 0, 0, 0, t.Location())
+		for i := 0; i < maxHolidayStreak && !s.holidays.isBusinessDay(from.AddDate(0, 0, -1)); i++ {
+			from = from.AddDate(0, 0, -1)
+		}
+		from = from.Add(-time.Second)
+	}
+	var moved time.Time
+	for next := s.schedule.Next(from); !next.IsZero() && (moved.IsZero() || next.Before(moved)); next = s.schedule.Next(next) {
+		if !s.holidays.contains(next) {
+			if next.After(t) {
+				return next
+			}
+			continue
+		}
+		if s.policy != holidayNextBusinessDay {
+			continue
+		}
+		if m := s.nextBusinessDay(next); m.After(t) && (moved.IsZero() || m.Before(moved)) {
+			moved = m
+		}
+	}
+	return moved
+}
+
+// nextBusinessDay returns the time of day of t on the first business day
+// after it, zero if there is none within maxHolidayStreak days.
+func (s holidaySchedule) nextBusinessDay(t time.Time) time.Time {
+	for i := 1; i <= maxHolidayStreak; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+		if s.holidays.isBusinessDay(day) {
+			return day
+		}
+	}
+	return time.Time{}
+}
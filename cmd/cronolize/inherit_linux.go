@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// startInheriting starts cmd pinned to the CPUs in cpus and with the
+// scheduling policy, either of which may be nil. A child process inherits the
+// affinity and policy of the thread forking it, so they are set on this
+// thread while starting cmd and then restored. There is no window where the
+// process (or anything it starts) runs on other CPUs or with another policy.
+func startInheriting(cmd *exec.Cmd, cpus *cpuSet, policy *schedPolicy) error {
+	runtime.LockOSThread()
+	var undo []func() error
+	// A thread that could not be restored stays locked to this goroutine,
+	// and is terminated when the goroutine exits instead of being reused.
+	restore := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undo[i]() != nil {
+				return
+			}
+		}
+		runtime.UnlockOSThread()
+	}
+	if cpus != nil {
+		var old cpuSet
+		if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &old); err != nil {
+			restore()
+			return os.NewSyscallError("sched_getaffinity", err)
+		}
+		if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, cpus); err != nil {
+			restore()
+			return os.NewSyscallError("sched_setaffinity", err)
+		}
+		undo = append(undo, func() error {
+			return schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &old)
+		})
+	}
+	if policy != nil {
+		old, param, err := getSchedPolicy()
+		if err != nil {
+			restore()
+			return os.NewSyscallError("sched_getscheduler", err)
+		}
+		if err := setSchedPolicy(int(*policy), schedParam{}); err != nil {
+			restore()
+			return os.NewSyscallError("sched_setscheduler", err)
+		}
+		undo = append(undo, func() error {
+			return setSchedPolicy(old, param)
+		})
+	}
+	err := cmd.Start()
+	restore()
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// startInheriting is not supported, CPU affinity and scheduling policies are
+// only supported on Linux.
+func startInheriting(cmd *exec.Cmd, cpus *cpuSet, policy *schedPolicy) error {
+	return errors.New("cpuset and sched_policy are only supported on Linux")
+}
@@ -0,0 +1,28 @@
+package main
+
+// With -jitter (or jitter of a job in a config) every scheduled run waits a
+// random time between 0 and the jitter before it starts, so hosts running the
+// same schedule don't all hit a backend in the same second. Unlike -splay (see
+// splay.go), which moves the schedule by the same offset every time, the wait
+// is drawn anew for every run. Runs requested using run, backfill or
+// retry-failed start right away.
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+)
+
+const jitterFlag string = "jitter"
+
+// randomJitter returns a random duration between 0 and max.
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)+1))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64())
+}
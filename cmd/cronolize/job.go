@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+	"gopkg.in/yaml.v3"
+)
+
+// job is a command scheduled in the cron process, either the single job given
+// on the command line or one of the jobs from a config file.
+type job struct {
+	Name     string   `yaml:"name"`
+	Schedule string   `yaml:"schedule"`
+	Command  string   `yaml:"command"`
+	Log      string   `yaml:"log"`
+	Tags     []string `yaml:"tags"`
+	Enabled  *bool    `yaml:"enabled"`
+	Priority int      `yaml:"priority"`
+	Shell    string   `yaml:"shell"`
+	Env      []string `yaml:"env"`
+	MailTo   string   `yaml:"mailto"`
+	User     string   `yaml:"user"`
+	Group    string   `yaml:"group"`
+	// Pushgateway overrides the -pushgateway option for this job.
+	Pushgateway string `yaml:"pushgateway"`
+	// MailFailures overrides the -mail-failures option for this job.
+	MailFailures string `yaml:"mail_failures"`
+	// MaxMemory overrides the -max-memory option for this job.
+	MaxMemory string `yaml:"max_memory"`
+	// Timeout overrides the -timeout option for this job, see timeout.go.
+	Timeout string `yaml:"timeout"`
+	// Jitter overrides the -jitter option for this job, see jitter.go.
+	Jitter string `yaml:"jitter"`
+	// Overlap overrides the -overlap option for this job, see overlap.go.
+	Overlap string `yaml:"overlap"`
+	// Retries and RetryBackoff override the -retries and -retry-backoff
+	// options for this job, see retries.go.
+	Retries      *int   `yaml:"retries"`
+	RetryBackoff string `yaml:"retry_backoff"`
+	// MinFreeDisk and Preconditions are checked before every run and
+	// Postconditions after every successful run, see condition.go.
+	MinFreeDisk    string      `yaml:"min_free_disk"`
+	Preconditions  []condition `yaml:"preconditions"`
+	Postconditions []condition `yaml:"postconditions"`
+	// WaitForNetwork defers runs until the network is reachable, for up
+	// to NetworkWait, see network.go.
+	WaitForNetwork string `yaml:"wait_for_network"`
+	NetworkWait    string `yaml:"network_wait"`
+	// Wasm is a WASI module run instead of Command, with WasmArgs and
+	// access to the WasmMounts directories, see wasm.go.
+	Wasm       string   `yaml:"wasm"`
+	WasmArgs   []string `yaml:"wasm_args"`
+	WasmMounts []string `yaml:"wasm_mounts"`
+	// Metrics are extracted from the output of every run, see metrics.go.
+	Metrics []metricExtractor `yaml:"metrics"`
+	// CPUSet pins the job's processes to CPUs, see affinity.go.
+	CPUSet string `yaml:"cpuset"`
+	// SchedPolicy is the Linux scheduling policy of the job's processes,
+	// see schedpolicy.go.
+	SchedPolicy string `yaml:"sched_policy"`
+	// SystemdScope runs every run in a transient systemd scope with the
+	// SystemdProperties, see systemd.go.
+	SystemdScope      bool     `yaml:"systemd_scope"`
+	SystemdProperties []string `yaml:"systemd_properties"`
+	// RequireNTPSync skips runs while the system clock is not
+	// synchronized, see timesync.go.
+	RequireNTPSync bool `yaml:"require_ntp_sync"`
+	// OnlyOnAC and MinBattery skip runs on battery, see power.go.
+	OnlyOnAC   bool   `yaml:"only_on_ac"`
+	MinBattery string `yaml:"min_battery"`
+	// MaxLoad postpones runs for up to LoadWait while the load average is
+	// higher, see load.go.
+	MaxLoad  float64 `yaml:"max_load"`
+	LoadWait string  `yaml:"load_wait"`
+	// Watch is a file or directory whose changes run the job, after
+	// Debounce, see watch.go.
+	Watch    string `yaml:"watch"`
+	Debounce string `yaml:"debounce"`
+
+	// cred is who the job runs as (User and Group) resolved at startup.
+	cred *credential
+	// maxMemory is MaxMemory in bytes, 0 if not set.
+	maxMemory int64
+	timeout   time.Duration
+	overlap   cronolizer.OverlapPolicy
+	// jitter is Jitter, -1 if not set.
+	jitter time.Duration
+	// running is entered by a run of a job with an overlap policy other
+	// than allow until it has finished.
+	running cronolizer.Overlap
+	// retryBackoff is RetryBackoff, 0 if not set.
+	retryBackoff time.Duration
+	minFreeDisk  *diskSpace
+	network      *networkGate
+	minBattery   int
+	cpus         *cpuSet
+	schedPolicy  *schedPolicy
+	// wasm is the command line running Wasm, nil if the job has none.
+	wasm     []string
+	load     *loadGate
+	debounce time.Duration
+	watcher  *watcher
+
+	// index is the position in the jobs list of the config file, -1 if the
+	// job is not in it (added at runtime without being persisted).
+	index int
+	// added is true for jobs added at runtime.
+	added bool
+	// expanded is true for jobs expanded from a matrix, which share the
+	// index of their template.
+	expanded bool
+	// definition is the job as read from the file, to tell whether it has
+	// changed when the file is reloaded.
+	definition string
+	id         cronolizer.EntryID
+	stdout     io.Writer
+	stderr     io.Writer
+	logger     *log.Logger
+
+	mu            sync.Mutex
+	disabled      bool
+	outputSum     [sha256.Size]byte
+	hasOutputSum  bool
+	unchangedRuns int
+}
+
+// prepare checks the job's shell, memory limit and conditions and resolves who
+// it runs as.
+func (j *job) prepare() error {
+	if len(j.MaxMemory) > 0 {
+		n, err := parseByteSize(j.MaxMemory)
+		if err != nil {
+			return fmt.Errorf("max_memory: %v", err)
+		}
+		j.maxMemory = n
+	}
+	if len(j.MinFreeDisk) > 0 {
+		d, err := parseMinFreeDisk(j.MinFreeDisk)
+		if err != nil {
+			return fmt.Errorf("min_free_disk: %v", err)
+		}
+		j.minFreeDisk = d
+	}
+	if err := prepareMetrics(j.Metrics); err != nil {
+		return fmt.Errorf("metrics: %v", err)
+	}
+	if len(j.Wasm) > 0 {
+		argv, err := j.wasmArgv()
+		if err != nil {
+			return err
+		}
+		j.wasm = argv
+	}
+	if len(j.CPUSet) > 0 {
+		if !cpuSetSupported {
+			return errors.New("cpuset is only supported on Linux")
+		}
+		set, err := parseCPUSet(j.CPUSet)
+		if err != nil {
+			return fmt.Errorf("cpuset: %v", err)
+		}
+		j.cpus = set
+	}
+	if len(j.SchedPolicy) > 0 {
+		if !schedPolicySupported {
+			return errors.New("sched_policy is only supported on Linux")
+		}
+		policy, err := parseSchedPolicy(j.SchedPolicy)
+		if err != nil {
+			return fmt.Errorf("sched_policy: %v", err)
+		}
+		j.schedPolicy = &policy
+	}
+	if len(j.SystemdProperties) > 0 && !j.SystemdScope {
+		return errors.New("systemd_properties: requires systemd_scope")
+	}
+	if j.SystemdScope {
+		if err := checkSystemdRun(); err != nil {
+			return fmt.Errorf("systemd_scope: %v", err)
+		}
+		for _, property := range j.SystemdProperties {
+			if err := checkSystemdProperty(property); err != nil {
+				return fmt.Errorf("systemd_properties: %v", err)
+			}
+		}
+	}
+	if j.RequireNTPSync && !clockSyncSupported {
+		return errors.New("require_ntp_sync is only supported on Linux")
+	}
+	if len(j.MinBattery) > 0 {
+		n, err := parseBatteryPercent(j.MinBattery)
+		if err != nil {
+			return fmt.Errorf("min_battery: %v", err)
+		}
+		j.minBattery = n
+	}
+	if j.MaxLoad != 0 || len(j.LoadWait) > 0 {
+		g, err := newLoadGate(j.MaxLoad, j.LoadWait)
+		if err != nil {
+			return err
+		}
+		j.load = g
+	}
+	if len(j.WaitForNetwork) > 0 {
+		g, err := newNetworkGate(j.WaitForNetwork, j.NetworkWait)
+		if err != nil {
+			return fmt.Errorf("wait_for_network: %v", err)
+		}
+		j.network = g
+	}
+	if len(j.Timeout) > 0 {
+		d, err := time.ParseDuration(j.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("timeout: invalid duration %q", j.Timeout)
+		}
+		j.timeout = d
+	}
+	j.jitter = -1
+	if len(j.Jitter) > 0 {
+		d, err := time.ParseDuration(j.Jitter)
+		if err != nil || d < 0 {
+			return fmt.Errorf("jitter: invalid duration %q", j.Jitter)
+		}
+		j.jitter = d
+	}
+	if len(j.Overlap) > 0 {
+		p, err := cronolizer.ParseOverlapPolicy(j.Overlap)
+		if err != nil {
+			return fmt.Errorf("overlap: %v", err)
+		}
+		j.overlap = p
+	}
+	if j.Retries != nil && *j.Retries < 0 {
+		return fmt.Errorf("retries: must not be negative")
+	}
+	if len(j.RetryBackoff) > 0 {
+		d, err := time.ParseDuration(j.RetryBackoff)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("retry_backoff: invalid duration %q", j.RetryBackoff)
+		}
+		j.retryBackoff = d
+	}
+	j.debounce = defaultDebounce
+	if len(j.Debounce) > 0 {
+		d, err := time.ParseDuration(j.Debounce)
+		if err != nil || d < 0 {
+			return fmt.Errorf("debounce: invalid duration %q", j.Debounce)
+		}
+		j.debounce = d
+	}
+	for i := range j.Preconditions {
+		if err := j.Preconditions[i].prepare(false); err != nil {
+			return fmt.Errorf("precondition %d: %v", i+1, err)
+		}
+	}
+	for i := range j.Postconditions {
+		if err := j.Postconditions[i].prepare(true); err != nil {
+			return fmt.Errorf("postcondition %d: %v", i+1, err)
+		}
+	}
+	if len(j.Shell) > 0 {
+		if _, err := exec.LookPath(j.Shell); err != nil {
+			return fmt.Errorf("shell %s can not be used: %v", j.Shell, err)
+		}
+	}
+	if len(j.User) > 0 || len(j.Group) > 0 {
+		username := j.User
+		if len(username) == 0 {
+			username = strconv.Itoa(os.Geteuid())
+		}
+		cred, err := lookupCredential(username, j.Group)
+		if err != nil {
+			return err
+		}
+		j.cred = cred
+	}
+	return nil
+}
+
+// status returns the job's entry in the status file.
+func (j *job) status() jobStatus {
+	return jobStatus{
+		PID:     os.Getpid(),
+		ID:      int(j.id),
+		Name:    j.Name,
+		Spec:    j.spec(),
+		Watch:   j.Watch,
+		Command: j.displayCommand(),
+		Log:     j.Log,
+		Tags:    j.Tags,
+		Enabled: j.isEnabled(),
+	}
+}
+
+// displayCommand is the command of the job as shown to users, the module and
+// its arguments for a wasm job.
+func (j *job) displayCommand() string {
+	if len(j.Wasm) > 0 {
+		return strings.Join(append([]string{wasmCommand, j.Wasm}, j.WasmArgs...), " ")
+	}
+	return j.Command
+}
+
+func (j *job) isEnabled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.disabled
+}
+
+func (j *job) setEnabled(enabled bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.disabled = !enabled
+}
+
+// fingerprint returns the job's definition as YAML.
+func (j *job) fingerprint() string {
+	data, err := yaml.Marshal(j)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// spec returns the job's schedule, which may be changed at runtime.
+func (j *job) spec() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Schedule
+}
+
+func (j *job) setSpec(spec string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Schedule = spec
+}
+
+// writeOutput writes the captured output of a run to the job's stdout (stderr
+// if stdout is discarded by -q=3) unless it is byte-identical to the output of
+// the previous run, in which case only the number of consecutive runs that
+// produced this output is logged.
+func (j *job) writeOutput(output *outputCapture, quiet quietLevel) {
+	sum := output.Sum()
+	j.mu.Lock()
+	unchanged := j.hasOutputSum && sum == j.outputSum
+	if unchanged {
+		j.unchangedRuns++
+	} else {
+		j.outputSum = sum
+		j.hasOutputSum = true
+		j.unchangedRuns = 1
+	}
+	unchangedRuns := j.unchangedRuns
+	j.mu.Unlock()
+	if unchanged {
+		if quiet < quietAll {
+			j.logger.Printf("Output unchanged, %d runs", unchangedRuns)
+		}
+		return
+	}
+	if quiet < quietAll {
+		output.WriteTo(j.stdout)
+	} else {
+		output.WriteTo(j.stderr)
+	}
+}
+
+// resolveLogPath makes path absolute (relative paths are relative to the
+// working directory) and resolves any symlinks, a path that does not exist
+// yet is returned cleaned.
+func resolveLogPath(path string) (string, error) {
+	cleanedPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	evaluatedPath, err := filepath.EvalSymlinks(cleanedPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		evaluatedPath = cleanedPath
+	}
+	return evaluatedPath, nil
+}
+
+// openLog opens (or creates) a log file for appending, truncating it first if
+// truncate is true.
+func openLog(path string, truncate bool) (*os.File, error) {
+	var flags int
+	if truncate {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND | os.O_TRUNC
+	} else {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	return os.OpenFile(path, flags, 0666)
+}
+
+// nextRun returns the earliest next run time of all entries in c.
+func nextRun(c *cronolizer.Scheduler) time.Time {
+	var next time.Time
+	for _, entry := range c.Entries() {
+		if next.IsZero() || (!entry.Next.IsZero() && entry.Next.Before(next)) {
+			next = entry.Next
+		}
+	}
+	return next
+}
@@ -0,0 +1,166 @@
+package main
+
+// `cronolize kill JOB` sends a signal (SIGTERM unless -signal is given) to the
+// command of a running job and everything it started, or to those of the run
+// selected with -run-id if the job is running more than once (see `cronolize
+// running`). The run is recorded as cancelled instead of failed, in the job's
+// log, the status and the audit log, and notifiers are not alerted about it.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const killCommand string = "kill"
+
+// signals are the signals kill accepts by name, with or without SIG.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// parseSignal parses a signal name (TERM, SIGTERM) or number.
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// activeRun is a run whose command is executing.
+type activeRun struct {
+	id      int
+	job     *job
+	process *cronolizer.Process
+	// cancelled describes who killed the run with what signal, empty if
+	// it was not killed.
+	cancelled string
+	// frozen is true while the run is stopped by freeze.
+	frozen bool
+}
+
+// activeRuns keeps track of the executing runs so they can be killed.
+type activeRuns struct {
+	mu   sync.Mutex
+	runs map[int]*activeRun
+}
+
+func newActiveRuns() *activeRuns {
+	return &activeRuns{runs: make(map[int]*activeRun)}
+}
+
+func (a *activeRuns) add(run *activeRun) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.runs[run.id] = run
+}
+
+// remove forgets run id and returns who cancelled it, empty if no one did.
+func (a *activeRuns) remove(id int) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	run := a.runs[id]
+	delete(a.runs, id)
+	if run == nil {
+		return ""
+	}
+	return run.cancelled
+}
+
+// find returns the run with runID of the job named name, or the only run of
+// the job if runID is 0. a.mu must be held.
+func (a *activeRuns) find(name string, runID int) (*activeRun, error) {
+	var found []*activeRun
+	for _, run := range a.runs {
+		if run.job.Name == name && (runID == 0 || run.id == runID) {
+			found = append(found, run)
+		}
+	}
+	switch {
+	case len(found) == 0 && runID != 0:
+		return nil, fmt.Errorf("job %q has no run with ID %d", name, runID)
+	case len(found) == 0:
+		return nil, fmt.Errorf("job %q is not running", name)
+	case len(found) > 1:
+		return nil, fmt.Errorf("job %q is running %d times, select a run using -run-id", name, len(found))
+	}
+	return found[0], nil
+}
+
+// kill signals the run with runID of the job named name, or the only run of
+// the job if runID is 0, and returns it. by is who killed it.
+func (a *activeRuns) kill(name string, runID int, sig syscall.Signal, by string) (*activeRun, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	run, err := a.find(name, runID)
+	if err != nil {
+		return nil, err
+	}
+	if err := run.process.Signal(sig); err != nil {
+		return nil, err
+	}
+	// A frozen run would not get the signal until thawed.
+	if run.frozen {
+		run.process.Signal(syscall.SIGCONT)
+		run.frozen = false
+	}
+	run.cancelled = fmt.Sprintf("%s by %s", sigName(sig), by)
+	return run, nil
+}
+
+// sigName returns the name of sig, e.g. SIGTERM.
+func sigName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// killJobCmd implements `cronolize kill [-pid PID] [-run-id ID] [-signal
+// SIGNAL] JOB`.
+func killJobCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(killCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	runID := cmdFlags.Int("run-id", 0, "ID of the run to kill as shown by running (if the job is running more than once)")
+	signal := cmdFlags.String("signal", "TERM", "Signal to send to the command of the run and everything it started, by name or number")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] [-run-id ID] [-signal SIGNAL] JOB", os.Args[0], killCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	if _, err := parseSignal(*signal); err != nil {
+		fatalf("Syntax error: %v", err)
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	req := controlRequest{Command: killCommand, Job: name, RunID: *runID, Signal: *signal}
+	if err := sendControl(status.ControlSocket, req); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+// startOrphaning starts a shell like the command of a run, whose child
+// outlives it when the shell is killed, and returns it and the PID of the
+// child. The child holds on to stderr, so Wait does not return until it has
+// exited too.
+func startOrphaning(t *testing.T) (*cronolizer.Process, int) {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", "sleep 60 & echo $!; wait; echo done")
+	cmd.Stderr = &bytes.Buffer{}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := cronolizer.StartProcess(cmd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-p.Pid, syscall.SIGKILL)
+		syscall.Kill(child, syscall.SIGKILL)
+	})
+	return p, child
+}
+
+// waitExited waits for p and the process child to have exited, failing the
+// test if they don't within a few seconds.
+func waitExited(t *testing.T, p *cronolizer.Process, child int) {
+	t.Helper()
+	waited := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not done within 5s, its child is still running")
+	}
+	for deadline := time.Now().Add(5 * time.Second); !exited(child); {
+		if time.Now().After(deadline) {
+			t.Fatalf("the child %d of the command is still running", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// exited returns true if the process pid is gone or a zombie.
+func exited(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}
+	i := bytes.LastIndexByte(data, ')')
+	return i >= 0 && i+2 < len(data) && data[i+2] == 'Z'
+}
+
+func TestActiveRunsKill(t *testing.T) {
+	p, child := startOrphaning(t)
+	runs := newActiveRuns()
+	runs.add(&activeRun{id: 1, job: &job{Name: "slow"}, process: p})
+	if _, err := runs.kill("slow", 2, syscall.SIGTERM, "test"); err == nil {
+		t.Error("killing a run that does not exist succeeded")
+	}
+	if _, err := runs.kill("slow", 0, syscall.SIGTERM, "test"); err != nil {
+		t.Fatal(err)
+	}
+	waitExited(t, p, child)
+	if got, want := runs.remove(1), "SIGTERM by test"; got != want {
+		t.Errorf("remove(1) = %q, want %q", got, want)
+	}
+}
+
+func TestActiveRunsKillFrozen(t *testing.T) {
+	p, child := startOrphaning(t)
+	runs := newActiveRuns()
+	runs.add(&activeRun{id: 1, job: &job{Name: "slow"}, process: p})
+	if _, err := runs.freeze("slow", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runs.kill("slow", 0, syscall.SIGTERM, "test"); err != nil {
+		t.Fatal(err)
+	}
+	waitExited(t, p, child)
+}
@@ -0,0 +1,76 @@
+package main
+
+// Maintenance jobs can yield to real workload spikes. A job with
+//
+//	max_load: 4
+//	load_wait: 15m
+//
+// in a config is postponed while the 1 minute load average is above 4,
+// re-checking every loadRetryInterval for up to load_wait, and skipped if the
+// load has not come down by then (right away if there is no load_wait). Like
+// runs skipped on battery, these skips are logged and counted in the status
+// but not alerted about.
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const loadRetryInterval time.Duration = 30 * time.Second
+
+// loadGate is the max_load option of a job.
+type loadGate struct {
+	max  float64
+	wait time.Duration
+}
+
+// newLoadGate returns the gate for max, wait is the load_wait option (empty
+// to skip right away).
+func newLoadGate(max float64, wait string) (*loadGate, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("max_load: %v is not a positive load average", max)
+	}
+	g := &loadGate{max: max}
+	if len(wait) > 0 {
+		d, err := time.ParseDuration(wait)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("load_wait: invalid duration %q", wait)
+		}
+		g.wait = d
+	}
+	return g, nil
+}
+
+// waitFor blocks until the load average is at most max, logging to logger
+// while waiting. It returns an error if it is still higher after the wait.
+func (g *loadGate) waitFor(logger *log.Logger) error {
+	load, err := loadAverage()
+	if err != nil {
+		// Better to run than to skip because of not knowing.
+		logger.Print(colorize("Error:", ansiBold, ansiRed), " reading the load average: ", err)
+		return nil
+	}
+	if load <= g.max {
+		return nil
+	}
+	if g.wait > 0 {
+		logger.Printf("Postponed up to %s, the load average %.2f is above %.2f", g.wait, load, g.max)
+	}
+	started := time.Now()
+	deadline := started.Add(g.wait)
+	for remaining := g.wait; remaining > 0; remaining = time.Until(deadline) {
+		if remaining > loadRetryInterval {
+			remaining = loadRetryInterval
+		}
+		time.Sleep(remaining)
+		if load, err = loadAverage(); err != nil {
+			return nil
+		}
+		if load <= g.max {
+			logger.Printf("Load average down to %.2f after %s", load, time.Since(started).Round(time.Second))
+			return nil
+		}
+	}
+	return fmt.Errorf("the load average %.2f is above %.2f", load, g.max)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// loadAverage returns the 1 minute load average from `sysctl -n vm.loadavg`,
+// which prints e.g. "{ 1.23 1.10 1.05 }" on macOS and the BSDs.
+func loadAverage() (float64, error) {
+	out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(string(out)), "{}"))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("sysctl vm.loadavg: unexpected output %q", out)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,162 @@
+package main
+
+// Log paths (-log and the logs of jobs) may contain strftime(3) conversions
+// like cronolog, e.g. /var/log/cronolize/backup.%Y-%m-%d.log, which are
+// resolved when the log is written to: a new file (and directory) is started
+// whenever the resolved path changes, giving daily or hourly logs without
+// rotating them. The times are local, or UTC with -utc. With -log-compress,
+// the file left behind is gzipped when a new one is started.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isLogPattern reports whether path contains strftime conversions.
+func isLogPattern(path string) bool {
+	return strings.Contains(path, "%")
+}
+
+// strftime formats t according to pattern. Supported are %Y, %y, %m, %d,
+// %e, %j, %H, %I, %M, %S, %p, %a, %A, %b, %h, %B, %u, %w, %V, %G, %s, %z,
+// %Z, %F, %T and %%, anything else is left as it is.
+func strftime(pattern string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch c := pattern[i]; c {
+		case 'Y':
+			b.WriteString(strconv.Itoa(t.Year()))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'e':
+			b.WriteString(t.Format("_2"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'I':
+			b.WriteString(t.Format("03"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'u':
+			weekday := int(t.Weekday())
+			if weekday == 0 {
+				weekday = 7
+			}
+			b.WriteString(strconv.Itoa(weekday))
+		case 'w':
+			b.WriteString(strconv.Itoa(int(t.Weekday())))
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&b, "%02d", week)
+		case 'G':
+			year, _ := t.ISOWeek()
+			b.WriteString(strconv.Itoa(year))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			b.WriteString(t.Format("15:04:05"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// openDatedLog opens the file pattern resolves to at t like openLog,
+// creating its directory if needed. It returns the path of the file.
+func openDatedLog(pattern string, t time.Time, truncate bool) (*os.File, string, error) {
+	path := strftime(pattern, t)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, "", err
+	}
+	f, err := openLog(path, truncate)
+	return f, path, err
+}
+
+// datedLog writes to the file its pattern resolves to at the time of each
+// write, rotated by size if rotation.maxSize is set.
+type datedLog struct {
+	mu       sync.Mutex
+	pattern  string
+	now      func() time.Time
+	path     string
+	w        io.WriteCloser
+	rotation logRotation
+}
+
+// newDatedLog takes over f, the file pattern resolved to at path when it was
+// opened.
+func newDatedLog(f *os.File, path, pattern string, now func() time.Time, rotation logRotation) *datedLog {
+	d := &datedLog{pattern: pattern, now: now, rotation: rotation}
+	d.use(f, path)
+	return d
+}
+
+func (d *datedLog) use(f *os.File, path string) {
+	d.path = path
+	if d.rotation.maxSize > 0 {
+		d.w = newRotatingLog(f, path, d.rotation)
+	} else {
+		d.w = f
+	}
+}
+
+func (d *datedLog) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t := d.now(); strftime(d.pattern, t) != d.path {
+		f, path, err := openDatedLog(d.pattern, t, false)
+		if err != nil {
+			// Keep writing to the previous file rather than losing
+			// the output, opening the new one is tried again on the
+			// next write.
+			fmt.Fprintf(d.w, "Error: opening %s: %v\n", strftime(d.pattern, t), err)
+		} else {
+			d.w.Close()
+			if d.rotation.compress {
+				go func(path string) {
+					logCompressError(path, compressLog(path))
+				}(d.path)
+			}
+			d.use(f, path)
+		}
+	}
+	return d.w.Write(p)
+}
@@ -0,0 +1,180 @@
+package main
+
+// With -log-max-size, the cron process rotates the log files it writes (the
+// -log file and the logs of jobs) when a write would make one larger than the
+// size: out.log is renamed out.log.1, an existing out.log.1 out.log.2 and so
+// on, keeping -log-max-files old files, and a new out.log is started.
+//
+// With -log-compress, a rotated file (and the file of a strftime pattern no
+// longer written to, see logdate.go) is gzipped in the background into
+// out.log.1.gz. The compressed file is written next to the original, which is
+// only removed once the compressed one is complete, so a failed compression
+// leaves the output uncompressed rather than losing it.
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	logMaxSizeFlag     string = "log-max-size"
+	logMaxFilesFlag    string = "log-max-files"
+	logCompressFlag    string = "log-compress"
+	defaultLogMaxFiles int    = 5
+	compressedLogExt   string = ".gz"
+)
+
+// logRotation is how log files are rotated, by size if maxSize is set.
+type logRotation struct {
+	maxSize  int64
+	maxFiles int
+	compress bool
+}
+
+// rotatingLog is a log file rotated when it would grow past maxSize.
+type rotatingLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	path     string
+	size     int64
+	rotation logRotation
+	// compressing is waited for before renaming the old files again.
+	compressing sync.WaitGroup
+}
+
+// newRotatingLog takes over f, the log file opened from path.
+func newRotatingLog(f *os.File, path string, rotation logRotation) *rotatingLog {
+	r := &rotatingLog{f: f, path: path, rotation: rotation}
+	if info, err := f.Stat(); err == nil {
+		r.size = info.Size()
+	}
+	return r
+}
+
+func (r *rotatingLog) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.rotation.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep writing to the file rather than losing the
+			// output, rotation is tried again after another
+			// maxSize.
+			fmt.Fprintf(r.f, "Error: rotating %s: %v\n", r.path, err)
+			r.size = 0
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotated returns the path of the nth old file.
+func (r *rotatingLog) rotated(n int) string {
+	return r.path + "." + strconv.Itoa(n)
+}
+
+// rotate renames the old files and the current file one index up, removing
+// the oldest, and starts a new file.
+func (r *rotatingLog) rotate() error {
+	r.compressing.Wait()
+	if r.rotation.maxFiles == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		// Old files are compressed or not depending on -log-compress
+		// (and whether compressing them succeeded).
+		for _, ext := range []string{"", compressedLogExt} {
+			if err := os.Remove(r.rotated(r.rotation.maxFiles) + ext); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for n := r.rotation.maxFiles - 1; n >= 1; n-- {
+				if err := os.Rename(r.rotated(n)+ext, r.rotated(n+1)+ext); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(r.path, r.rotated(1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	f, err := openLog(r.path, false)
+	if err != nil {
+		return err
+	}
+	r.f.Close()
+	r.f, r.size = f, 0
+	if r.rotation.compress && r.rotation.maxFiles > 0 {
+		r.compressing.Add(1)
+		go func(path string) {
+			err := compressLog(path)
+			// Done before logging, the log may be this file and
+			// the next rotation waits for compressing.
+			r.compressing.Done()
+			logCompressError(path, err)
+		}(r.rotated(1))
+	}
+	return nil
+}
+
+// Close closes the current file.
+func (r *rotatingLog) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// compressLog gzips path into path.gz and removes path. The compressed file
+// is written to path.gz.tmp first and renamed when complete, on any error it
+// is removed and path is left as it is.
+func compressLog(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := path + compressedLogExt + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path+compressedLogExt); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// logCompressError logs err from compressLog, if any.
+func logCompressError(path string, err error) {
+	if err != nil {
+		log.Printf("%s compressing %s, leaving it uncompressed: %v", colorize("Error:", ansiBold, ansiRed), path, err)
+	}
+}
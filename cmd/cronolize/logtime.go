@@ -0,0 +1,62 @@
+package main
+
+// The timestamps of log entries and the times in summary lines are written in
+// the layout given with -log-time-format to match existing log parsing
+// pipelines: rfc3339, rfc3339nano, epoch (Unix time in seconds with
+// milliseconds) or a Go time layout such as "Jan _2 15:04:05". Without it, log
+// entries are stamped like by the log package and summary lines use RFC 3339
+// with milliseconds. With -utc the times are in UTC.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	logTimeFormatFlag    string = "log-time-format"
+	defaultLogTimeLayout string = "2006/01/02 15:04:05"
+)
+
+// logTimeFormat is how times are written in logs.
+type logTimeFormat struct {
+	// layout is a time layout, empty for Unix time.
+	layout string
+	utc    bool
+}
+
+// parseLogTimeFormat parses the -log-time-format option.
+func parseLogTimeFormat(s string, utc bool) (logTimeFormat, error) {
+	f := logTimeFormat{layout: s, utc: utc}
+	switch strings.ToLower(s) {
+	case "rfc3339":
+		f.layout = time.RFC3339
+	case "rfc3339nano":
+		f.layout = time.RFC3339Nano
+	case "epoch":
+		f.layout = ""
+	default:
+		// A layout without any element of the reference time would
+		// stamp every entry with the same text.
+		if time.Unix(0, 0).UTC().Format(s) == s {
+			return f, fmt.Errorf("%q is not rfc3339, rfc3339nano, epoch or a Go time layout", s)
+		}
+	}
+	return f, nil
+}
+
+// append appends t formatted to b.
+func (f logTimeFormat) append(b []byte, t time.Time) []byte {
+	if len(f.layout) == 0 {
+		return strconv.AppendFloat(b, float64(t.UnixMilli())/1000, 'f', 3, 64)
+	}
+	if f.utc {
+		t = t.UTC()
+	}
+	return t.AppendFormat(b, f.layout)
+}
+
+func (f logTimeFormat) format(t time.Time) string {
+	return string(f.append(nil, t))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// asyncWriterKick is the buffer size at which the flusher is woken up
+	// before the next interval.
+	asyncWriterKick int = 64 * 1024
+	// asyncWriterLimit is the buffer size at which Write stops buffering and
+	// flushes synchronously, pushing back on the writer instead of growing.
+	asyncWriterLimit int = 8 * 1024 * 1024
+)
+
+// asyncWriter buffers writes in memory and writes them to the underlying
+// writer from a separate goroutine, so a chatty job does not make every write
+// a blocking syscall. Only complete lines are flushed at each interval unless
+// a partial line has been pending for a full interval. Close (or Flush) writes
+// everything that is buffered.
+type asyncWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      bytes.Buffer
+	partial  bool
+	err      error
+	kick     chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newAsyncWriter(w io.Writer, interval time.Duration) *asyncWriter {
+	a := &asyncWriter{
+		w:       w,
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go a.loop(interval)
+	return a
+}
+
+func (a *asyncWriter) loop(interval time.Duration) {
+	defer close(a.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flush(false)
+		case <-a.kick:
+			a.flush(false)
+		case <-a.done:
+			a.flush(true)
+			return
+		}
+	}
+}
+
+// Write never blocks on the underlying writer unless the buffer has grown
+// past asyncWriterLimit. Errors from the underlying writer are returned on the
+// next Write.
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return 0, a.err
+	}
+	n, _ := a.buf.Write(p)
+	switch {
+	case a.buf.Len() >= asyncWriterLimit:
+		a.writeLocked(a.buf.Len())
+	case a.buf.Len() >= asyncWriterKick:
+		select {
+		case a.kick <- struct{}{}:
+		default:
+		}
+	}
+	return n, nil
+}
+
+// flush writes all complete lines, or everything if all is true or a partial
+// line was already pending at the previous flush.
+func (a *asyncWriter) flush(all bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buf.Len() == 0 {
+		a.partial = false
+		return
+	}
+	n := bytes.LastIndexByte(a.buf.Bytes(), '\n') + 1
+	if all || a.partial {
+		n = a.buf.Len()
+	}
+	a.writeLocked(n)
+	a.partial = a.buf.Len() > 0
+}
+
+func (a *asyncWriter) writeLocked(n int) {
+	if n == 0 {
+		return
+	}
+	if _, err := a.w.Write(a.buf.Next(n)); err != nil && a.err == nil {
+		a.err = err
+	}
+}
+
+// Flush synchronously writes everything buffered.
+func (a *asyncWriter) Flush() error {
+	a.flush(true)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Close flushes the buffer and stops the flusher, it does not close the
+// underlying writer.
+func (a *asyncWriter) Close() error {
+	a.stopOnce.Do(func() {
+		close(a.done)
+	})
+	<-a.stopped
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// collapseRepeatsAfter is how long a "last message repeated" count is held
+// back waiting for more repeats before it is written.
+const collapseRepeatsAfter time.Duration = 30 * time.Second
+
+// repeatCollapser is a line filter that collapses consecutive identical lines
+// into "last message repeated N times", like syslog. The count is written
+// when a different line arrives, after collapseRepeatsAfter or on Close.
+type repeatCollapser struct {
+	mu       sync.Mutex
+	w        io.Writer
+	partial  []byte
+	last     []byte
+	hasLast  bool
+	repeated int
+	timer    *time.Timer
+}
+
+func newRepeatCollapser(w io.Writer) *repeatCollapser {
+	return &repeatCollapser{w: w}
+}
+
+func (r *repeatCollapser) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.partial, p...)
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := data[:i+1]
+		data = data[i+1:]
+		if r.hasLast && bytes.Equal(line, r.last) {
+			r.repeated++
+			if r.timer == nil {
+				r.timer = time.AfterFunc(collapseRepeatsAfter, r.flushRepeated)
+			}
+			continue
+		}
+		r.writeRepeatedLocked(&out)
+		r.last = append(r.last[:0], line...)
+		r.hasLast = true
+		out.Write(line)
+	}
+	r.partial = append([]byte(nil), data...)
+	if out.Len() > 0 {
+		if _, err := r.w.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (r *repeatCollapser) writeRepeatedLocked(out *bytes.Buffer) {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if r.repeated == 0 {
+		return
+	}
+	if r.repeated == 1 {
+		out.WriteString("last message repeated 1 time\n")
+	} else {
+		fmt.Fprintf(out, "last message repeated %d times\n", r.repeated)
+	}
+	r.repeated = 0
+}
+
+// flushRepeated writes a pending repeat count, further repeats of the same
+// line are counted again from zero.
+func (r *repeatCollapser) flushRepeated() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out bytes.Buffer
+	r.writeRepeatedLocked(&out)
+	if out.Len() > 0 {
+		r.w.Write(out.Bytes())
+	}
+}
+
+// Close writes any pending repeat count and partial line, it does not close
+// the underlying writer.
+func (r *repeatCollapser) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out bytes.Buffer
+	r.writeRepeatedLocked(&out)
+	out.Write(r.partial)
+	r.partial = nil
+	if out.Len() > 0 {
+		_, err := r.w.Write(out.Bytes())
+		return err
+	}
+	return nil
+}
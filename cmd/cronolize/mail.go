@@ -0,0 +1,173 @@
+package main
+
+// Jobs with a MAILTO (see crontab.go) or mailto in a config have the output of
+// every run that produced any mailed to them using sendmail(8), like cron.
+//
+// With -mail-failures (or mail_failures per job in a config), a report is
+// mailed for every failed run, with the run's metadata and the last
+// -mail-tail-lines lines of output so most failures can be triaged from the
+// mail alone.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// sendmailPath is the sendmail compatible program used to send mail.
+	sendmailPath         string = "/usr/sbin/sendmail"
+	mailFailuresFlag     string = "mail-failures"
+	defaultMailTailLines int    = 50
+)
+
+// mailOutput mails body to the comma separated addresses in to.
+func mailOutput(to, subject string, body io.WriterTo) error {
+	return sendMail(to, subject, "text/plain; charset=UTF-8", func(w io.Writer) error {
+		_, err := body.WriteTo(w)
+		return err
+	})
+}
+
+// sendMail pipes a message with a body of contentType written by writeBody to
+// sendmail.
+func sendMail(to, subject, contentType string, writeBody func(w io.Writer) error) error {
+	cmd := exec.Command(sendmailPath, "-oi", "-t")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdin, "To: %s\nSubject: %s\nMIME-Version: 1.0\nContent-Type: %s\n\n", headerValue(to), headerValue(subject), contentType)
+	if err == nil {
+		err = writeBody(stdin)
+	}
+	stdin.Close()
+	if waitErr := cmd.Wait(); waitErr != nil {
+		return fmt.Errorf("%s: %w", sendmailPath, waitErr)
+	}
+	return err
+}
+
+// mailWithAttachment mails body with the file at path attached as name.
+func mailWithAttachment(to, subject string, body []byte, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	boundary := multipart.NewWriter(nil).Boundary()
+	return sendMail(to, subject, "multipart/mixed; boundary="+boundary, func(w io.Writer) error {
+		mw := multipart.NewWriter(w)
+		mw.SetBoundary(boundary)
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(body); err != nil {
+			return err
+		}
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=UTF-8"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		// Base64 in lines of 76 characters as required by RFC 2045.
+		chunk := make([]byte, 57)
+		for {
+			n, err := io.ReadFull(f, chunk)
+			if n > 0 {
+				if _, err := io.WriteString(part, base64.StdEncoding.EncodeToString(chunk[:n])+"\r\n"); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return mw.Close()
+	})
+}
+
+// mailSubject returns a cron style subject for mail about command.
+func mailSubject(command string) string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("Cron <%s@%s> %s", username, hostname, command)
+}
+
+// headerValue folds s into a single line so it can not inject headers.
+func headerValue(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// mailNotifier mails a report about every alerted (failed or recovered) run.
+type mailNotifier struct {
+	// to is the default recipients, jobs may have their own.
+	to        string
+	tailLines int
+}
+
+func (m *mailNotifier) name() string {
+	return "Mail"
+}
+
+func (m *mailNotifier) notify(r runResult) error {
+	to := m.to
+	if len(r.job.MailFailures) > 0 {
+		to = r.job.MailFailures
+	}
+	if !r.alert() || len(to) == 0 {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	var body bytes.Buffer
+	tw := tabwriter.NewWriter(&body, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "Job:\t%s\n", r.job.Name)
+	fmt.Fprintf(tw, "Command:\t%s\n", r.job.displayCommand())
+	fmt.Fprintf(tw, "Schedule:\t%s\n", r.job.spec())
+	fmt.Fprintf(tw, "Host:\t%s\n", hostname)
+	fmt.Fprintf(tw, "Started:\t%s\n", r.started.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Duration:\t%s\n", r.duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "Exit code:\t%d\n", r.exitCode)
+	if r.err != nil {
+		fmt.Fprintf(tw, "Error:\t%v\n", r.err)
+	}
+	tw.Flush()
+	if output := outputTailLines(r, m.tailLines); len(output) > 0 {
+		fmt.Fprintf(&body, "\nOutput (last %d lines at most):\n\n%s\n", m.tailLines, output)
+	} else {
+		fmt.Fprintln(&body, "\nThe run produced no output.")
+	}
+	subject := r.title()
+	if len(r.outputFile) > 0 && r.lines > m.tailLines {
+		fmt.Fprintf(&body, "\nThe complete output is attached (and kept in %s).\n", r.outputFile)
+		return mailWithAttachment(to, subject, body.Bytes(), filepath.Base(r.outputFile), r.outputFile)
+	}
+	return mailOutput(to, subject, &body)
+}
@@ -0,0 +1,205 @@
+package main
+
+// `cronolize maintenance on [DURATION]` puts every running cronolize process
+// (or the one selected with -pid) in maintenance mode until `cronolize
+// maintenance off`, or until the duration has passed. Runs coming due during
+// maintenance are skipped, so planned downtime does not produce failure noise.
+// The maintenance window is shown by `cronolize status` and recorded in the
+// audit log.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const maintenanceCommand string = "maintenance"
+
+type maintenanceStatus struct {
+	Since time.Time `json:"since"`
+	// Until is when maintenance ends by itself, nil if only when turned off.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// maintenanceMode pauses all jobs of the process while on.
+type maintenanceMode struct {
+	mu    sync.Mutex
+	on    bool
+	since time.Time
+	until time.Time
+	timer *time.Timer
+	// changed is called (with the lock held) whenever maintenance starts,
+	// is extended or ends, by is who caused it and detail describes it.
+	changed func(status *maintenanceStatus, by, detail string)
+}
+
+// active reports whether runs are to be skipped.
+func (m *maintenanceMode) active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.on
+}
+
+// start turns maintenance on, until stop is called if d is 0, otherwise for d.
+// Starting again while on replaces the duration.
+func (m *maintenanceMode) start(d time.Duration, by string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if !m.on {
+		m.on = true
+		m.since = now
+	}
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.until = time.Time{}
+	detail := "until turned off"
+	if d > 0 {
+		m.until = now.Add(d)
+		detail = fmt.Sprintf("for %s, until %s", d, m.until.Format(time.RFC3339))
+		m.endAfter(d)
+	}
+	if m.changed != nil {
+		m.changed(m.status(), by, detail)
+	}
+}
+
+// restore continues a maintenance window handed over by another process (see
+// upgrade.go) without calling changed.
+func (m *maintenanceMode) restore(status *maintenanceStatus) {
+	if status == nil || (status.Until != nil && !status.Until.After(time.Now())) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.on = true
+	m.since = status.Since
+	if status.Until != nil {
+		m.until = *status.Until
+		m.endAfter(time.Until(m.until))
+	}
+}
+
+// endAfter ends maintenance after d, the lock must be held.
+func (m *maintenanceMode) endAfter(d time.Duration) {
+	// Stopped timers may still fire, the generation check keeps an old
+	// timer from ending a newer maintenance window.
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.timer == timer {
+			m.end("", fmt.Sprintf("after %s, the duration elapsed", time.Since(m.since).Round(time.Second)))
+		}
+	})
+	m.timer = timer
+}
+
+// stop turns maintenance off, it is not an error if it is already off.
+func (m *maintenanceMode) stop(by string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.on {
+		m.end(by, fmt.Sprintf("after %s", time.Since(m.since).Round(time.Second)))
+	}
+}
+
+// end turns maintenance off, the lock must be held.
+func (m *maintenanceMode) end(by, detail string) {
+	m.on = false
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	if m.changed != nil {
+		m.changed(nil, by, detail)
+	}
+}
+
+// status returns the maintenance window, nil if not on. The lock must be held.
+func (m *maintenanceMode) status() *maintenanceStatus {
+	if !m.on {
+		return nil
+	}
+	status := &maintenanceStatus{Since: m.since}
+	if !m.until.IsZero() {
+		until := m.until
+		status.Until = &until
+	}
+	return status
+}
+
+// setMaintenance records the maintenance window (nil if not in maintenance)
+// and writes the status file. Errors are logged, not fatal.
+func setMaintenance(s *statusFile, maintenance *maintenanceStatus) {
+	err := s.update(func(status *daemonStatus) {
+		status.Maintenance = maintenance
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// maintenanceCmd implements `cronolize maintenance [-pid PID] on [DURATION]`
+// and `cronolize maintenance [-pid PID] off`.
+func maintenanceCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(maintenanceCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process to put in or take out of maintenance (default all)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] on [DURATION] | off", os.Args[0], maintenanceCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	req := controlRequest{Command: maintenanceCommand}
+	switch {
+	case cmdFlags.NArg() >= 1 && cmdFlags.NArg() <= 2 && cmdFlags.Arg(0) == "on":
+		req.On = true
+		if cmdFlags.NArg() == 2 {
+			d, err := time.ParseDuration(cmdFlags.Arg(1))
+			if err != nil || d <= 0 {
+				fatalf("Syntax error: invalid duration %q", cmdFlags.Arg(1))
+			}
+			req.Duration = d
+		}
+	case cmdFlags.NArg() == 1 && cmdFlags.Arg(0) == "off":
+	default:
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	found, failed := false, false
+	for _, status := range statuses {
+		if *pid != 0 && status.PID != *pid {
+			continue
+		}
+		found = true
+		if len(status.ControlSocket) == 0 {
+			pe("%s process %d has no control socket", colorize("Error:", ansiBold, ansiRed), status.PID)
+			failed = true
+			continue
+		}
+		if err := sendControl(status.ControlSocket, req); err != nil {
+			pe("%s process %d: %v", colorize("Error:", ansiBold, ansiRed), status.PID, err)
+			failed = true
+		}
+	}
+	if !found {
+		if *pid != 0 {
+			fatalf("Error: no cronolize process with PID %d", *pid)
+		}
+		fatal("no cronolize processes are running")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+// The man page is rendered from the same flag definitions and help constants
+// used by the built-in syntax help, so `cronolize man > cronolize.1` always
+// matches the binary it was produced by.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const manCommand string = "man"
+
+// roffEscape escapes backslashes and hyphens and protects lines starting with
+// a control character (. or ') from being interpreted as roff requests.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// roffVerbatim writes text as a no-fill block, used for tables and examples.
+func roffVerbatim(w io.Writer, text string) {
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, ".nf")
+	fmt.Fprintln(w, ".ft CR")
+	fmt.Fprintln(w, roffEscape(strings.Trim(text, "\n")))
+	fmt.Fprintln(w, ".ft R")
+	fmt.Fprintln(w, ".fi")
+}
+
+// writeManPage renders a roff man page (section 1) to w from the flags
+// registered on fs.
+func writeManPage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, ".TH CRONOLIZE 1 \"\" \"cronolize %s\" \"User Commands\"\n", roffEscape(version))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `cronolize \- run a command on a CRON schedule as a background daemon`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B cronolize`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fIcronSpec\fR \fIcommand\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fB\-config\fR \fIfile\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fB\-crontab\fR|\fB\-system\-crontab\fR \fIfile\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fB\-on\-start\fR \fIcommand\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize start`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fIcronSpec\fR \fIcommand\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize stop`)
+	fmt.Fprintln(w, `[\fB\-statedir\fR \fIdirectory\fR] [\fB\-all\fR] [\fB\-wait\fR \fIDURATION\fR] [\fIPID\fR...]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize list`)
+	fmt.Fprintln(w, `[\fB\-json\fR] [\fB\-tag\fR \fItag\fR] [\fB\-statedir\fR \fIdirectory\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize status`)
+	fmt.Fprintln(w, `[\fB\-json\fR] [\fB\-tag\fR \fItag\fR] [\fB\-recent\fR \fIN\fR] [\fB\-statedir\fR \fIdirectory\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize running`)
+	fmt.Fprintln(w, `[\fB\-json\fR] [\fB\-statedir\fR \fIdirectory\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize ps`)
+	fmt.Fprintln(w, `[\fB\-json\fR] [\fB\-statedir\fR \fIdirectory\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize run`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] [\fB\-param\fR \fIKEY\fR=\fIVALUE\fR]... \fIJOB\fR|\fB\-tag\fR \fItag\fR...`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize backfill`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] \fB\-from\fR \fIFROM\fR \fB\-to\fR \fITO\fR [\fB\-param\fR \fIKEY\fR=\fIVALUE\fR]... \fIJOB\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize retry\-failed`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] [\fB\-since\fR \fIDURATION\fR] [\fIJOB\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize kill`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] [\fB\-run\-id\fR \fIID\fR] [\fB\-signal\fR \fISIGNAL\fR] \fIJOB\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize freeze\fR|\fBthaw`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] [\fB\-run\-id\fR \fIID\fR] \fIJOB\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize enable\fR|\fBdisable`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] \fIJOB\fR|\fB\-tag\fR \fItag\fR...`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize set\-schedule`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] \fIJOB\fR \fISCHEDULE\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize add`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] [\fB\-persist\fR] [\fIoptions\fR] \fIcronSpec\fR \fIcommand\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize remove`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] [\fB\-persist\fR] \fIJOB\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize maintenance`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR] \fBon\fR [\fIDURATION\fR] | \fBoff\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize upgrade`)
+	fmt.Fprintln(w, `[\fB\-pid\fR \fIPID\fR] [\fB\-statedir\fR \fIdirectory\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize wasm`)
+	fmt.Fprintln(w, `[\fB\-mount\fR \fIHOST\fR[:\fIGUEST\fR][:ro]]... \fIfile\fR [\fIargs\fR...]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize doctor`)
+	fmt.Fprintln(w, `[\fB\-statedir\fR \fIdirectory\fR] [\fB\-shell\fR \fIshell\fR] [\fB\-config\fR|\fB\-crontab\fR \fIfile\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize describe`)
+	fmt.Fprintln(w, `[\fB\-json\fR]`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize explain`)
+	fmt.Fprintln(w, `[\fB\-seconds\fR] \fIcronSpec\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B cronolize man`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, roffEscape(`cronolize schedules command to be executed via $SHELL -c (by default, /bin/sh if SHELL is not set) `+
+		`according to cronSpec, a five field CRON expression (six with -seconds, a systemd OnCalendar expression with -calendar or a Quartz expression with -quartz), or an iCalendar recurrence rule such as RRULE:FREQ=MONTHLY;BYDAY=-1FR. `+
+		`See https://pkg.go.dev/github.com/robfig/cron/v3 for details.`))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape(strings.ReplaceAll(strings.TrimSpace(daemonMsg), "\n", " ")))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize start starts a cronolize process like cronolize without a subcommand, with "+
+		"-config or -crontab as well. cronolize stop stops the process with PID (as shown by cronolize status), or the "+
+		"only one running, and waits up to -wait for it to exit. Runs in progress are not waited for."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("On SIGHUP, a cronolize process started with -config, -crontab or -system-crontab "+
+		"reads the file again, schedules the jobs added to it, replaces changed jobs and unschedules removed jobs. "+
+		"Unchanged jobs keep their next run. If the file has errors, all jobs are kept as they are."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize list shows the jobs of all running cronolize processes of the current "+
+		"user, cronolize status shows the processes themselves. With -json the output is a stable JSON "+
+		"structure where fields are only ever added."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize running shows the runs currently executing in all running cronolize "+
+		"processes with the PID, start time and elapsed time of their commands."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize ps lists the cronolize processes of all users on the host (only your own unless "+
+		"run as root) with their user, config and number of jobs."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize run runs a job of a running cronolize process right away, outside its "+
+		"schedule, with each -param KEY=VALUE added to the environment of that run. With -tag instead of a job, every "+
+		"job with the tags is run."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize backfill runs a job once for every time its schedule fired from -from until -to "+
+		"(dates or RFC 3339 times), one run after the other, with the time each run stands in for in CRONOLIZE_SCHEDULED_TIME."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize retry-failed runs the runs that failed within -since (24h by default) again, "+
+		"with the scheduled time and parameters of the original run. A failed run is only retried once."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize kill sends SIGTERM (or -signal) to the command of a running job, or to "+
+		"the run with -run-id as shown by cronolize running. The run is recorded as cancelled, not failed."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize freeze pauses a running job by stopping its command and everything it "+
+		"started with SIGSTOP, cronolize thaw continues it with SIGCONT."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize enable and disable turn a job of a running cronolize process on or off "+
+		"via its control socket, or with -tag every job with the tags. A disabled job is still scheduled, but skipped "+
+		"when it fires."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize set-schedule changes the schedule of a job of a running cronolize process "+
+		"without restarting it. With -config the new schedule is also written to the config file."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize add and remove add a job to or remove a job from a running cronolize "+
+		"process, with -persist also to or from its config file. See cronolize add -h for the options of a job."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("The commands talking to running cronolize processes find them by their status files. "+
+		"With -socket PATH they talk to the process listening on the control socket at PATH instead, as given to it by "+
+		"-control-socket."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize maintenance on pauses all jobs of every running cronolize process (or the one "+
+		"given by -pid) until cronolize maintenance off, or until DURATION (e.g. 2h) has passed. Runs coming due "+
+		"during maintenance are skipped."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize upgrade replaces every cronolize process running in the background (or the one "+
+		"given by -pid) with the cronolize binary now on disk. The new process takes over the state of the old one, "+
+		"which exits when its running jobs have finished, so no scheduled run is missed."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize wasm runs a WASI module with the standard input, output and environment of "+
+		"the process, and no access to files except the directories given by -mount. It is what runs the module of a job "+
+		"with wasm in a config."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize doctor checks the shell, the log, runtime and state directories, the timezone "+
+		"database and the system clock, looks for files left behind by cronolize processes that are gone and, with -config "+
+		"or -crontab, checks the jobs in the file. It prints what to do about every problem found and exits 1 on errors."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize describe lists every field of a config file with its type, default and "+
+		"allowed values, with -json as a JSON Schema for editors and other tools to complete and validate configs with."))
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, roffEscape("cronolize explain describes cronSpec in English, e.g. \"37 13 * * 1-5\" as \"At 13:37 on "+
+		"Monday through Friday\", and warns about likely mistakes such as both the day of the month and of the week being "+
+		"restricted (cron runs the job when either matches), * as the minute or days missing from the months given."))
+	fmt.Fprintln(w, ".SH OPTIONS")
+	fs.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintln(w, ".TP")
+		if len(name) > 0 {
+			fmt.Fprintf(w, "\\fB\\-%s\\fR \\fI%s\\fR\n", roffEscape(f.Name), roffEscape(name))
+		} else {
+			fmt.Fprintf(w, "\\fB\\-%s\\fR\n", roffEscape(f.Name))
+		}
+		if len(f.DefValue) > 0 && f.DefValue != "false" {
+			usage = fmt.Sprintf("%s (default %q)", usage, f.DefValue)
+		}
+		fmt.Fprintln(w, roffEscape(usage))
+	})
+	fmt.Fprintln(w, ".SH CRON FORMAT")
+	roffVerbatim(w, cronFormatMsg)
+	fmt.Fprintln(w, ".SS Predefined schedules")
+	roffVerbatim(w, predefinedMsg)
+	fmt.Fprintln(w, ".SH EXAMPLES")
+	roffVerbatim(w, examplesMsg)
+	fmt.Fprintln(w, ".SH AUTHOR")
+	fmt.Fprintln(w, roffEscape("SA6MWA Michel Blomgren <sa6mwa@gmail.com>, https://github.com/sa6mwa/cronolizer"))
+	fmt.Fprintln(w, ".SH SEE ALSO")
+	fmt.Fprintln(w, `.BR crontab (5),`)
+	fmt.Fprintln(w, `.BR cron (8)`)
+}
@@ -0,0 +1,154 @@
+package main
+
+// A job in a config with a matrix is a template expanded into one job per
+// value, instead of near-identical copies of the same job:
+//
+//	- name: dump-{{db}}
+//	  schedule: "@daily"
+//	  command: pg_dump {{db}} > /var/backups/{{db}}.sql
+//	  matrix:
+//	    db: [customers, orders, billing]
+//
+// {{NAME}} is replaced by the value of NAME in every string of the template.
+// With several names in the matrix there is a job for every combination of
+// their values. The expanded jobs share the position of the template in the
+// config file, so their schedules can not be changed or the jobs removed in
+// the file at runtime, that is done by editing the template.
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+const matrixKey string = "matrix"
+
+var (
+	matrixName        = regexp.MustCompile(`^\w+$`)
+	matrixPlaceholder = regexp.MustCompile(`{{\s*(\w+)\s*}}`)
+)
+
+// matrixValue is one combination of the values of a matrix.
+type matrixValue map[string]string
+
+// expandMatrices replaces the jobs with a matrix in the jobs list of the
+// config document doc by their expansions. It returns the position in the
+// original jobs list of every job in the new one, and whether it was
+// expanded from a matrix, or nil if there are no jobs with a matrix.
+func expandMatrices(doc *yaml.Node) (positions []int, expanded []bool, err error) {
+	if len(doc.Content) == 0 {
+		return nil, nil, nil
+	}
+	jobs := mappingValue(doc.Content[0], "jobs")
+	if jobs == nil || jobs.Kind != yaml.SequenceNode {
+		return nil, nil, nil
+	}
+	var content []*yaml.Node
+	hasMatrix := false
+	for i, node := range jobs.Content {
+		matrix := mappingValue(node, matrixKey)
+		if matrix == nil {
+			content = append(content, node)
+			positions = append(positions, i)
+			expanded = append(expanded, false)
+			continue
+		}
+		hasMatrix = true
+		if mappingValue(node, "name") == nil {
+			return nil, nil, fmt.Errorf("job %d: a job with a %s needs a name", i+1, matrixKey)
+		}
+		values, err := matrixValues(matrix)
+		if err != nil {
+			return nil, nil, fmt.Errorf("job %d: %s: %v", i+1, matrixKey, err)
+		}
+		template := withoutKey(node, matrixKey)
+		for _, value := range values {
+			job, err := substituteMatrix(template, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("job %d: %v", i+1, err)
+			}
+			content = append(content, job)
+			positions = append(positions, i)
+			expanded = append(expanded, true)
+		}
+	}
+	if !hasMatrix {
+		return nil, nil, nil
+	}
+	jobs.Content = content
+	return positions, expanded, nil
+}
+
+// matrixValues returns every combination of the values in matrix, a mapping
+// of names to lists of values, in the order they are written.
+func matrixValues(matrix *yaml.Node) ([]matrixValue, error) {
+	if matrix.Kind != yaml.MappingNode || len(matrix.Content) == 0 {
+		return nil, errors.New("must map names to lists of values")
+	}
+	combinations := []matrixValue{{}}
+	for i := 0; i+1 < len(matrix.Content); i += 2 {
+		name, list := matrix.Content[i].Value, matrix.Content[i+1]
+		if !matrixName.MatchString(name) {
+			return nil, fmt.Errorf("%q is not a valid name", name)
+		}
+		if list.Kind != yaml.SequenceNode || len(list.Content) == 0 {
+			return nil, fmt.Errorf("%s must be a list of values", name)
+		}
+		var next []matrixValue
+		for _, combination := range combinations {
+			for _, item := range list.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s: values must be strings or numbers", name)
+				}
+				value := matrixValue{name: item.Value}
+				for k, v := range combination {
+					value[k] = v
+				}
+				next = append(next, value)
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
+
+// withoutKey returns a shallow copy of the mapping node without key.
+func withoutKey(node *yaml.Node, key string) *yaml.Node {
+	c := *node
+	c.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			c.Content = append(c.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	return &c
+}
+
+// substituteMatrix returns a deep copy of node with the placeholders in every
+// scalar replaced by their values.
+func substituteMatrix(node *yaml.Node, value matrixValue) (*yaml.Node, error) {
+	c := *node
+	if node.Kind == yaml.ScalarNode {
+		var err error
+		c.Value = matrixPlaceholder.ReplaceAllStringFunc(node.Value, func(placeholder string) string {
+			name := matrixPlaceholder.FindStringSubmatch(placeholder)[1]
+			v, ok := value[name]
+			if !ok && err == nil {
+				err = fmt.Errorf("%s is not in the %s", placeholder, matrixKey)
+			}
+			return v
+		})
+		return &c, err
+	}
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		sub, err := substituteMatrix(child, value)
+		if err != nil {
+			return nil, err
+		}
+		c.Content[i] = sub
+	}
+	return &c, nil
+}
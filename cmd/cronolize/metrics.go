@@ -0,0 +1,208 @@
+package main
+
+// A job with metrics in a config has numeric values extracted from the lines
+// of its output, turning existing scripts into metric sources without
+// changing them:
+//
+//	metrics:
+//	  - name: rows_backed_up
+//	    help: Rows in the last backup.
+//	    regex: 'backed up (\d+) rows'
+//	  - name: backup_bytes
+//	    json: stats.bytes
+//
+// A regex extracts its first group (or the whole match if it has none), a
+// json path the value at the path of a line that is a JSON object (e.g. a
+// summary printed last), array elements are selected by index such as
+// files.0.size. The last value found in a run wins. The values are pushed as
+// gauges with the other metrics of the run to the Pushgateway and included in
+// the events of notifiers publishing them.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxMetricLine is the longest line of output scanned for metrics.
+const maxMetricLine int = 64 * 1024
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// metricExtractor is an entry in the metrics of a job.
+type metricExtractor struct {
+	Name  string `yaml:"name"`
+	Help  string `yaml:"help"`
+	Regex string `yaml:"regex"`
+	JSON  string `yaml:"json"`
+
+	re   *regexp.Regexp
+	path []string
+}
+
+// prepare checks the name and compiles the regex or json path.
+func (m *metricExtractor) prepare() error {
+	if !metricNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("%q is not a valid metric name", m.Name)
+	}
+	switch {
+	case len(m.Regex) > 0 && len(m.JSON) > 0:
+		return fmt.Errorf("%s: has both a regex and a json path", m.Name)
+	case len(m.Regex) > 0:
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.Name, err)
+		}
+		m.re = re
+	case len(m.JSON) > 0:
+		path := strings.TrimPrefix(strings.TrimPrefix(m.JSON, "$"), ".")
+		if len(path) == 0 {
+			return fmt.Errorf("%s: invalid json path %q", m.Name, m.JSON)
+		}
+		m.path = strings.Split(path, ".")
+	default:
+		return fmt.Errorf("%s: needs a regex or a json path", m.Name)
+	}
+	return nil
+}
+
+// extract returns the value of the metric in line.
+func (m *metricExtractor) extract(line []byte) (float64, bool) {
+	if m.re != nil {
+		match := m.re.FindSubmatch(line)
+		if match == nil {
+			return 0, false
+		}
+		value := match[0]
+		if len(match) > 1 {
+			value = match[1]
+		}
+		f, err := strconv.ParseFloat(string(bytes.TrimSpace(value)), 64)
+		return f, err == nil
+	}
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return 0, false
+	}
+	var v any
+	if err := json.Unmarshal(line, &v); err != nil {
+		return 0, false
+	}
+	for _, key := range m.path {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return 0, false
+			}
+			v = node[i]
+		default:
+			return 0, false
+		}
+	}
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// metricScanner is an io.Writer extracting the metrics of a job from its
+// output line by line.
+type metricScanner struct {
+	mu         sync.Mutex
+	extractors []metricExtractor
+	line       []byte
+	// long is set while skipping a line longer than maxMetricLine.
+	long   bool
+	values map[string]float64
+}
+
+func newMetricScanner(extractors []metricExtractor) *metricScanner {
+	return &metricScanner{extractors: extractors, values: make(map[string]float64)}
+}
+
+func (s *metricScanner) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.append(p)
+			break
+		}
+		s.append(p[:i])
+		if !s.long {
+			s.scan(s.line)
+		}
+		s.line, s.long = s.line[:0], false
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (s *metricScanner) append(p []byte) {
+	if s.long || len(s.line)+len(p) > maxMetricLine {
+		s.line, s.long = s.line[:0], true
+		return
+	}
+	s.line = append(s.line, p...)
+}
+
+func (s *metricScanner) scan(line []byte) {
+	for i := range s.extractors {
+		if value, ok := s.extractors[i].extract(line); ok {
+			s.values[s.extractors[i].Name] = value
+		}
+	}
+}
+
+// metrics returns the values found in the output, including a last line
+// without a newline.
+func (s *metricScanner) metrics() map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.line) > 0 && !s.long {
+		s.scan(s.line)
+	}
+	s.line, s.long = nil, false
+	if len(s.values) == 0 {
+		return nil
+	}
+	values := make(map[string]float64, len(s.values))
+	for name, value := range s.values {
+		values[name] = value
+	}
+	return values
+}
+
+// prepareMetrics checks the metrics of a job, names must be unique.
+func prepareMetrics(extractors []metricExtractor) error {
+	names := make(map[string]bool)
+	for i := range extractors {
+		if err := extractors[i].prepare(); err != nil {
+			return err
+		}
+		if names[extractors[i].Name] {
+			return errors.New(extractors[i].Name + " is not unique")
+		}
+		names[extractors[i].Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+// A job with wait_for_network in a config is deferred until the network is
+// reachable, retrying every networkRetryInterval for up to network_wait (10
+// minutes by default), so sync and upload jobs do not fail just because the
+// VPN happened to be down at the scheduled minute:
+//
+//	wait_for_network: backup.example.com:22
+//	network_wait: 30m
+//
+// The target is host:port (reachable if a TCP connection can be made), an
+// http or https URL (reachable if it answers at all, whatever the status) or
+// "any" (reachable if an interface other than loopback is up with a routable
+// address). A run still waiting when network_wait has passed is skipped like
+// one whose preconditions are not met.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	anyNetwork           string        = "any"
+	defaultNetworkWait   time.Duration = 10 * time.Minute
+	networkRetryInterval time.Duration = 15 * time.Second
+	networkCheckTimeout  time.Duration = 5 * time.Second
+)
+
+// networkGate is the wait_for_network option of a job.
+type networkGate struct {
+	target string
+	isURL  bool
+	wait   time.Duration
+}
+
+// newNetworkGate checks target, wait is the network_wait option (empty for
+// the default).
+func newNetworkGate(target, wait string) (*networkGate, error) {
+	g := &networkGate{target: target, wait: defaultNetworkWait}
+	switch u, err := url.Parse(target); {
+	case target == anyNetwork:
+	case err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0:
+		g.isURL = true
+	default:
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, fmt.Errorf("%q is not host:port, an http(s) URL or %s", target, anyNetwork)
+		}
+	}
+	if len(wait) > 0 {
+		d, err := time.ParseDuration(wait)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("network_wait: invalid duration %q", wait)
+		}
+		g.wait = d
+	}
+	return g, nil
+}
+
+// check returns nil if the target is reachable.
+func (g *networkGate) check() error {
+	switch {
+	case g.target == anyNetwork:
+		return checkAnyNetwork()
+	case g.isURL:
+		client := &http.Client{Timeout: networkCheckTimeout}
+		resp, err := client.Head(g.target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	default:
+		conn, err := net.DialTimeout("tcp", g.target, networkCheckTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// checkAnyNetwork returns nil if an interface other than loopback is up with
+// a routable address.
+func checkAnyNetwork() error {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+				return nil
+			}
+		}
+	}
+	return errors.New("no network interface is up")
+}
+
+// waitFor blocks until the target is reachable, logging to logger while
+// waiting. It returns an error if it is not reachable within the wait.
+func (g *networkGate) waitFor(logger *log.Logger) error {
+	err := g.check()
+	if err == nil {
+		return nil
+	}
+	logger.Printf("Waiting up to %s for the network (%s): %v", g.wait, g.target, err)
+	started := time.Now()
+	deadline := started.Add(g.wait)
+	for remaining := g.wait; remaining > 0; remaining = time.Until(deadline) {
+		if remaining > networkRetryInterval {
+			remaining = networkRetryInterval
+		}
+		time.Sleep(remaining)
+		if err = g.check(); err == nil {
+			logger.Printf("Network (%s) reachable after %s", g.target, time.Since(started).Round(time.Second))
+			return nil
+		}
+	}
+	return fmt.Errorf("network (%s) not reachable within %s: %w", g.target, g.wait, err)
+}
@@ -0,0 +1,80 @@
+package main
+
+// With -next N, cronolize prints the next N times the cronSpec (or every job
+// of a config or crontab) is scheduled at and exits without starting
+// anything, to check a tricky expression before deploying it. Times are
+// shown in the time zone of the schedule (CRON_TZ, or the time zone of an
+// OnCalendar expression) and include -splay, but not -jitter. Runs within
+// -blackout windows are left out like those on holidays.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	nextFlag       string = "next"
+	nextTimeFormat string = "Mon 2006-01-02 15:04:05 MST"
+)
+
+// specLocation returns the time zone of a CRON_TZ= or TZ= prefix of spec,
+// time.Local if there is none.
+func specLocation(spec string) *time.Location {
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		_, name, _ := strings.Cut(fields[0], "=")
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// printNextRuns writes the next n times each job is scheduled at after now
+// and not within blackouts, prefixed by the name of the job when prefixed.
+func printNextRuns(w io.Writer, parser cron.ScheduleParser, jobs []*job, n int, now time.Time, blackouts blackoutWindows, prefixed bool) error {
+	for _, j := range jobs {
+		var prefix string
+		if prefixed {
+			prefix = j.Name + ": "
+		}
+		if len(j.Schedule) == 0 {
+			fmt.Fprintf(w, "%snot scheduled, runs on changes to %s\n", prefix, j.Watch)
+			continue
+		}
+		if isReboot(j.Schedule) {
+			fmt.Fprintf(w, "%sonly at startup\n", prefix)
+			continue
+		}
+		schedule, err := parser.Parse(j.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", j.Name, err)
+		}
+		loc := specLocation(j.Schedule)
+		t := now.In(loc)
+		for i := 0; i < n; {
+			if t = schedule.Next(t); t.IsZero() {
+				fmt.Fprintf(w, "%sno more runs\n", prefix)
+				break
+			}
+			if blackouts.check(t.Local()) != nil {
+				// The runs within the blackout are skipped all at once,
+				// the schedule continues from the end of it.
+				end := blackouts.end(t.Local())
+				if end.IsZero() {
+					fmt.Fprintf(w, "%sno runs outside the blackout windows\n", prefix)
+					break
+				}
+				t = end.Add(-time.Nanosecond).In(loc)
+				continue
+			}
+			fmt.Fprintf(w, "%s%s\n", prefix, t.Format(nextTimeFormat))
+			i++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestPrintNextRuns(t *testing.T) {
+	// Thursday.
+	now := time.Date(2026, 1, 8, 13, 0, 0, 0, time.Local)
+	format := func(times ...time.Time) string {
+		var lines []string
+		for _, t := range times {
+			lines = append(lines, t.Format(nextTimeFormat))
+		}
+		return strings.Join(lines, "\n")
+	}
+	day := func(d, hour, min int) time.Time {
+		return time.Date(2026, 1, d, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name      string
+		spec      string
+		blackouts []string
+		n         int
+		want      string
+	}{
+		{
+			name: "no blackout",
+			spec: "0 12 * * *",
+			n:    3,
+			want: format(day(9, 12, 0), day(10, 12, 0), day(11, 12, 0)),
+		},
+		{
+			name:      "weekend",
+			spec:      "0 12 * * *",
+			blackouts: []string{"SAT,SUN"},
+			n:         3,
+			want:      format(day(9, 12, 0), day(12, 12, 0), day(13, 12, 0)),
+		},
+		{
+			name:      "past midnight",
+			spec:      "30 * * * *",
+			blackouts: []string{"14:00-06:00"},
+			n:         3,
+			want:      format(day(8, 13, 30), day(9, 6, 30), day(9, 7, 30)),
+		},
+		{
+			name:      "several windows",
+			spec:      "0 9 * * *",
+			blackouts: []string{"FRI", "SAT,SUN 08:00-10:00", "MON 00:00-09:30"},
+			n:         2,
+			want:      format(day(13, 9, 0), day(14, 9, 0)),
+		},
+		{
+			name:      "every second",
+			spec:      "* * * * * *",
+			blackouts: []string{"THU 13:00-00:00", "FRI-SUN"},
+			n:         2,
+			want:      format(day(12, 0, 0), time.Date(2026, 1, 12, 0, 0, 1, 0, time.Local)),
+		},
+		{
+			name:      "always blacked out",
+			spec:      "*/5 * * * *",
+			blackouts: []string{"MON-SUN"},
+			n:         1,
+			want:      "no runs outside the blackout windows",
+		},
+	}
+	parser := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var blackouts blackoutWindows
+			for _, b := range test.blackouts {
+				if err := blackouts.Set(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var out bytes.Buffer
+			jobs := []*job{{Name: "1", Schedule: test.spec}}
+			if err := printNextRuns(&out, parser, jobs, test.n, now, blackouts, false); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(out.String()); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
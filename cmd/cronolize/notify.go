@@ -0,0 +1,348 @@
+package main
+
+// Notifiers (Sentry and friends) are handed every finished run and decide
+// themselves what to report, e.g. only failures. They run in the background
+// so a slow endpoint never holds up a job, errors are logged to the job's
+// log.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runResult describes a finished run.
+type runResult struct {
+	job      *job
+	started  time.Time
+	duration time.Duration
+	err      error
+	// exitCode is the exit status of the command, -1 if it was not started
+	// or was killed by a signal.
+	exitCode int
+	// output is the tail of the run's combined output and lines the
+	// number of lines of the complete output.
+	output []byte
+	lines  int
+	// outputFile is where the complete output of a failed run was saved
+	// with -attach-output, empty if it was not.
+	outputFile string
+	// suppressed is set if an alert about an identical failure was sent
+	// within -notify-window, occurrences is the number of identical failures
+	// since firstOccurrence (including this one) that were not alerted.
+	suppressed      bool
+	occurrences     int
+	firstOccurrence time.Time
+	// consecutiveFailures is the number of failed runs in a row including
+	// this one. recovered is set on the first successful run after
+	// previousFailures failures in a row if -notify-recovery is given.
+	consecutiveFailures int
+	recovered           bool
+	previousFailures    int
+	// skipped is set if the run was not started because its preconditions
+	// were not met, err is why.
+	skipped bool
+	// metrics are the values extracted from the output, see metrics.go.
+	metrics map[string]float64
+}
+
+func newRunResult(j *job, started time.Time, err error, tail *outputTail) runResult {
+	output, lines := tail.Bytes(), tail.lines()
+	r := runResult{
+		job:      j,
+		started:  started,
+		duration: time.Since(started),
+		err:      err,
+		output:   output,
+		lines:    lines,
+	}
+	r.exitCode = exitCode(err)
+	return r
+}
+
+// exitCode is the exit status of the command of a run that returned err, -1
+// if it was not started or was killed by a signal.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return -1
+	}
+}
+
+func (r runResult) failed() bool {
+	return r.err != nil
+}
+
+// alert reports whether notifiers reporting failures (and recoveries) should
+// alert about r.
+func (r runResult) alert() bool {
+	return r.recovered || (r.failed() && !r.suppressed)
+}
+
+// title returns a one-line summary of a failed or recovered run for alerts.
+func (r runResult) title() string {
+	hostname, _ := os.Hostname()
+	if r.recovered {
+		return fmt.Sprintf("cronolize job %s recovered on %s after %d failure(s)", r.job.Name, hostname, r.previousFailures)
+	}
+	if r.skipped {
+		return fmt.Sprintf("cronolize job %s skipped on %s, precondition not met", r.job.Name, hostname)
+	}
+	if r.occurrences > 1 {
+		return fmt.Sprintf("cronolize job %s still failing on %s, %d occurrences since %s",
+			r.job.Name, hostname, r.occurrences, r.firstOccurrence.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("cronolize job %s failed on %s", r.job.Name, hostname)
+}
+
+// alertState keeps track of the failures of each job. It counts consecutive
+// failures for escalation and recovery alerts and rate-limits alerts about
+// identical failures (same job, exit code and error) to one per window. The
+// next identical failure after the window is alerted as still failing with
+// the number of occurrences in between, a successful run or a different
+// failure starts over.
+type alertState struct {
+	mu       sync.Mutex
+	window   time.Duration
+	recovery bool
+	jobs     map[*job]*jobAlertState
+	streaks  map[*job]int
+}
+
+type jobAlertState struct {
+	signature   string
+	alerted     time.Time
+	first       time.Time
+	occurrences int
+}
+
+func newAlertState(window time.Duration, recovery bool) *alertState {
+	return &alertState{
+		window:   window,
+		recovery: recovery,
+		jobs:     make(map[*job]*jobAlertState),
+		streaks:  make(map[*job]int),
+	}
+}
+
+// check sets the alerting fields of r.
+func (t *alertState) check(r *runResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// A skipped run did not run, it neither adds to a failure streak
+	// (for escalation) nor ends one (as a recovery).
+	if r.skipped {
+		return
+	}
+	if !r.failed() {
+		if previous := t.streaks[r.job]; previous > 0 && t.recovery {
+			r.recovered = true
+			r.previousFailures = previous
+		}
+		delete(t.streaks, r.job)
+		delete(t.jobs, r.job)
+		return
+	}
+	t.streaks[r.job]++
+	r.consecutiveFailures = t.streaks[r.job]
+	if t.window <= 0 {
+		return
+	}
+	signature := fmt.Sprintf("%d %v", r.exitCode, r.err)
+	state, ok := t.jobs[r.job]
+	if !ok || state.signature != signature {
+		t.jobs[r.job] = &jobAlertState{signature: signature, alerted: r.started}
+		return
+	}
+	if state.occurrences == 0 {
+		state.first = r.started
+	}
+	state.occurrences++
+	if r.started.Sub(state.alerted) < t.window {
+		r.suppressed = true
+		return
+	}
+	r.occurrences = state.occurrences
+	r.firstOccurrence = state.first
+	state.alerted = r.started
+	state.occurrences = 0
+}
+
+// runEvent is the JSON representation of a finished run sent by notifiers
+// publishing events, fields are only ever added.
+type runEvent struct {
+	Job             string    `json:"job"`
+	Command         string    `json:"command"`
+	Schedule        string    `json:"schedule"`
+	Tags            []string  `json:"tags,omitempty"`
+	Host            string    `json:"host"`
+	PID             int       `json:"pid"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ExitCode        int       `json:"exit_code"`
+	Success         bool      `json:"success"`
+	Skipped         bool      `json:"skipped,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	// Metrics are the values extracted from the output of the run.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+func (r runResult) event() runEvent {
+	hostname, _ := os.Hostname()
+	e := runEvent{
+		Job:             r.job.Name,
+		Command:         r.job.displayCommand(),
+		Schedule:        r.job.spec(),
+		Tags:            r.job.Tags,
+		Host:            hostname,
+		PID:             os.Getpid(),
+		Started:         r.started,
+		DurationSeconds: r.duration.Seconds(),
+		ExitCode:        r.exitCode,
+		Success:         !r.failed(),
+		Skipped:         r.skipped,
+		Metrics:         r.metrics,
+	}
+	if r.err != nil {
+		e.Error = r.err.Error()
+	}
+	return e
+}
+
+type notifier interface {
+	name() string
+	notify(r runResult) error
+}
+
+// notifyAll hands r to every notifier in a goroutine of its own, after
+// deciding whether to suppress alerting about it.
+func notifyAll(notifiers []notifier, t *alertState, r runResult) {
+	t.check(&r)
+	for _, n := range notifiers {
+		n := n
+		go func() {
+			defer reportPanic()
+			if err := n.notify(r); err != nil {
+				r.job.logger.Print(colorize("Error:", ansiBold, ansiRed), " ", n.name(), ": ", err)
+			}
+		}()
+	}
+}
+
+// outputTail keeps the last captureTailSize bytes of a run's output and counts
+// its lines, it may be written to concurrently.
+type outputTail struct {
+	mu        sync.Mutex
+	ring      *ringBuffer
+	lineCount int
+	partial   bool
+}
+
+func newOutputTail() *outputTail {
+	return &outputTail{ring: newRingBuffer(captureTailSize)}
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(p) > 0 {
+		t.lineCount += bytes.Count(p, []byte{'\n'})
+		t.partial = p[len(p)-1] != '\n'
+	}
+	return t.ring.Write(p)
+}
+
+// lines returns the number of lines written, counting an unterminated last
+// line.
+func (t *outputTail) lines() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.partial {
+		return t.lineCount + 1
+	}
+	return t.lineCount
+}
+
+func (t *outputTail) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ring.Bytes()
+}
+
+// outputRetention is how long output saved by -attach-output is kept.
+const outputRetention time.Duration = 7 * 24 * time.Hour
+
+// saveOutput saves the complete output of a failed run of j in the output
+// directory of the state directory and removes files older than
+// outputRetention.
+func saveOutput(j *job, started time.Time, output *outputCapture) (string, error) {
+	dir := filepath.Join(stateDir(), "output")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > outputRetention {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r < ' ' {
+			return '_'
+		}
+		return r
+	}, j.Name)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s-%d.log", name, started.Format("20060102T150405"), os.Getpid()))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := output.WriteTo(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+var (
+	panicHandlersMu sync.Mutex
+	panicHandlers   []func(v any, stack []byte)
+)
+
+// onPanic registers fn to be called with the value and stack of a panic in a
+// goroutine deferring reportPanic, before the process crashes.
+func onPanic(fn func(v any, stack []byte)) {
+	panicHandlersMu.Lock()
+	defer panicHandlersMu.Unlock()
+	panicHandlers = append(panicHandlers, fn)
+}
+
+// reportPanic must be deferred, it hands a panic to the onPanic handlers and
+// then panics again.
+func reportPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	stack := debug.Stack()
+	panicHandlersMu.Lock()
+	handlers := panicHandlers
+	panicHandlersMu.Unlock()
+	for _, fn := range handlers {
+		fn(v, stack)
+	}
+	panic(v)
+}
@@ -0,0 +1,44 @@
+package main
+
+// -overlap (or overlap of a job in a config) decides what happens when a job
+// fires while a run of it has not finished yet, see cronolizer.OverlapPolicy:
+//
+//	allow  start another run next to it (the default)
+//	skip   skip the new run, it is logged and counted as skipped
+//	queue  start the new run when the previous one has finished
+//	kill   kill the previous run (SIGTERM to its command and everything it
+//	       started, recorded as cancelled) and start the new one when it
+//	       has exited
+//
+// Retries of a failed run (see retries.go) belong to the run, a new run
+// overlaps them as it would the run itself.
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const overlapFlag string = "overlap"
+
+// killJob signals every run of j and returns how many there were. by is who
+// killed them.
+func (a *activeRuns) killJob(j *job, sig syscall.Signal, by string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, run := range a.runs {
+		if run.job != j {
+			continue
+		}
+		if err := run.process.Signal(sig); err != nil {
+			continue
+		}
+		if run.frozen {
+			run.process.Signal(syscall.SIGCONT)
+			run.frozen = false
+		}
+		run.cancelled = fmt.Sprintf("%s by %s", sigName(sig), by)
+		n++
+	}
+	return n
+}
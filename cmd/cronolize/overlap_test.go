@@ -0,0 +1,28 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestActiveRunsKillJob(t *testing.T) {
+	slow, other := &job{Name: "slow"}, &job{Name: "other"}
+	p, child := startOrphaning(t)
+	otherP, otherChild := startOrphaning(t)
+	runs := newActiveRuns()
+	runs.add(&activeRun{id: 1, job: slow, process: p})
+	runs.add(&activeRun{id: 2, job: other, process: otherP})
+	if n := runs.killJob(slow, syscall.SIGTERM, "overlap policy"); n != 1 {
+		t.Errorf("killJob() = %d, want 1", n)
+	}
+	waitExited(t, p, child)
+	if exited(otherChild) {
+		t.Error("killing a job killed the run of another")
+	}
+	if got, want := runs.remove(1), "SIGTERM by overlap policy"; got != want {
+		t.Errorf("remove(1) = %q, want %q", got, want)
+	}
+	if got := runs.remove(2); got != "" {
+		t.Errorf("remove(2) = %q, want the run not cancelled", got)
+	}
+}
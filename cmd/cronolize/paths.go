@@ -0,0 +1,129 @@
+package main
+
+// Default locations follow the XDG Base Directory Specification. Files that
+// only exist while a cron process is running (status files and control
+// sockets) live in the runtime directory, files that outlive it (such as the
+// saved output of failed runs) in the state directory. When running as root
+// the system locations under /run and /var/lib are used instead. With
+// -statedir, everything goes in that single directory which makes it easy to
+// run isolated daemons side by side. Log files are not kept in any of them,
+// relative log paths are relative to the working directory.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	appName      string = "cronolize"
+	stateDirFlag string = "statedir"
+)
+
+// stateDirOverride is the -statedir option, it replaces the runtime, state
+// and log directories when set.
+var stateDirOverride string
+
+// addStateDirFlag registers -statedir on fs.
+func addStateDirFlag(fs *flag.FlagSet) {
+	fs.StringVar(&stateDirOverride, stateDirFlag, "", "Keep status files, control sockets and state in this `directory` instead of the default locations")
+}
+
+// runtimeDir returns the directory where status files and control sockets
+// are kept: $XDG_RUNTIME_DIR/cronolize, /run/cronolize as root or a per-user
+// directory in the temporary directory if XDG_RUNTIME_DIR is not set.
+func runtimeDir() string {
+	if len(stateDirOverride) > 0 {
+		return stateDirOverride
+	}
+	if os.Geteuid() == 0 {
+		return filepath.Join("/run", appName)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appName)
+	}
+	return tempRuntimeDir()
+}
+
+// tempRuntimeDir returns the runtime directory used when XDG_RUNTIME_DIR is
+// not set.
+func tempRuntimeDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d", appName, os.Getuid()))
+}
+
+// makeRuntimeDir creates dir, the runtime directory or the directory of a
+// control socket, if it does not exist. The runtime directory in the
+// temporary directory has a predictable name in a directory anyone can write
+// to, another user could create it first to get hold of the control sockets
+// and status files, so it is only used if it is a directory (not a symlink)
+// owned by this user and inaccessible to others.
+func makeRuntimeDir(dir string) error {
+	if dir != tempRuntimeDir() {
+		return os.MkdirAll(dir, 0700)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is owned by another user (uid %d)", dir, stat.Uid)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("%s has mode %04o, expected 0700", dir, perm)
+	}
+	return nil
+}
+
+// runtimeDirs returns the runtime directories of all users of the host that
+// exist, starting with this user's. Only the directories readable by this
+// user can be searched for daemons, i.e. all of them as root.
+func runtimeDirs() []string {
+	candidates := []string{runtimeDir(), filepath.Join("/run", appName)}
+	for _, pattern := range []string{
+		filepath.Join("/run", "user", "*", appName),
+		filepath.Join(os.TempDir(), appName+"-*"),
+	} {
+		if matches, err := filepath.Glob(pattern); err == nil {
+			candidates = append(candidates, matches...)
+		}
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, dir := range candidates {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// stateDir returns the directory where persistent state is kept:
+// $XDG_STATE_HOME/cronolize (~/.local/state/cronolize by default) or
+// /var/lib/cronolize as root.
+func stateDir() string {
+	if len(stateDirOverride) > 0 {
+		return stateDirOverride
+	}
+	if os.Geteuid() == 0 {
+		return filepath.Join("/var/lib", appName)
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appName)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", appName)
+	}
+	return runtimeDir()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID returns the uid of the process at the other end of a unix domain
+// socket connection, -1 if it can not be determined.
+func peerUID(conn net.Conn) int {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	uid := -1
+	raw.Control(func(fd uintptr) {
+		if cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED); err == nil {
+			uid = int(cred.Uid)
+		}
+	})
+	return uid
+}
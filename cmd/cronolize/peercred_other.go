@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// peerUID returns -1, peer credentials are only looked up on Linux.
+func peerUID(conn net.Conn) int {
+	return -1
+}
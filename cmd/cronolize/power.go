@@ -0,0 +1,61 @@
+package main
+
+// Heavy jobs (backups, indexing) can be kept from draining a laptop's battery.
+// A job with
+//
+//	only_on_ac: true
+//
+// in a config is skipped while running on battery, one with
+//
+//	min_battery: 40%
+//
+// only while on battery with less than 40% charge left. The skip is logged
+// and counted in the status, but not alerted about, it is expected. A host
+// without a battery is always on AC. The power source is read from
+// /sys/class/power_supply on Linux and using pmset(1) on macOS, elsewhere
+// every host counts as being on AC.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// powerState is the power source of the host.
+type powerState struct {
+	onAC bool
+	// battery is the charge left in percent, -1 if there is no battery.
+	battery int
+}
+
+// parseBatteryPercent parses the min_battery option, e.g. 40% or 40.
+func parseBatteryPercent(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")))
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("%q is not a percentage", s)
+	}
+	return n, nil
+}
+
+// checkPower returns why the job should not run on the current power source,
+// nil if it can run.
+func (j *job) checkPower() error {
+	if !j.OnlyOnAC && j.minBattery == 0 {
+		return nil
+	}
+	state, err := readPowerState()
+	if err != nil {
+		// Better to run than to skip because of not knowing.
+		j.logger.Print(colorize("Error:", ansiBold, ansiRed), " reading the power source: ", err)
+		return nil
+	}
+	switch {
+	case state.onAC:
+		return nil
+	case j.OnlyOnAC:
+		return fmt.Errorf("running on battery (%d%%)", state.battery)
+	case state.battery >= 0 && state.battery < j.minBattery:
+		return fmt.Errorf("running on battery with %d%% left, less than %d%%", state.battery, j.minBattery)
+	}
+	return nil
+}
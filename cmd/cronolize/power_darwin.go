@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var pmsetPercent = regexp.MustCompile(`(\d+)%`)
+
+// readPowerState parses the output of `pmset -g batt`, e.g.
+//
+//	Now drawing from 'Battery Power'
+//	 -InternalBattery-0 (id=1234)	85%; discharging; 4:12 remaining present: true
+func readPowerState() (powerState, error) {
+	state := powerState{battery: -1}
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return state, err
+	}
+	lines := strings.Split(string(out), "\n")
+	state.onAC = !strings.Contains(lines[0], "Battery Power")
+	for _, line := range lines[1:] {
+		if m := pmsetPercent.FindStringSubmatch(line); m != nil {
+			state.battery, _ = strconv.Atoi(m[1])
+			break
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const powerSupplyDir string = "/sys/class/power_supply"
+
+// readPowerState reads the power supplies in sysfs. The host is on AC if a
+// mains (or USB) supply is online, or if no battery is discharging when
+// there is no such supply.
+func readPowerState() (powerState, error) {
+	state := powerState{battery: -1}
+	entries, err := os.ReadDir(powerSupplyDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		state.onAC = true
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	read := func(supply, name string) string {
+		data, _ := os.ReadFile(filepath.Join(powerSupplyDir, supply, name))
+		return strings.TrimSpace(string(data))
+	}
+	mains, online, discharging := false, false, false
+	for _, entry := range entries {
+		switch read(entry.Name(), "type") {
+		case "Mains", "USB":
+			mains = true
+			online = online || read(entry.Name(), "online") == "1"
+		case "Battery":
+			// Peripherals such as mice report their batteries too.
+			if read(entry.Name(), "scope") == "Device" {
+				continue
+			}
+			if n, err := strconv.Atoi(read(entry.Name(), "capacity")); err == nil && (state.battery < 0 || n < state.battery) {
+				state.battery = n
+			}
+			discharging = discharging || read(entry.Name(), "status") == "Discharging"
+		}
+	}
+	state.onAC = online || (!mains && !discharging)
+	return state, nil
+}
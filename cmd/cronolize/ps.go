@@ -0,0 +1,69 @@
+package main
+
+// `cronolize ps` discovers the cronolize daemons of all users on the host by
+// searching every runtime directory (see paths.go) for status files, showing
+// what has been cronolized where. Only root can read the runtime directories
+// of other users, anyone else sees their own daemons. Daemons started with
+// -statedir are found by passing the same -statedir to ps.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+const psCommand string = "ps"
+
+// psCmd implements `cronolize ps [-json]`.
+func psCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(psCommand, flag.ExitOnError)
+	asJSON := cmdFlags.Bool("json", false, "Output as JSON")
+	addStateDirFlag(cmdFlags)
+	cmdFlags.Parse(args)
+	dirs := runtimeDirs()
+	statuses := make([]daemonStatus, 0)
+	unreadable := 0
+	for _, dir := range dirs {
+		if _, err := os.ReadDir(dir); errors.Is(err, fs.ErrPermission) {
+			unreadable++
+			continue
+		}
+		found, err := readStatusesIn(dir)
+		if err != nil {
+			fatal(err)
+		}
+		statuses = append(statuses, found...)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].PID < statuses[j].PID
+	})
+	if *asJSON {
+		printJSON(statuses)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "PID\tUSER\tVERSION\tSTARTED\tMODE\tJOBS\tCONFIG")
+		for _, status := range statuses {
+			mode := "daemon"
+			if status.Foreground {
+				mode = "foreground"
+			}
+			user := status.User
+			if len(user) == 0 {
+				user = "-"
+			}
+			config := status.Config
+			if len(config) == 0 {
+				config = "-"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n", status.PID, user, status.Version, formatTime(&status.Started), mode, len(status.Jobs), config)
+		}
+		tw.Flush()
+	}
+	if unreadable > 0 {
+		pe("Warning: %d runtime directories of other users are not readable, run as root to see all daemons", unreadable)
+	}
+}
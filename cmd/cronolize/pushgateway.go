@@ -0,0 +1,120 @@
+package main
+
+// With -pushgateway (or pushgateway per job in a config), the metrics of every
+// run are pushed to a Prometheus Pushgateway, for hosts without a scrapeable
+// endpoint. Each job is its own group, labelled job (the job name) and
+// instance (the hostname). The metrics of a job with tags are labelled tags,
+// the tags separated and surrounded by commas (e.g. ",backup,nightly,") so
+// they are selected by tag with tags=~".*,backup,.*". Metrics are pushed using
+// POST so the last success timestamp (and metrics extracted from the output)
+// survive failed runs.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	pushgatewayFlag    string        = "pushgateway"
+	pushgatewayTimeout time.Duration = 10 * time.Second
+)
+
+// helpEscaper escapes the HELP text of a metric.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// labelEscaper escapes the value of a label.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+type pushgatewayNotifier struct {
+	// url is the default Pushgateway, jobs may have their own.
+	url      string
+	instance string
+	client   *http.Client
+}
+
+func newPushgatewayNotifier(url string) *pushgatewayNotifier {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &pushgatewayNotifier{
+		url:      url,
+		instance: hostname,
+		client:   &http.Client{Timeout: pushgatewayTimeout},
+	}
+}
+
+func (p *pushgatewayNotifier) name() string {
+	return "Pushgateway"
+}
+
+func (p *pushgatewayNotifier) notify(r runResult) error {
+	base := p.url
+	if len(r.job.Pushgateway) > 0 {
+		base = r.job.Pushgateway
+	}
+	// The metrics are about runs, a skipped run did not run.
+	if len(base) == 0 || r.skipped {
+		return nil
+	}
+	success := 0
+	if !r.failed() {
+		success = 1
+	}
+	var labels string
+	if len(r.job.Tags) > 0 {
+		labels = `{tags="` + labelEscaper.Replace(","+strings.Join(r.job.Tags, ",")+",") + `"}`
+	}
+	var body bytes.Buffer
+	metric := func(name, help string, value any) {
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s%s %v\n", name, help, name, name, labels, value)
+	}
+	metric("cronolize_last_run_timestamp_seconds", "Start time of the last run.", r.started.Unix())
+	metric("cronolize_last_run_duration_seconds", "Duration of the last run.", r.duration.Seconds())
+	metric("cronolize_last_run_exit_code", "Exit code of the last run, -1 if it did not exit normally.", r.exitCode)
+	metric("cronolize_last_run_success", "1 if the last run succeeded, 0 if it failed.", success)
+	if success == 1 {
+		metric("cronolize_last_success_timestamp_seconds", "Start time of the last successful run.", r.started.Unix())
+	}
+	for _, m := range r.job.Metrics {
+		if value, ok := r.metrics[m.Name]; ok {
+			help := helpEscaper.Replace(m.Help)
+			if len(help) == 0 {
+				help = "Extracted from the output of the last run."
+			}
+			metric(m.Name, help, value)
+		}
+	}
+	endpoint := strings.TrimSuffix(base, "/") + "/metrics/" + groupingKey("job", r.job.Name) + "/" + groupingKey("instance", p.instance)
+	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// groupingKey returns a label/value path element of a Pushgateway URL, values
+// containing a slash (or empty ones) are base64 encoded.
+func groupingKey(label, value string) string {
+	if len(value) == 0 || strings.Contains(value, "/") {
+		return label + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return label + "/" + url.PathEscape(value)
+}
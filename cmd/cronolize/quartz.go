@@ -0,0 +1,330 @@
+package main
+
+// With -quartz, schedules are Quartz cron expressions instead of standard
+// ones: six or seven fields, seconds first and an optional year last,
+//
+//	SECONDS MINUTES HOURS DAY-OF-MONTH MONTH DAY-OF-WEEK [YEAR]
+//
+// where the day of the week is 1-7 (SUN-SAT) and one of the day fields must
+// be ?. Besides the usual *, lists, ranges and steps, the day of the month may
+// be L (the last day), L-3 (three days before the last), 15W (the weekday
+// nearest the 15th, within the month) or LW (the last weekday), and the day of
+// the week 6L (the last Friday of the month) or 6#3 (the third Friday). E.g.
+// "0 0 18 ? * 6L" runs at 18:00 on the last Friday of every month and
+// "0 30 9 LW * ? 2027" at 09:30 on the last weekday of every month in 2027.
+// Descriptors such as @daily and @every 10s still work.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const quartzFlag string = "quartz"
+
+var quartzMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var quartzWeekdays = map[string]int{
+	"sun": 1, "mon": 2, "tue": 3, "wed": 4, "thu": 5, "fri": 6, "sat": 7,
+}
+
+// quartzSchedule is a parsed Quartz expression. The seconds to the months
+// and the years are matched like an OnCalendar expression, the days by the
+// day of the month or of the week fields (a nil field matches every day).
+type quartzSchedule struct {
+	calendarSchedule
+	monthDays *quartzMonthDays
+	weekDays  *quartzWeekDays
+}
+
+// quartzMonthDays is a day of the month field. fromLast are days counted
+// from the last day of the month (0 is the last day, L-3 is 3).
+type quartzMonthDays struct {
+	days        uint64
+	fromLast    uint64
+	nearest     uint64
+	lastWeekday bool
+}
+
+// quartzWeekDays is a day of the week field, indexed by time.Weekday. nth
+// has bit n set for the nth weekday of the month.
+type quartzWeekDays struct {
+	weekdays uint64
+	last     uint64
+	nth      [7]uint64
+}
+
+func (s quartzSchedule) Next(t time.Time) time.Time {
+	return s.next(t, s.matchDay)
+}
+
+func (s quartzSchedule) matchDay(t time.Time) bool {
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return s.monthDays.match(t, lastDay) && s.weekDays.match(t, lastDay)
+}
+
+func (m *quartzMonthDays) match(t time.Time, lastDay int) bool {
+	if m == nil {
+		return true
+	}
+	day := t.Day()
+	if m.days&(1<<uint(day)) != 0 || m.fromLast&(1<<uint(lastDay-day)) != 0 {
+		return true
+	}
+	if m.lastWeekday && day == nearestWeekday(t, lastDay, lastDay) {
+		return true
+	}
+	for d := 1; d <= lastDay; d++ {
+		if m.nearest&(1<<uint(d)) != 0 && day == nearestWeekday(t, d, lastDay) {
+			return true
+		}
+	}
+	return false
+}
+
+// nearestWeekday returns the weekday (Monday to Friday) nearest day in the
+// month of t, without leaving the month.
+func nearestWeekday(t time.Time, day, lastDay int) int {
+	switch time.Date(t.Year(), t.Month(), day, 12, 0, 0, 0, time.UTC).Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == lastDay {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
+
+func (w *quartzWeekDays) match(t time.Time, lastDay int) bool {
+	if w == nil {
+		return true
+	}
+	weekday := t.Weekday()
+	if w.weekdays&(1<<uint(weekday)) != 0 {
+		return true
+	}
+	if w.last&(1<<uint(weekday)) != 0 && t.Day()+7 > lastDay {
+		return true
+	}
+	return w.nth[weekday]&(1<<uint((t.Day()+6)/7)) != 0
+}
+
+// parseQuartzSchedule parses a Quartz expression, optionally preceded by
+// CRON_TZ= or TZ= and a time zone like a standard one.
+func parseQuartzSchedule(spec string) (cron.Schedule, error) {
+	s := quartzSchedule{calendarSchedule: calendarSchedule{years: make([]bool, calendarMaxYear-calendarMinYear+1)}}
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		_, name, _ := strings.Cut(fields[0], "=")
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+		s.location = location
+		fields = fields[1:]
+	}
+	if len(fields) != 6 && len(fields) != 7 {
+		return nil, fmt.Errorf("%q: expected 6 or 7 fields (seconds, minutes, hours, day of month, month, day of week and optionally year), found %d", spec, len(fields))
+	}
+	if len(fields) == 6 {
+		fields = append(fields, "*")
+	}
+	var err error
+	sets := []struct {
+		set      *uint64
+		name     string
+		min, max int
+		names    map[string]int
+	}{
+		{&s.seconds, "second", 0, 59, nil},
+		{&s.minutes, "minute", 0, 59, nil},
+		{&s.hours, "hour", 0, 23, nil},
+		{&s.months, "month", 1, 12, quartzMonths},
+	}
+	for i, field := range []string{fields[0], fields[1], fields[2], fields[4]} {
+		if *sets[i].set, err = quartzSet(field, sets[i].name, sets[i].min, sets[i].max, sets[i].names); err != nil {
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+	}
+	years, err := quartzValues(fields[6], "year", calendarMinYear, calendarMaxYear, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	for _, year := range years {
+		s.years[year-calendarMinYear] = true
+	}
+	if s.monthDays, err = parseQuartzMonthDays(fields[3]); err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	if s.weekDays, err = parseQuartzWeekDays(fields[5]); err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	if s.monthDays != nil && s.weekDays != nil {
+		return nil, fmt.Errorf("%q: the day of month and the day of week can not both be given, use ? for one of them", spec)
+	}
+	return s, nil
+}
+
+// parseQuartzMonthDays parses a day of the month field, nil if it matches
+// every day.
+func parseQuartzMonthDays(field string) (*quartzMonthDays, error) {
+	if field == "?" || field == "*" {
+		return nil, nil
+	}
+	m := &quartzMonthDays{}
+	for _, item := range strings.Split(strings.ToUpper(field), ",") {
+		switch {
+		case item == "L":
+			m.fromLast |= 1
+		case item == "LW":
+			m.lastWeekday = true
+		case strings.HasPrefix(item, "L-"):
+			offset, err := strconv.Atoi(item[2:])
+			if err != nil || offset < 1 || offset > 30 {
+				return nil, fmt.Errorf("%q is not a day before the last day of the month", item)
+			}
+			m.fromLast |= 1 << uint(offset)
+		case strings.HasSuffix(item, "W"):
+			day, err := strconv.Atoi(strings.TrimSuffix(item, "W"))
+			if err != nil || day < 1 || day > 31 {
+				return nil, fmt.Errorf("%q is not a weekday nearest a day of the month", item)
+			}
+			m.nearest |= 1 << uint(day)
+		default:
+			days, err := quartzSet(item, "day of month", 1, 31, nil)
+			if err != nil {
+				return nil, err
+			}
+			m.days |= days
+		}
+	}
+	return m, nil
+}
+
+// parseQuartzWeekDays parses a day of the week field, nil if it matches
+// every day.
+func parseQuartzWeekDays(field string) (*quartzWeekDays, error) {
+	if field == "?" || field == "*" {
+		return nil, nil
+	}
+	w := &quartzWeekDays{}
+	weekday := func(s string) (time.Weekday, error) {
+		v, err := quartzValue(s, "day of week", 1, 7, quartzWeekdays)
+		return time.Weekday(v - 1), err
+	}
+	for _, item := range strings.Split(strings.ToUpper(field), ",") {
+		if day, n, nth := strings.Cut(item, "#"); nth {
+			d, err := weekday(day)
+			if err != nil {
+				return nil, err
+			}
+			i, err := strconv.Atoi(n)
+			if err != nil || i < 1 || i > 5 {
+				return nil, fmt.Errorf("%q is not a week of the month (1-5)", n)
+			}
+			w.nth[d] |= 1 << uint(i)
+			continue
+		}
+		if item == "L" {
+			item = "7"
+		} else if strings.HasSuffix(item, "L") {
+			d, err := weekday(strings.TrimSuffix(item, "L"))
+			if err != nil {
+				return nil, err
+			}
+			w.last |= 1 << uint(d)
+			continue
+		}
+		values, err := quartzValues(item, "day of week", 1, 7, quartzWeekdays)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			w.weekdays |= 1 << uint(v-1)
+		}
+	}
+	return w, nil
+}
+
+func quartzSet(field, name string, min, max int, names map[string]int) (uint64, error) {
+	values, err := quartzValues(field, name, min, max, names)
+	var set uint64
+	for _, v := range values {
+		set |= 1 << uint(v)
+	}
+	return set, err
+}
+
+func quartzValue(s, name string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%s %q is not within %d-%d", name, s, min, max)
+	}
+	return v, nil
+}
+
+// quartzValues returns the values of a field such as *, 5, 1-5, */15, 10/5 or
+// a list of them.
+func quartzValues(field, name string, min, max int, names map[string]int) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(field, ",") {
+		item, repetition, repeated := strings.Cut(item, "/")
+		step := 1
+		if repeated {
+			var err error
+			if step, err = strconv.Atoi(repetition); err != nil || step <= 0 {
+				return nil, fmt.Errorf("%q is not a step", repetition)
+			}
+		}
+		from, to := min, max
+		if item != "*" && item != "?" {
+			first, last, isRange := strings.Cut(item, "-")
+			var err error
+			if from, err = quartzValue(first, name, min, max, names); err != nil {
+				return nil, err
+			}
+			switch {
+			case isRange:
+				if to, err = quartzValue(last, name, min, max, names); err != nil {
+					return nil, err
+				}
+				if from > to {
+					return nil, fmt.Errorf("%q is not a %s range", item, name)
+				}
+			case !repeated:
+				to = from
+			}
+		}
+		for v := from; v <= to; v += step {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// quartzParser parses Quartz expressions, and descriptors (starting with @)
+// and recurrence rules using parser.
+type quartzParser struct {
+	parser cron.ScheduleParser
+}
+
+func (p quartzParser) Parse(spec string) (cron.Schedule, error) {
+	if strings.HasPrefix(strings.TrimSpace(spec), "@") || isRRule(spec) {
+		return p.parser.Parse(spec)
+	}
+	return parseQuartzSchedule(spec)
+}
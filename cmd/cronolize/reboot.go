@@ -0,0 +1,19 @@
+package main
+
+// A job scheduled @reboot runs once when the daemon starts and never again,
+// like @reboot in a crontab (which cron runs when crond starts, at boot), so
+// user crontabs mixing @reboot with timed entries can be run as they are.
+// `cronolize -on-start command` is the same as `cronolize @reboot command`. A
+// process taking over after `cronolize upgrade` does not run them again.
+
+import "strings"
+
+const (
+	rebootDescriptor string = "@reboot"
+	onStartFlag      string = "on-start"
+)
+
+// isReboot reports whether spec is @reboot.
+func isReboot(spec string) bool {
+	return strings.EqualFold(strings.TrimSpace(spec), rebootDescriptor)
+}
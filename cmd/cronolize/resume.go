@@ -0,0 +1,76 @@
+package main
+
+// Timers do not advance while the system is suspended, so without help a job
+// due during a suspend would run at some arbitrary time after the resume. The
+// scheduler checks every jumpCheckInterval how far the wall clock and the
+// monotonic clock (which stops during suspend) have moved, and before running
+// anything the difference is split into the time suspended and a step of the
+// clock (by NTP or by hand), using CLOCK_BOOTTIME on Linux. Elsewhere all of a
+// forward jump counts as a suspend.
+//
+// The runs that came due while suspended are skipped, like cron does, or run
+// right away with -run-on-resume so the daily jobs of laptops that sleep at
+// night actually run. The runs that came due when the clock was stepped
+// forward are handled according to -clock-jump: skipped, run once (every job
+// that came due runs once) or caught up (every run that came due is made up
+// for, oldest first, like a backfill). A clock stepped back never repeats
+// runs, the jobs wait until the clock has caught up with their next run.
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	runOnResumeFlag   string        = "run-on-resume"
+	clockJumpFlag     string        = "clock-jump"
+	jumpCheckInterval time.Duration = 10 * time.Second
+	// jumpThreshold is how much further (or less) the wall clock has to
+	// have moved than the monotonic clock to count as a resume or a step.
+	jumpThreshold time.Duration = time.Minute
+)
+
+// clockJumpPolicy is what to do about the runs that came due when the clock
+// was stepped forward.
+type clockJumpPolicy string
+
+const (
+	clockJumpSkip    clockJumpPolicy = "skip"
+	clockJumpOnce    clockJumpPolicy = "once"
+	clockJumpCatchUp clockJumpPolicy = "catch-up"
+)
+
+func parseClockJumpPolicy(s string) (clockJumpPolicy, error) {
+	switch p := clockJumpPolicy(s); p {
+	case clockJumpSkip, clockJumpOnce, clockJumpCatchUp:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q is not skip, once or catch-up", s)
+}
+
+// jumpSplitter splits clock jumps into the time suspended and the step of the
+// clock.
+type jumpSplitter struct {
+	suspended time.Duration
+	ok        bool
+}
+
+func newJumpSplitter() *jumpSplitter {
+	s := &jumpSplitter{}
+	s.suspended, s.ok = suspendedTime()
+	return s
+}
+
+// split returns how much of jump was spent suspended since the previous jump
+// and how much the clock was stepped.
+func (s *jumpSplitter) split(jump time.Duration) (suspended, stepped time.Duration) {
+	total, ok := suspendedTime()
+	if !s.ok || !ok {
+		if jump > 0 {
+			return jump, 0
+		}
+		return 0, jump
+	}
+	suspended, s.suspended = total-s.suspended, total
+	return suspended, jump - suspended
+}
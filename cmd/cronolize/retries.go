@@ -0,0 +1,19 @@
+package main
+
+// With -retries N (or retries of a job in a config) a failed run is run again
+// up to N times before it counts as failed, after -retry-backoff, doubled for
+// every retry (at most cronolizer.MaxRetryDelay):
+//
+//	Failed: exit status 1, retrying in 30s (retry 1 of 3)
+//
+// Only the last attempt is recorded in the status and alerted about, every
+// attempt is logged with its summary line. Runs that were killed on purpose
+// are not retried, runs that timed out are.
+
+import "time"
+
+const (
+	retriesFlag         string        = "retries"
+	retryBackoffFlag    string        = "retry-backoff"
+	defaultRetryBackoff time.Duration = 30 * time.Second
+)
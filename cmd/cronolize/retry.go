@@ -0,0 +1,148 @@
+package main
+
+// `cronolize retry-failed` runs the failed runs of the last -since (24h by
+// default) among the recent runs kept in the status again, e.g. once a broken
+// dependency has been fixed. Each is run with the context of the original run:
+// its scheduled time in CRONOLIZE_SCHEDULED_TIME (like a backfill, see
+// backfill.go) and the summary line, and the -param values it was run with.
+// The runs of a job are retried one after the other, oldest first. A failed
+// run is only retried once, if the retry fails too, that is a new failed run.
+// Only the runs of JOB are retried if given, and only in the process -pid if
+// given.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const (
+	retryFailedCommand string = "retry-failed"
+	// defaultRetrySince is how far back retry-failed looks by default.
+	defaultRetrySince time.Duration = 24 * time.Hour
+)
+
+// retryable returns whether run failed at or after since and has not been
+// retried.
+func (run recentRun) retryable(since time.Time) bool {
+	return run.Result == resultFailed && !run.Retried && !run.Started.Before(since)
+}
+
+// retryFire returns the fire retrying run of j.
+func (run recentRun) retryFire(j *job) fire {
+	f := fire{j: j}
+	if run.Scheduled != nil {
+		f.scheduled = *run.Scheduled
+		f.params = []string{scheduledTimeEnvVar + "=" + f.scheduled.Format(time.RFC3339)}
+	}
+	for _, param := range run.Params {
+		if !strings.HasPrefix(param, scheduledTimeEnvVar+"=") {
+			f.params = append(f.params, param)
+		}
+	}
+	return f
+}
+
+// takeFailedRuns returns the runs of job id to retry, marks them as retried
+// and writes the status file. Errors writing it are logged, not fatal.
+func takeFailedRuns(s *statusFile, id cronolizer.EntryID, since time.Time) []recentRun {
+	var runs []recentRun
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			if status.Jobs[i].ID != int(id) {
+				continue
+			}
+			recent := make([]recentRun, len(status.Jobs[i].Recent))
+			copy(recent, status.Jobs[i].Recent)
+			for k := range recent {
+				if recent[k].retryable(since) {
+					runs = append(runs, recent[k])
+					recent[k].Retried = true
+				}
+			}
+			status.Jobs[i].Recent = recent
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+	return runs
+}
+
+// retryFailedCmd implements `cronolize retry-failed [-pid PID] [-since
+// DURATION] [JOB]`.
+func retryFailedCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(retryFailedCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process whose failed runs to retry (default all)")
+	since := cmdFlags.Duration("since", defaultRetrySince, "Retry the runs that failed within this `duration`")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] [-since DURATION] [JOB]", os.Args[0], retryFailedCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() > 1 || *since <= 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	name := cmdFlags.Arg(0)
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	after := time.Now().Add(-*since)
+	found, failed, retried := false, false, false
+	for _, status := range statuses {
+		if *pid != 0 && status.PID != *pid {
+			continue
+		}
+		n := 0
+		for _, js := range status.Jobs {
+			if len(name) > 0 && js.Name != name {
+				continue
+			}
+			found = true
+			for _, run := range js.Recent {
+				if run.retryable(after) {
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if len(status.ControlSocket) == 0 {
+			pe("%s process %d has no control socket", colorize("Error:", ansiBold, ansiRed), status.PID)
+			failed = true
+			continue
+		}
+		req := controlRequest{Command: retryFailedCommand, Job: name, Duration: *since}
+		if err := sendControl(status.ControlSocket, req); err != nil {
+			pe("%s process %d: %v", colorize("Error:", ansiBold, ansiRed), status.PID, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Retrying %d failed runs in process %d\n", n, status.PID)
+		retried = true
+	}
+	switch {
+	case !found && len(name) > 0:
+		fatalf("Error: no running job named %q", name)
+	case !found && *pid != 0:
+		fatalf("Error: no cronolize process with PID %d", *pid)
+	case !found:
+		fatal("no cronolize processes are running")
+	}
+	if failed {
+		os.Exit(1)
+	}
+	if !retried {
+		fmt.Printf("No failed runs within %s\n", *since)
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+// Besides cron expressions, a job can be scheduled by an iCalendar (RFC 5545)
+// recurrence rule, optionally preceded by the DTSTART it is counted from:
+//
+//	RRULE:FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=17       the last Friday of every month at 17:00
+//	RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH       every other Monday and Thursday at midnight
+//	RRULE:FREQ=MONTHLY;BYDAY=MO-FR;BYSETPOS=-1     the last weekday of every month
+//	DTSTART;TZID=Europe/Stockholm:20260105T083000 RRULE:FREQ=DAILY;COUNT=10
+//
+// FREQ may be SECONDLY to YEARLY, the rule parts are INTERVAL, COUNT, UNTIL,
+// BYMONTH, BYYEARDAY, BYMONTHDAY, BYDAY, BYHOUR, BYMINUTE, BYSECOND,
+// BYSETPOS and WKST (BYWEEKNO is not supported). As an extension, BYDAY also
+// takes ranges such as MO-FR. DTSTART is a date or a date and time in local
+// time (UTC with a trailing Z, or in the time zone given by TZID), the time of
+// day and the fields not given by the rule are taken from it. Without DTSTART
+// the rule is counted from 1970-01-01 00:00:00 local time, so the runs are at
+// midnight unless BYHOUR says otherwise. A rule with COUNT requires DTSTART,
+// counted from 1970 its runs would long be used up.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// rruleFrequency is the FREQ of a rule, ordered from the shortest period.
+type rruleFrequency int
+
+const (
+	rruleSecondly rruleFrequency = iota
+	rruleMinutely
+	rruleHourly
+	rruleDaily
+	rruleWeekly
+	rruleMonthly
+	rruleYearly
+)
+
+var rruleFrequencies = map[string]rruleFrequency{
+	"SECONDLY": rruleSecondly,
+	"MINUTELY": rruleMinutely,
+	"HOURLY":   rruleHourly,
+	"DAILY":    rruleDaily,
+	"WEEKLY":   rruleWeekly,
+	"MONTHLY":  rruleMonthly,
+	"YEARLY":   rruleYearly,
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// rruleWeekday is a BYDAY entry, the nth weekday of the month or year (from
+// the end if negative), every such weekday if nth is 0.
+type rruleWeekday struct {
+	weekday time.Weekday
+	nth     int
+}
+
+// rruleSchedule is a parsed recurrence rule.
+type rruleSchedule struct {
+	dtstart    time.Time
+	freq       rruleFrequency
+	interval   int
+	count      int
+	until      time.Time
+	months     []int
+	yearDays   []int
+	monthDays  []int
+	weekdays   []rruleWeekday
+	hours      []int
+	minutes    []int
+	seconds    []int
+	setPos     []int
+	weekStart  time.Weekday
+	defaultDay bool
+	// counted is how far the runs of a rule with COUNT have been counted.
+	counted *rruleCounted
+}
+
+// rruleCounted remembers that n runs precede the period idx (starting at
+// start), so that Next does not count every run from DTSTART again for each
+// run of a rule with COUNT.
+type rruleCounted struct {
+	mu    sync.Mutex
+	idx   int
+	n     int
+	start time.Time
+}
+
+// isRRule reports whether spec is a recurrence rule.
+func isRRule(spec string) bool {
+	upper := strings.ToUpper(strings.TrimSpace(spec))
+	return strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "DTSTART")
+}
+
+// parseRRule parses a recurrence rule, optionally preceded by DTSTART.
+func parseRRule(spec string) (cron.Schedule, error) {
+	s := rruleSchedule{
+		dtstart:   time.Date(1970, time.January, 1, 0, 0, 0, 0, time.Local),
+		interval:  1,
+		weekStart: time.Monday,
+	}
+	var rule string
+	var hasStart bool
+	for _, field := range strings.Fields(spec) {
+		name, value, _ := strings.Cut(field, ":")
+		name, params, _ := strings.Cut(name, ";")
+		switch name = strings.ToUpper(name); {
+		case name == "DTSTART" && len(value) > 0:
+			dtstart, err := parseRRuleTime(value, params, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("%q: DTSTART %v", spec, err)
+			}
+			s.dtstart = dtstart
+			hasStart = true
+		case name == "RRULE" && len(rule) == 0:
+			rule = value
+		default:
+			return nil, fmt.Errorf("%q: unexpected %q, expected [DTSTART:...] RRULE:...", spec, field)
+		}
+	}
+	if len(rule) == 0 {
+		return nil, fmt.Errorf("%q: RRULE missing", spec)
+	}
+	if err := s.parseRule(rule); err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	if s.count > 0 && !hasStart {
+		return nil, fmt.Errorf("%q: COUNT requires DTSTART", spec)
+	}
+	return s, nil
+}
+
+// parseRRuleTime parses a DATE or DATE-TIME value, in the time zone of a
+// TZID parameter if there is one, otherwise in loc unless it ends with Z.
+func parseRRuleTime(value, params string, loc *time.Location) (time.Time, error) {
+	for _, param := range strings.Split(params, ";") {
+		if name, tz, _ := strings.Cut(param, "="); strings.EqualFold(name, "TZID") {
+			location, err := time.LoadLocation(tz)
+			if err != nil {
+				return time.Time{}, err
+			}
+			loc = location
+		}
+	}
+	if strings.HasSuffix(strings.ToUpper(value), "Z") {
+		value, loc = value[:len(value)-1], time.UTC
+	}
+	for _, layout := range []string{"20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date (YYYYMMDD) or date and time (YYYYMMDDTHHMMSS)", value)
+}
+
+// parseRule parses the rule parts of an RRULE.
+func (s *rruleSchedule) parseRule(rule string) error {
+	var freq bool
+	for _, part := range strings.Split(rule, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || len(value) == 0 {
+			return fmt.Errorf("%q is not a rule part (NAME=VALUE)", part)
+		}
+		var err error
+		switch name = strings.ToUpper(name); name {
+		case "FREQ":
+			if s.freq, freq = rruleFrequencies[strings.ToUpper(value)]; !freq {
+				return fmt.Errorf("FREQ %q is not SECONDLY, MINUTELY, HOURLY, DAILY, WEEKLY, MONTHLY or YEARLY", value)
+			}
+		case "INTERVAL":
+			if s.interval, err = strconv.Atoi(value); err != nil || s.interval < 1 {
+				return fmt.Errorf("INTERVAL %q is not a positive number", value)
+			}
+		case "COUNT":
+			if s.count, err = strconv.Atoi(value); err != nil || s.count < 1 {
+				return fmt.Errorf("COUNT %q is not a positive number", value)
+			}
+		case "UNTIL":
+			if s.until, err = parseRRuleTime(value, "", s.dtstart.Location()); err != nil {
+				return fmt.Errorf("UNTIL %v", err)
+			}
+			if len(value) == len("20060102") {
+				// A date includes the whole day.
+				s.until = s.until.AddDate(0, 0, 1).Add(-time.Second)
+			}
+		case "BYMONTH":
+			s.months, err = rruleValues(name, value, 1, 12, false)
+		case "BYYEARDAY":
+			s.yearDays, err = rruleValues(name, value, 1, 366, true)
+		case "BYMONTHDAY":
+			s.monthDays, err = rruleValues(name, value, 1, 31, true)
+		case "BYDAY":
+			s.weekdays, err = parseRRuleWeekdays(value)
+		case "BYHOUR":
+			s.hours, err = rruleValues(name, value, 0, 23, false)
+		case "BYMINUTE":
+			s.minutes, err = rruleValues(name, value, 0, 59, false)
+		case "BYSECOND":
+			s.seconds, err = rruleValues(name, value, 0, 59, false)
+		case "BYSETPOS":
+			s.setPos, err = rruleValues(name, value, 1, 366, true)
+		case "WKST":
+			var known bool
+			if s.weekStart, known = rruleWeekdays[strings.ToUpper(value)]; !known {
+				return fmt.Errorf("WKST %q is not a weekday (MO to SU)", value)
+			}
+		default:
+			return fmt.Errorf("%s is not supported", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case !freq:
+		return fmt.Errorf("FREQ missing")
+	case s.count > 0 && !s.until.IsZero():
+		return fmt.Errorf("COUNT and UNTIL can not both be given")
+	}
+	for _, w := range s.weekdays {
+		if w.nth != 0 && s.freq != rruleMonthly && s.freq != rruleYearly {
+			return fmt.Errorf("BYDAY with a week number such as %d%s requires FREQ=MONTHLY or YEARLY", w.nth, rruleWeekdayName(w.weekday))
+		}
+	}
+	if len(s.monthDays) > 0 && s.freq == rruleWeekly {
+		return fmt.Errorf("BYMONTHDAY can not be used with FREQ=WEEKLY")
+	}
+	// Without days in the rule, the runs are on the day of DTSTART (its
+	// weekday, day of the month or day of the year).
+	s.defaultDay = len(s.yearDays) == 0 && len(s.monthDays) == 0 && len(s.weekdays) == 0
+	if s.count > 0 {
+		s.counted = &rruleCounted{}
+	}
+	return nil
+}
+
+// rruleValues parses a comma separated list of numbers from min to max, or
+// -max to -min with negative.
+func rruleValues(name, list string, min, max int, negative bool) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(list, ",") {
+		v, err := strconv.Atoi(item)
+		abs := v
+		if abs < 0 && negative {
+			abs = -abs
+		}
+		if err != nil || abs < min || abs > max {
+			return nil, fmt.Errorf("%s %q is not within %d to %d", name, item, min, max)
+		}
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+// parseRRuleWeekdays parses BYDAY such as MO,WE, -1FR, 2TU or MO-FR.
+func parseRRuleWeekdays(list string) ([]rruleWeekday, error) {
+	var weekdays []rruleWeekday
+	for _, item := range strings.Split(strings.ToUpper(list), ",") {
+		if from, to, isRange := strings.Cut(item, "-"); isRange && len(from) == 2 {
+			first, ok1 := rruleWeekdays[from]
+			last, ok2 := rruleWeekdays[to]
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("BYDAY %q is not a range of weekdays", item)
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				weekdays = append(weekdays, rruleWeekday{weekday: d})
+				if d == last {
+					break
+				}
+			}
+			continue
+		}
+		if len(item) < 2 {
+			return nil, fmt.Errorf("BYDAY %q is not a weekday", item)
+		}
+		weekday, ok := rruleWeekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("BYDAY %q is not a weekday", item)
+		}
+		w := rruleWeekday{weekday: weekday}
+		if n := item[:len(item)-2]; len(n) > 0 {
+			nth, err := strconv.Atoi(n)
+			if err != nil || nth == 0 || nth < -53 || nth > 53 {
+				return nil, fmt.Errorf("BYDAY %q is not a weekday", item)
+			}
+			w.nth = nth
+		}
+		weekdays = append(weekdays, w)
+	}
+	return weekdays, nil
+}
+
+func rruleWeekdayName(weekday time.Weekday) string {
+	for name, d := range rruleWeekdays {
+		if d == weekday {
+			return name
+		}
+	}
+	return ""
+}
+
+// civilDay returns the number of days from 1970-01-01 to the date of t.
+func civilDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// periodStart returns the start of the idx:th period from the one DTSTART
+// is in.
+func (s rruleSchedule) periodStart(idx int) time.Time {
+	d := s.dtstart
+	loc := d.Location()
+	switch s.freq {
+	case rruleYearly:
+		return time.Date(d.Year()+idx, time.January, 1, 0, 0, 0, 0, loc)
+	case rruleMonthly:
+		return time.Date(d.Year(), d.Month()+time.Month(idx), 1, 0, 0, 0, 0, loc)
+	case rruleWeekly:
+		back := (int(d.Weekday()) - int(s.weekStart) + 7) % 7
+		return time.Date(d.Year(), d.Month(), d.Day()-back+7*idx, 0, 0, 0, 0, loc)
+	case rruleDaily:
+		return time.Date(d.Year(), d.Month(), d.Day()+idx, 0, 0, 0, 0, loc)
+	case rruleHourly:
+		return time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), 0, 0, 0, loc).Add(time.Duration(idx) * time.Hour)
+	case rruleMinutely:
+		return time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), 0, 0, loc).Add(time.Duration(idx) * time.Minute)
+	}
+	return time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), d.Second(), 0, loc).Add(time.Duration(idx) * time.Second)
+}
+
+// periodIndex returns the index of the period t is in, the inverse of
+// periodStart.
+func (s rruleSchedule) periodIndex(t time.Time) int {
+	t = t.In(s.dtstart.Location())
+	d := s.dtstart
+	switch s.freq {
+	case rruleYearly:
+		return t.Year() - d.Year()
+	case rruleMonthly:
+		return (t.Year()-d.Year())*12 + int(t.Month()) - int(d.Month())
+	case rruleWeekly:
+		return floorDiv(civilDay(t)-civilDay(s.periodStart(0)), 7)
+	case rruleDaily:
+		return civilDay(t) - civilDay(d)
+	case rruleHourly:
+		return int(floorDiv(int(t.Sub(s.periodStart(0))/time.Second), 3600))
+	case rruleMinutely:
+		return int(floorDiv(int(t.Sub(s.periodStart(0))/time.Second), 60))
+	}
+	return int(floorDiv(int(t.Sub(s.periodStart(0))/time.Second), 1))
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// matchDay reports whether the rule runs on the day of t.
+func (s rruleSchedule) matchDay(t time.Time) bool {
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	lastYearDay := time.Date(t.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	if len(s.months) > 0 && !containsInt(s.months, int(t.Month())) {
+		return false
+	}
+	if len(s.yearDays) > 0 && !containsInt(s.yearDays, t.YearDay()) && !containsInt(s.yearDays, t.YearDay()-lastYearDay-1) {
+		return false
+	}
+	if len(s.monthDays) > 0 && !containsInt(s.monthDays, t.Day()) && !containsInt(s.monthDays, t.Day()-lastDay-1) {
+		return false
+	}
+	if len(s.weekdays) > 0 {
+		// The week numbers count within the month, or the year with
+		// FREQ=YEARLY unless BYMONTH is given.
+		day, days := t.Day(), lastDay
+		if s.freq == rruleYearly && len(s.months) == 0 {
+			day, days = t.YearDay(), lastYearDay
+		}
+		found := false
+		for _, w := range s.weekdays {
+			switch {
+			case w.weekday != t.Weekday():
+			case w.nth == 0,
+				w.nth > 0 && (day-1)/7+1 == w.nth,
+				w.nth < 0 && -((days-day)/7+1) == w.nth:
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.defaultDay {
+		d := s.dtstart
+		switch s.freq {
+		case rruleWeekly:
+			return t.Weekday() == d.Weekday()
+		case rruleMonthly:
+			return t.Day() == d.Day()
+		case rruleYearly:
+			return t.Day() == d.Day() && (len(s.months) > 0 || t.Month() == d.Month())
+		}
+	}
+	return true
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// timesOfDay returns the values of a time field in a period starting at
+// periodValue: the one of the period if the rule is at least as frequent as
+// the field (limited by byValues), otherwise byValues or the one of DTSTART.
+func (s rruleSchedule) timesOfDay(field rruleFrequency, periodValue, dtstartValue int, byValues []int) []int {
+	switch {
+	case s.freq > field && len(byValues) > 0:
+		return byValues
+	case s.freq > field:
+		return []int{dtstartValue}
+	case len(byValues) == 0 || containsInt(byValues, periodValue):
+		return []int{periodValue}
+	}
+	return nil
+}
+
+// occurrences returns the runs within the idx:th period in order.
+func (s rruleSchedule) occurrences(idx int) []time.Time {
+	start := s.periodStart(idx)
+	end := s.periodStart(idx + 1)
+	loc := start.Location()
+	var runs []time.Time
+	hours := s.timesOfDay(rruleHourly, start.Hour(), s.dtstart.Hour(), s.hours)
+	minutes := s.timesOfDay(rruleMinutely, start.Minute(), s.dtstart.Minute(), s.minutes)
+	seconds := s.timesOfDay(rruleSecondly, start.Second(), s.dtstart.Second(), s.seconds)
+	for day := start; day.Before(end); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+		if !s.matchDay(day) {
+			continue
+		}
+		for _, h := range hours {
+			for _, m := range minutes {
+				for _, sec := range seconds {
+					run := time.Date(day.Year(), day.Month(), day.Day(), h, m, sec, 0, loc)
+					// Times skipped by a daylight saving time change
+					// do not exist.
+					if run.Hour() == h && run.Minute() == m {
+						runs = append(runs, run)
+					}
+				}
+			}
+		}
+		if s.freq < rruleDaily {
+			break
+		}
+	}
+	if len(s.setPos) == 0 {
+		return runs
+	}
+	var selected []time.Time
+	for _, pos := range s.setPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(runs) + pos
+		}
+		if i >= 0 && i < len(runs) && (len(selected) == 0 || runs[i].After(selected[len(selected)-1])) {
+			selected = append(selected, runs[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+	return selected
+}
+
+// onePerPeriod reports whether the rule runs exactly once in every period
+// (of a frequency of a fixed length) it is not skipping by INTERVAL, which
+// makes the number of runs before a period a matter of arithmetic.
+func (s rruleSchedule) onePerPeriod() bool {
+	return s.freq <= rruleHourly && len(s.months) == 0 && len(s.yearDays) == 0 &&
+		len(s.monthDays) == 0 && len(s.weekdays) == 0 && len(s.hours) == 0 &&
+		len(s.minutes) == 0 && len(s.seconds) == 0 && len(s.setPos) == 0
+}
+
+func (s rruleSchedule) Next(t time.Time) time.Time {
+	idx, n := 0, 0
+	switch {
+	case !t.After(s.dtstart):
+	case s.count == 0:
+		// Without COUNT the search starts in the period of t.
+		idx = s.periodIndex(t) / s.interval * s.interval
+	case s.onePerPeriod():
+		idx = s.periodIndex(t) / s.interval * s.interval
+		n = idx / s.interval
+	default:
+		// With COUNT every run from DTSTART on is counted, continuing
+		// from where an earlier call got if that was not after t.
+		s.counted.mu.Lock()
+		if !s.counted.start.IsZero() && !s.counted.start.After(t) {
+			idx, n = s.counted.idx, s.counted.n
+		}
+		s.counted.mu.Unlock()
+	}
+	for ; ; idx += s.interval {
+		start := s.periodStart(idx)
+		if start.Year() > calendarMaxYear {
+			return time.Time{}
+		}
+		if s.freq < rruleDaily && !s.matchDay(start) {
+			// Skip the rest of the day at once.
+			next := s.periodIndex(time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, start.Location()))
+			if next = (next + s.interval - 1) / s.interval * s.interval; next > idx {
+				idx = next - s.interval
+			}
+			continue
+		}
+		before := n
+		for _, run := range s.occurrences(idx) {
+			if run.Before(s.dtstart) {
+				continue
+			}
+			if !s.until.IsZero() && run.After(s.until) {
+				return time.Time{}
+			}
+			if n++; s.count > 0 && n > s.count {
+				return time.Time{}
+			}
+			if run.After(t) {
+				if s.counted != nil {
+					s.counted.remember(idx, before, start)
+				}
+				return run
+			}
+		}
+	}
+}
+
+// remember records that n runs precede the period idx starting at start,
+// unless a later period is already remembered.
+func (c *rruleCounted) remember(idx, n int, start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if start.After(c.start) {
+		c.idx, c.n, c.start = idx, n, start
+	}
+}
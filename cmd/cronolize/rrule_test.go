@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRRuleCount(t *testing.T) {
+	if _, err := parseRRule("RRULE:FREQ=DAILY;COUNT=3"); err == nil || !strings.Contains(err.Error(), "COUNT requires DTSTART") {
+		t.Errorf("COUNT without DTSTART: err = %v, want COUNT requires DTSTART", err)
+	}
+	schedule, err := parseRRule("DTSTART:20260105T083000Z RRULE:FREQ=DAILY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		time.Date(2026, 1, 5, 8, 30, 0, 0, time.UTC),
+		time.Date(2026, 1, 6, 8, 30, 0, 0, time.UTC),
+		time.Date(2026, 1, 7, 8, 30, 0, 0, time.UTC),
+	}
+	next := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, w := range want {
+		if next = schedule.Next(next); !next.Equal(w) {
+			t.Fatalf("Next() = %v, want %v", next, w)
+		}
+	}
+	if next = schedule.Next(next); !next.IsZero() {
+		t.Errorf("Next() after COUNT runs = %v, want none", next)
+	}
+}
+
+func TestParseRRuleWithoutStart(t *testing.T) {
+	schedule, err := parseRRule("RRULE:FREQ=DAILY;BYHOUR=6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 1, 5, 12, 0, 0, 0, time.Local)
+	if next, want := schedule.Next(from), time.Date(2026, 1, 6, 6, 0, 0, 0, time.Local); !next.Equal(want) {
+		t.Errorf("Next() = %v, want %v", next, want)
+	}
+}
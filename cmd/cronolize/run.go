@@ -0,0 +1,73 @@
+package main
+
+// `cronolize run JOB` runs a job of a running daemon right away, outside its
+// schedule. Each -param KEY=VALUE is added to the environment of that run
+// only, so a job can be re-run against a specific date or target without
+// editing the config, e.g. with
+//
+//	command: report --date "${DATE:-$(date -d yesterday +%F)}"
+//
+// `cronolize run -param DATE=2026-10-01 report` re-runs the report of that
+// day. The run is refused if the job is disabled or maintenance mode is on.
+// With -tag instead of JOB, every job with the tags is run.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const runCommand string = "run"
+
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkParam returns an error unless param is KEY=VALUE with KEY a valid
+// environment variable name.
+func checkParam(param string) error {
+	key, _, ok := strings.Cut(param, "=")
+	if !ok || !paramName.MatchString(key) {
+		return fmt.Errorf("%q is not KEY=VALUE", param)
+	}
+	return nil
+}
+
+// runJobCmd implements `cronolize run [-pid PID] [-param KEY=VALUE]...
+// JOB|-tag tag...`.
+func runJobCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(runCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process running the job (if several run a job with that name)")
+	var params stringList
+	cmdFlags.Var(&params, "param", "Add `KEY=VALUE` to the environment of the run (repeatable)")
+	var tags stringList
+	cmdFlags.Var(&tags, "tag", "Instead of JOB, run every job with this `tag` (repeatable, jobs must have all tags)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory] [-param KEY=VALUE]... JOB|-tag tag...", os.Args[0], runCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if (len(tags) > 0) == (cmdFlags.NArg() == 1) || cmdFlags.NArg() > 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	for _, param := range params {
+		if err := checkParam(param); err != nil {
+			fatalf("Syntax error: %v", err)
+		}
+	}
+	if len(tags) > 0 {
+		controlTagged(controlRequest{Command: runCommand, Params: params}, tags, *pid, "Running")
+		return
+	}
+	name := cmdFlags.Arg(0)
+	status, err := findJobDaemon(name, *pid)
+	if err != nil {
+		fatal(err)
+	}
+	if err := sendControl(status.ControlSocket, controlRequest{Command: runCommand, Job: name, Params: params}); err != nil {
+		fatal(err)
+	}
+}
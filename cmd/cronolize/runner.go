@@ -0,0 +1,535 @@
+package main
+
+// The runner runs the jobs handed to it by the dispatcher. A run first goes
+// through the checks deciding whether it runs at all (enabled, maintenance
+// mode, jitter, the overlap policy, blackout windows, power, clock sync, load,
+// preconditions and -max-runs), then the command is started with its output
+// wired to the job's log and whatever else wants it (-suppress-unchanged,
+// -tag-output, mailto and the notifiers). Once it has exited, the run is
+// recorded in the status, summarized in the log, retried if it failed and
+// has retries left, and handed to the notifiers.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+// runOptions are the options of the command line for running jobs, the
+// settings of a job in a config take precedence over them.
+type runOptions struct {
+	quiet             quietLevel
+	shell             string
+	shellOption       string
+	dryRun            bool
+	foreground        bool
+	jitter            time.Duration
+	overlap           cronolizer.OverlapPolicy
+	retries           int
+	retryBackoff      time.Duration
+	timeout           time.Duration
+	maxMemory         int64
+	sampleInterval    time.Duration
+	maxRuns           int
+	systemdScope      bool
+	suppressUnchanged bool
+	tagOutput         bool
+	attachOutput      bool
+	captureMemory     int64
+	blackouts         blackoutWindows
+	summaryFormat     logTimeFormat
+}
+
+// runner runs jobs, its run method is the runFunc of the dispatcher.
+type runner struct {
+	runOptions
+	clock     cronolizer.Clock
+	scheduler *cronolizer.Scheduler
+	maint     *maintenanceMode
+	runs      *activeRuns
+	notifiers []notifier
+	alerts    *alertState
+	// cd and status are set once the cron process is set up, they are nil
+	// until then or if not used.
+	cd     *countdown
+	status *statusFile
+	// lastRunID numbers the runs from 1 in the order they were started.
+	lastRunID int64
+	// With -max-runs, runsStarted counts the runs (not their retries) and
+	// maxRunsReached is called by the run reaching the limit once
+	// everything is set up.
+	runsStarted    int64
+	maxRunsReached func()
+}
+
+// jobCommand is what is executed for a run of a job.
+type jobCommand struct {
+	shell string
+	// args are the arguments of the shell, the command is the last.
+	args []string
+	// argv is the command line, the shell with args or the one running the
+	// module of a wasm job.
+	argv []string
+}
+
+func (c jobCommand) String() string {
+	return strings.Join(c.argv, " ")
+}
+
+// command returns what is executed for a run of j.
+func (r *runner) command(j *job) jobCommand {
+	c := jobCommand{shell: r.shell}
+	if len(j.Shell) > 0 {
+		c.shell = j.Shell
+	}
+	if len(r.shellOption) != 0 {
+		c.args = []string{r.shellOption, j.Command}
+	} else {
+		c.args = []string{j.Command}
+	}
+	// A wasm job runs its module using this executable instead of the
+	// shell, which is still used for its conditions.
+	c.argv = append([]string{c.shell}, c.args...)
+	if j.wasm != nil {
+		c.argv = j.wasm
+	}
+	return c
+}
+
+// skip logs and counts a run skipped on purpose (e.g. on battery or under
+// load), it is not alerted about.
+func (r *runner) skip(j *job, reason error) {
+	if r.quiet < quietRuns {
+		j.logger.Print("Skipped, ", reason)
+	}
+	if r.status != nil {
+		recordSkippedRun(r.status, j.id)
+	}
+}
+
+// run runs f unless it is to be skipped, calling started once the job's
+// process has been started (or when it returns without starting one). Instead
+// of waiting for its jitter, the previous run (with -overlap queue) or a
+// retry, it returns a rerun for the dispatcher.
+func (r *runner) run(f fire, started func()) (again *rerun) {
+	j := f.j
+	// The overlap of the job is exited once the run is done, unless it is
+	// handed on to its rerun.
+	defer func() {
+		if f.locked && (again == nil || !again.locked) {
+			j.running.Exit()
+		}
+	}()
+	if !j.isEnabled() {
+		if r.quiet < quietRuns {
+			j.logger.Print("Skipped, job is disabled")
+		}
+		return
+	}
+	if r.maint.active() {
+		if r.quiet < quietRuns {
+			j.logger.Print("Skipped, in maintenance mode")
+		}
+		return
+	}
+	if r.cd != nil {
+		r.cd.pause()
+		defer r.cd.resume()
+	}
+	if r.status != nil {
+		defer updateJobStatus(r.status, r.scheduler, j.id)
+		updateJobStatus(r.status, r.scheduler, j.id)
+	}
+	command := r.command(j)
+	if r.dryRun {
+		// Log what would have been executed regardless of -q, that is the
+		// whole point of a dry-run.
+		j.logger.Print(colorize("Would run: "+command.String(), ansiBold, ansiCyan))
+		return
+	}
+	maxJitter := r.jitter
+	if j.jitter >= 0 {
+		maxJitter = j.jitter
+	}
+	if maxJitter > 0 && !f.requested && f.attempt == 0 && !f.jittered {
+		wait := randomJitter(maxJitter).Round(time.Millisecond)
+		if r.quiet < quietRuns {
+			j.logger.Printf("Waiting %s of jitter", wait)
+		}
+		f.jittered = true
+		return &rerun{fire: f, delay: wait}
+	}
+	// Retries hold on to the run of the first attempt, see overlap.go.
+	policy := r.overlap
+	if len(j.overlap) > 0 {
+		policy = j.overlap
+	}
+	if policy != cronolizer.OverlapAllow && !f.locked {
+		if !j.running.TryEnter() {
+			switch policy {
+			case cronolizer.OverlapSkip:
+				r.skip(j, errors.New("the previous run has not finished"))
+				return
+			case cronolizer.OverlapQueue:
+				if r.quiet < quietRuns {
+					j.logger.Print("Queued until the previous run has finished")
+				}
+			case cronolizer.OverlapKill:
+				if n := r.runs.killJob(j, syscall.SIGTERM, "overlap policy"); n > 0 && r.quiet < quietRuns {
+					j.logger.Print("Killing the previous run that has not finished")
+				}
+			}
+			// The run is run again once it has entered the overlap.
+			f.locked = true
+			return &rerun{fire: f, wait: j.running.Wait}
+		}
+		f.locked = true
+	}
+	if !f.requested {
+		if err := r.blackouts.check(r.clock.Now().Local()); err != nil {
+			r.skip(j, err)
+			return
+		}
+	}
+	if err := j.checkPower(); err != nil {
+		r.skip(j, err)
+		return
+	}
+	if err := j.checkClockSync(); err != nil {
+		j.logger.Print(colorize("Warning:", ansiBold, ansiYellow), " skipped, ", err)
+		if r.status != nil {
+			recordSkippedRun(r.status, j.id)
+		}
+		return
+	}
+	if j.load != nil || j.network != nil {
+		// Waiting for the load to come down or the network must not hold
+		// up the jobs fired at the same time with a lower priority.
+		started()
+	}
+	if j.load != nil {
+		if err := j.load.waitFor(j.logger); err != nil {
+			r.skip(j, err)
+			return
+		}
+	}
+	// The command is the last argument.
+	if err := j.checkPreconditions(command.shell, command.args[:len(command.args)-1]); err != nil {
+		j.logger.Print("Skipped, precondition not met: ", err)
+		if r.status != nil {
+			recordSkippedRun(r.status, j.id)
+		}
+		if len(r.notifiers) > 0 {
+			result := newRunResult(j, time.Now(), err, newOutputTail())
+			result.skipped = true
+			notifyAll(r.notifiers, r.alerts, result)
+		}
+		return
+	}
+	if r.maxRuns > 0 && f.attempt == 0 {
+		switch n := atomic.AddInt64(&r.runsStarted, 1); {
+		case n > int64(r.maxRuns):
+			r.skip(j, fmt.Errorf("-%s %d reached", maxRunsFlag, r.maxRuns))
+			return
+		case n == int64(r.maxRuns) && r.maxRunsReached != nil:
+			r.maxRunsReached()
+		}
+	}
+	return r.execute(f, command, started)
+}
+
+// runOutput is where the output of a run goes besides the job's log.
+type runOutput struct {
+	// captured is the output held back by -suppress-unchanged.
+	captured *outputCapture
+	// tagged are the streams tagged by -tag-output.
+	tagged []*taggedStream
+	// mailed is all output of a job with a mailto.
+	mailed *outputCapture
+	// tail, full and scanner are for the notifiers: the last lines, the
+	// complete output with -attach-output and the metrics of the job.
+	tail    *outputTail
+	full    *outputCapture
+	scanner *metricScanner
+	// bytes counts the output for the summary line.
+	bytes *byteCounter
+}
+
+// newRunOutput wires the output of cmd, a run of j.
+func (r *runner) newRunOutput(j *job, cmd *exec.Cmd) *runOutput {
+	o := &runOutput{}
+	if r.quiet < quietAll {
+		cmd.Stdout = j.stdout
+	}
+	cmd.Stderr = j.stderr
+	// With -suppress-unchanged the output is captured (spilling to disk
+	// beyond -capture-memory) and only written if it differs from the
+	// previous run's output.
+	if r.suppressUnchanged {
+		o.captured = newOutputCapture(r.captureMemory)
+		if cmd.Stdout != nil {
+			cmd.Stdout = o.captured
+		}
+		cmd.Stderr = o.captured
+	}
+	// With -tag-output the lines of stdout and stderr are tagged and written
+	// whole, see tagoutput.go.
+	if r.tagOutput {
+		tagger := &streamTagger{}
+		if cmd.Stdout != nil {
+			out := tagger.stream(cmd.Stdout, stdoutTag)
+			cmd.Stdout = out
+			o.tagged = append(o.tagged, out)
+		}
+		errs := tagger.stream(cmd.Stderr, stderrTag)
+		cmd.Stderr = errs
+		o.tagged = append(o.tagged, errs)
+	}
+	// All output of a job with a mailto is also captured to be mailed and
+	// the tail of it kept for notifiers, even output discarded by -q=3.
+	var taps []io.Writer
+	if len(j.MailTo) > 0 {
+		o.mailed = newOutputCapture(r.captureMemory)
+		taps = append(taps, o.mailed)
+	}
+	if len(r.notifiers) > 0 {
+		o.tail = newOutputTail()
+		taps = append(taps, o.tail)
+		if len(j.Metrics) > 0 {
+			o.scanner = newMetricScanner(j.Metrics)
+			taps = append(taps, o.scanner)
+		}
+		if r.attachOutput {
+			o.full = newOutputCapture(r.captureMemory)
+			taps = append(taps, o.full)
+		}
+	}
+	// Every run's output is counted for its summary line.
+	o.bytes = &byteCounter{}
+	taps = append(taps, o.bytes)
+	tee := func(w io.Writer) io.Writer {
+		if w == nil {
+			return io.MultiWriter(taps...)
+		}
+		return io.MultiWriter(append([]io.Writer{w}, taps...)...)
+	}
+	if cmd.Stdout == cmd.Stderr {
+		w := tee(cmd.Stdout)
+		cmd.Stdout, cmd.Stderr = w, w
+	} else {
+		cmd.Stdout, cmd.Stderr = tee(cmd.Stdout), tee(cmd.Stderr)
+	}
+	return o
+}
+
+// close removes the captured output.
+func (o *runOutput) close() {
+	for _, c := range []*outputCapture{o.captured, o.mailed, o.full} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// execute starts the command of f and waits for it, calling started once it
+// has been started. It returns the retry of a failed run.
+func (r *runner) execute(f fire, command jobCommand, started func()) *rerun {
+	j := f.j
+	if r.quiet < quietRuns {
+		j.logger.Print(colorize("Running: "+command.String(), ansiBold, ansiCyan))
+	}
+	argv := command.argv
+	inScope := j.SystemdScope || r.systemdScope
+	if inScope {
+		argv = j.scopeArgv(argv)
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if j.cred != nil {
+		// The job's own environment may still override HOME and friends,
+		// like in cron.
+		cmd.Env = append(os.Environ(), j.cred.env()...)
+		// In a scope systemd-run switches to the job's user.
+		if j.cred.cred != nil && !inScope {
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: j.cred.cred}
+		}
+	}
+	if len(j.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, j.Env...)
+	}
+	if j.wasm != nil {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, j.wasmEnv(time.Now())...)
+	}
+	if len(f.params) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, f.params...)
+	}
+	if !r.foreground {
+		cmd.Stdin = os.Stdin
+	} else {
+		cmd.Stdin = nil
+	}
+	out := r.newRunOutput(j, cmd)
+	defer out.close()
+	startTime := time.Now()
+	var start func(*exec.Cmd) error
+	if j.cpus != nil || j.schedPolicy != nil {
+		start = func(cmd *exec.Cmd) error {
+			return startInheriting(cmd, j.cpus, j.schedPolicy)
+		}
+	}
+	proc, err := cronolizer.StartProcess(cmd, start)
+	started()
+	// cancelled is who killed the run with what signal, if anyone.
+	var cancelled string
+	var runID int
+	if err == nil {
+		runID = int(atomic.AddInt64(&r.lastRunID, 1))
+		r.runs.add(&activeRun{id: runID, job: j, process: proc})
+		if r.status != nil {
+			addActiveRun(r.status, runStatus{
+				RunID:   runID,
+				Job:     j.Name,
+				JobID:   int(j.id),
+				PID:     proc.Pid,
+				Started: startTime,
+				Command: j.displayCommand(),
+			})
+		}
+		limit := r.maxMemory
+		if j.maxMemory > 0 {
+			limit = j.maxMemory
+		}
+		// exceeded is the memory usage that got the run killed, it is
+		// only written by the sampler and read once it is closed.
+		var exceeded int64
+		timeout := r.timeout
+		if j.timeout > 0 {
+			timeout = j.timeout
+		}
+		if timeout > 0 {
+			proc.Timeout(timeout, func() {
+				j.logger.Print(colorize("Timed out", ansiBold, ansiRed), " after ", timeout, ", killing run ", runID)
+			})
+		}
+		var smp *sampler
+		if r.sampleInterval > 0 {
+			smp = startSampler(proc.Pid, r.sampleInterval, func(usage resourceUsage) {
+				if r.status != nil {
+					setActiveRunUsage(r.status, runID, usage)
+				}
+				if limit > 0 && usage.MemoryBytes > limit && exceeded == 0 {
+					exceeded = usage.MemoryBytes
+					proc.Signal(syscall.SIGKILL)
+				}
+			})
+		}
+		// A run killed by its timeout fails with a *cronolizer.TimeoutError.
+		err = proc.Wait()
+		for _, stream := range out.tagged {
+			stream.Flush()
+		}
+		if smp != nil {
+			smp.close()
+		}
+		if exceeded > 0 {
+			err = fmt.Errorf("killed, memory usage %s exceeded the limit of %s: %w", formatBytes(exceeded), formatBytes(limit), err)
+		}
+		cancelled = r.runs.remove(runID)
+		if err == nil && len(cancelled) == 0 {
+			err = j.checkPostconditions(command.shell, command.args[:len(command.args)-1], startTime)
+		}
+		if r.status != nil {
+			removeActiveRun(r.status, runID)
+		}
+	}
+	return r.finish(f, out, runID, startTime, cancelled, err)
+}
+
+// finish records, logs and retries or alerts about a run of f that started
+// at startTime and ended with err (nil if it succeeded), cancelled by who
+// killed it with what signal, if anyone. It returns the retry of a failed run
+// with retries left.
+func (r *runner) finish(f fire, out *runOutput, runID int, startTime time.Time, cancelled string, err error) *rerun {
+	j := f.j
+	// Only the last attempt of a failed run is recorded and alerted about,
+	// see retries.go.
+	retries := cronolizer.Retries{Max: r.retries, Backoff: r.retryBackoff}
+	if j.Retries != nil {
+		retries.Max = *j.Retries
+	}
+	if j.retryBackoff > 0 {
+		retries.Backoff = j.retryBackoff
+	}
+	delay, retry := retries.Delay(f.attempt, err, len(cancelled) > 0)
+	if r.status != nil && !retry {
+		if len(cancelled) > 0 {
+			recordCancelledRun(r.status, j.id, startTime, time.Since(startTime))
+		} else {
+			recordJobRun(r.status, j.id, f, startTime, time.Since(startTime), err)
+		}
+	}
+	j.logger.Print(runSummary{
+		job:         j.Name,
+		runID:       runID,
+		scheduled:   f.scheduled,
+		start:       startTime,
+		end:         time.Now(),
+		exitCode:    exitCode(err),
+		outputBytes: out.bytes.count(),
+		times:       r.summaryFormat,
+	})
+	if out.captured != nil {
+		j.writeOutput(out.captured, r.quiet)
+	}
+	if retry {
+		j.logger.Print(colorize("Failed:", ansiBold, ansiYellow), " ", err, ", retrying in ", delay, fmt.Sprintf(" (retry %d of %d)", f.attempt+1, retries.Max))
+		f.attempt++
+		return &rerun{fire: f, delay: delay}
+	}
+	if out.mailed != nil && out.mailed.Size() > 0 {
+		if err := mailOutput(j.MailTo, mailSubject(j.displayCommand()), out.mailed); err != nil {
+			j.logger.Print(colorize("Error:", ansiBold, ansiRed), " mailing output to ", j.MailTo, ": ", err)
+		}
+	}
+	if len(cancelled) > 0 {
+		// A run killed on purpose is neither alerted about nor logged as
+		// an error.
+		j.logger.Print("Cancelled, killed with ", cancelled)
+		return nil
+	}
+	if out.tail != nil {
+		result := newRunResult(j, startTime, err, out.tail)
+		if out.scanner != nil {
+			result.metrics = out.scanner.metrics()
+		}
+		if out.full != nil && result.failed() && out.full.Size() > 0 {
+			path, saveErr := saveOutput(j, startTime, out.full)
+			if saveErr != nil {
+				j.logger.Print(colorize("Error:", ansiBold, ansiRed), " saving output: ", saveErr)
+			}
+			result.outputFile = path
+		}
+		notifyAll(r.notifiers, r.alerts, result)
+	}
+	if err != nil {
+		// A failing job must not take the other jobs down with it.
+		j.logger.Print(colorize("Error:", ansiBold, ansiRed), " ", err)
+	}
+	return nil
+}
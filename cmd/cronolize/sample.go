@@ -0,0 +1,96 @@
+package main
+
+// While a job's command is executing, the CPU and memory usage of its process
+// tree (the command and everything it started) is sampled every
+// -sample-interval and shown by `cronolize running` and in the active runs of
+// the status, with the peak memory usage of the run. With -max-memory (or
+// max_memory per job in a config), a run whose process tree uses more memory
+// than that is killed and fails, catching leaky batch scripts before they take
+// the host down. Sampling reads /proc and is only supported on Linux.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const defaultSampleInterval time.Duration = 10 * time.Second
+
+// resourceUsage is the latest sample of the resources used by a run.
+type resourceUsage struct {
+	// CPUPercent is the CPU usage since the previous sample, 100 is one
+	// core fully used.
+	CPUPercent      float64 `json:"cpu_percent"`
+	MemoryBytes     int64   `json:"memory_bytes"`
+	PeakMemoryBytes int64   `json:"peak_memory_bytes"`
+}
+
+// sampler samples the process tree of a run until closed.
+type sampler struct {
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// startSampler calls fn with the usage of the process tree of pid every
+// interval.
+func startSampler(pid int, interval time.Duration, fn func(usage resourceUsage)) *sampler {
+	s := &sampler{stop: make(chan struct{})}
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		defer reportPanic()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var usage resourceUsage
+		var prev time.Duration
+		prevTime := time.Now()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				tree, err := cronolizer.ReadProcessTree(pid)
+				if err != nil {
+					// The process has exited or sampling is not
+					// supported, there is nothing to sample.
+					return
+				}
+				if delta := tree.CPUTime - prev; delta > 0 {
+					usage.CPUPercent = 100 * delta.Seconds() / now.Sub(prevTime).Seconds()
+				} else {
+					usage.CPUPercent = 0
+				}
+				prev, prevTime = tree.CPUTime, now
+				usage.MemoryBytes = tree.RSS
+				if tree.RSS > usage.PeakMemoryBytes {
+					usage.PeakMemoryBytes = tree.RSS
+				}
+				fn(usage)
+			}
+		}
+	}()
+	return s
+}
+
+// close stops sampling and waits for a sample in progress.
+func (s *sampler) close() {
+	close(s.stop)
+	s.done.Wait()
+}
+
+// formatBytes formats n bytes for humans, e.g. 1.5G.
+func formatBytes(n int64) string {
+	f := float64(n)
+	for _, suffix := range []string{"", "K", "M", "G"} {
+		if f < 1024 {
+			if len(suffix) == 0 {
+				return fmt.Sprintf("%d", n)
+			}
+			return fmt.Sprintf("%.1f%s", f, suffix)
+		}
+		f /= 1024
+	}
+	return fmt.Sprintf("%.1fT", f)
+}
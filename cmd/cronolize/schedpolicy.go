@@ -0,0 +1,41 @@
+package main
+
+// A job with sched_policy in a config runs with that Linux scheduling policy:
+//
+//   - batch (SCHED_BATCH) is for CPU-bound work, it is never preferred in
+//     wakeups and preempts other processes less often.
+//   - idle (SCHED_IDLE) only runs when nothing else wants the CPU, so
+//     background maintenance yields completely to interactive work, which a
+//     nice value alone does not do.
+//   - other (SCHED_OTHER) is the default time-sharing policy.
+//
+// Like the CPU affinity, the command has the policy from the start and the
+// processes it starts inherit it. Scheduling policies are only supported on
+// Linux.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// schedPolicy is a Linux scheduling policy.
+type schedPolicy int
+
+const (
+	schedOther schedPolicy = 0
+	schedBatch schedPolicy = 3
+	schedIdle  schedPolicy = 5
+)
+
+// parseSchedPolicy parses other, batch or idle.
+func parseSchedPolicy(s string) (schedPolicy, error) {
+	switch strings.ToLower(s) {
+	case "other":
+		return schedOther, nil
+	case "batch":
+		return schedBatch, nil
+	case "idle":
+		return schedIdle, nil
+	}
+	return 0, fmt.Errorf("%q is not other, batch or idle", s)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const schedPolicySupported = true
+
+// schedParam is the struct sched_param of the kernel.
+type schedParam struct {
+	priority int32
+}
+
+// getSchedPolicy returns the policy and parameters of the calling thread.
+func getSchedPolicy() (int, schedParam, error) {
+	var param schedParam
+	policy, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETSCHEDULER, 0, 0, 0)
+	if errno != 0 {
+		return 0, param, errno
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETPARAM, 0, uintptr(unsafe.Pointer(&param)), 0); errno != 0 {
+		return 0, param, errno
+	}
+	return int(policy), param, nil
+}
+
+// setSchedPolicy sets the policy and parameters of the calling thread.
+func setSchedPolicy(policy int, param schedParam) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+// With -seconds a schedule may start with a seconds field, so six field
+// expressions such as "*/10 * * * * *" (every 10 seconds) or "30 0 3 * * *"
+// (03:00:30) work. Five field expressions still mean second 0, and lines in a
+// crontab have six fields.
+
+import "github.com/robfig/cron/v3"
+
+const secondsFlag string = "seconds"
+
+// withSeconds is the -seconds option.
+var withSeconds bool
+
+// cronParser parses five field expressions and descriptors, and six field
+// expressions with -seconds.
+func cronParser() cron.Parser {
+	fields := cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor
+	if withSeconds {
+		fields |= cron.SecondOptional
+	}
+	return cron.NewParser(fields)
+}
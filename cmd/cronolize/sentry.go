@@ -0,0 +1,161 @@
+package main
+
+// With -sentry-dsn (or SENTRY_DSN), failed runs and panics in the daemon are
+// reported to Sentry using its HTTP store API, the tail of the run's output
+// is attached as breadcrumbs.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sentryDSNFlag   string        = "sentry-dsn"
+	sentryDSNEnvVar string        = "SENTRY_DSN"
+	sentryTimeout   time.Duration = 10 * time.Second
+	// sentryBreadcrumbs is the number of output lines sent as breadcrumbs.
+	sentryBreadcrumbs int = 100
+)
+
+type sentryNotifier struct {
+	endpoint string
+	auth     string
+	client   *http.Client
+}
+
+// newSentryNotifier parses a DSN such as https://key@o1.ingest.sentry.io/42.
+func newSentryNotifier(dsn string) (*sentryNotifier, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return nil, fmt.Errorf("invalid Sentry DSN %q: no public key", dsn)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || i == len(path)-1 {
+		return nil, fmt.Errorf("invalid Sentry DSN %q: no project id", dsn)
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=cronolize/%s, sentry_key=%s", version, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	endpoint := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   path[:i] + "/api/" + path[i+1:] + "/store/",
+	}
+	return &sentryNotifier{
+		endpoint: endpoint.String(),
+		auth:     auth,
+		client:   &http.Client{Timeout: sentryTimeout},
+	}, nil
+}
+
+func (s *sentryNotifier) name() string {
+	return "Sentry"
+}
+
+// notify reports failed runs, Sentry tracks recoveries itself when issues
+// stop occurring. Skipped runs are not errors and are not reported.
+func (s *sentryNotifier) notify(r runResult) error {
+	if !r.alert() || r.recovered || r.skipped {
+		return nil
+	}
+	event := s.newEvent("error", fmt.Sprintf("%s: %v", r.job.Name, r.err))
+	event["tags"] = map[string]string{
+		"job":       r.job.Name,
+		"exit_code": fmt.Sprint(r.exitCode),
+	}
+	extra := map[string]any{
+		"command":          r.job.displayCommand(),
+		"schedule":         r.job.spec(),
+		"exit_code":        r.exitCode,
+		"started":          r.started.Format(time.RFC3339),
+		"duration_seconds": r.duration.Seconds(),
+	}
+	if len(r.outputFile) > 0 {
+		extra["output_file"] = r.outputFile
+	}
+	if r.occurrences > 1 {
+		extra["occurrences"] = r.occurrences
+		extra["first_occurrence"] = r.firstOccurrence.Format(time.RFC3339)
+	}
+	event["extra"] = extra
+	event["fingerprint"] = []string{"cronolize", r.job.Name}
+	lines := strings.Split(strings.TrimRight(string(r.output), "\n"), "\n")
+	if len(lines) > sentryBreadcrumbs {
+		lines = lines[len(lines)-sentryBreadcrumbs:]
+	}
+	crumbs := make([]map[string]any, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		crumbs = append(crumbs, map[string]any{
+			"category": "output",
+			"level":    "info",
+			"message":  line,
+		})
+	}
+	event["breadcrumbs"] = map[string]any{"values": crumbs}
+	return s.send(event)
+}
+
+// reportPanic reports a panic in the daemon itself.
+func (s *sentryNotifier) reportPanic(v any, stack []byte) {
+	event := s.newEvent("fatal", fmt.Sprintf("panic: %v", v))
+	event["extra"] = map[string]any{"stack": string(stack)}
+	if err := s.send(event); err != nil {
+		fmt.Fprintf(os.Stderr, "Sentry: %v\n", err)
+	}
+}
+
+func (s *sentryNotifier) newEvent(level, message string) map[string]any {
+	id := make([]byte, 16)
+	rand.Read(id)
+	hostname, _ := os.Hostname()
+	return map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"logger":      "cronolize",
+		"platform":    "other",
+		"server_name": hostname,
+		"release":     "cronolize@" + version,
+		"message":     map[string]string{"formatted": message},
+	}
+}
+
+func (s *sentryNotifier) send(event map[string]any) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", s.endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+// The hidden -simulate-speed flag runs the schedule on a virtual clock that
+// runs N times faster than real time, with every job in dry-run, so days of
+// scheduling behavior can be watched in seconds when debugging a config. Log
+// entries are timestamped with the virtual time.
+
+import (
+	"flag"
+	"io"
+	"sync"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const simulateSpeedFlag = "simulate-speed"
+
+// hiddenFlags are left out of the syntax help and the man page.
+var hiddenFlags = map[string]bool{
+	simulateSpeedFlag: true,
+}
+
+// printDefaults is flag.PrintDefaults without the hidden flags.
+func printDefaults(fs *flag.FlagSet) {
+	visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			// Var takes the current value as the default, which is not
+			// the default after parsing.
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// clockWriter prefixes every write with the time of a cronolizer.Clock, it is
+// used instead of the log package's timestamps when the clock is not the real
+// time or with -log-time-format. The log package issues a single write per log
+// entry.
+type clockWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	clock  cronolizer.Clock
+	format logTimeFormat
+}
+
+func newClockWriter(w io.Writer, clock cronolizer.Clock, format logTimeFormat) *clockWriter {
+	return &clockWriter{w: w, clock: clock, format: format}
+}
+
+func (c *clockWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line := make([]byte, 0, len(p)+40)
+	line = c.format.append(line, c.clock.Now())
+	line = append(line, ' ')
+	line = append(line, p...)
+	if _, err := c.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
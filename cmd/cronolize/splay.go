@@ -0,0 +1,62 @@
+package main
+
+// With -splay, every schedule is offset by a duration within the splay
+// derived from a hash of the hostname. The offset is the same for every job
+// on a host and stays the same across restarts, but differs between hosts, so
+// the same image or config deployed to hundreds of machines does not have
+// them all run their jobs in the same minute. A job scheduled at 03:00 with
+// -splay 30m runs somewhere between 03:00 and 03:30, always at the same time
+// on a given host.
+
+import (
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const splayFlag string = "splay"
+
+// splayOffset returns the offset of this host within splay, to the second.
+func splayOffset(splay time.Duration) time.Duration {
+	seconds := int64(splay / time.Second)
+	if seconds <= 0 {
+		return 0
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	return time.Duration(h.Sum64()%uint64(seconds)) * time.Second
+}
+
+// splayParser parses the schedules of parser offset by offset.
+type splayParser struct {
+	parser cron.ScheduleParser
+	offset time.Duration
+}
+
+func (p splayParser) Parse(spec string) (cron.Schedule, error) {
+	schedule, err := p.parser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return splaySchedule{schedule: schedule, offset: p.offset}, nil
+}
+
+// splaySchedule runs offset after every time of schedule.
+type splaySchedule struct {
+	schedule cron.Schedule
+	offset   time.Duration
+}
+
+func (s splaySchedule) Next(t time.Time) time.Time {
+	next := s.schedule.Next(t.Add(-s.offset))
+	if next.IsZero() {
+		return next
+	}
+	return next.Add(s.offset)
+}
@@ -0,0 +1,736 @@
+package main
+
+// Every cron process (background child or -fg) maintains a status file named
+// after its PID in the runtime directory (see paths.go). The `list` and `status` subcommands
+// read these files, skipping any left behind by processes that are no longer
+// alive. The JSON emitted by `list -json` and `status -json` is the same
+// structure as in the status files and fields are only ever added, never
+// renamed or removed.
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const (
+	listCommand    string = "list"
+	statusCommand  string = "status"
+	runningCommand string = "running"
+	statusFileExt  string = ".json"
+)
+
+type daemonStatus struct {
+	PID           int       `json:"pid"`
+	User          string    `json:"user,omitempty"`
+	Version       string    `json:"version"`
+	Started       time.Time `json:"started"`
+	Foreground    bool      `json:"foreground"`
+	Logfile       string    `json:"logfile,omitempty"`
+	Config        string    `json:"config,omitempty"`
+	ControlSocket string    `json:"control_socket,omitempty"`
+	Workers       int       `json:"workers"`
+	QueueLimit    int       `json:"queue_limit"`
+	Running       int       `json:"running"`
+	Queued        int       `json:"queued"`
+	Dropped       int       `json:"dropped"`
+	// ActiveRuns are the runs of jobs currently executing.
+	ActiveRuns []runStatus `json:"active_runs,omitempty"`
+	// Maintenance is set while in maintenance mode (see maintenance.go).
+	Maintenance *maintenanceStatus `json:"maintenance,omitempty"`
+	Jobs        []jobStatus        `json:"jobs"`
+}
+
+type jobStatus struct {
+	PID     int        `json:"pid"`
+	ID      int        `json:"id"`
+	Name    string     `json:"name"`
+	Spec    string     `json:"spec"`
+	Watch   string     `json:"watch,omitempty"`
+	Command string     `json:"command"`
+	Log     string     `json:"log,omitempty"`
+	Tags    []string   `json:"tags,omitempty"`
+	Enabled bool       `json:"enabled"`
+	Prev    *time.Time `json:"prev,omitempty"`
+	Next    *time.Time `json:"next,omitempty"`
+
+	Runs                   int     `json:"runs"`
+	Successes              int     `json:"successes"`
+	Failures               int     `json:"failures"`
+	Cancelled              int     `json:"cancelled"`
+	Skipped                int     `json:"skipped"`
+	ConsecutiveFailures    int     `json:"consecutive_failures"`
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+	// Recent are the results of the last recentRunsKept runs, oldest
+	// first.
+	Recent []recentRun `json:"recent,omitempty"`
+}
+
+// Results of recent runs.
+const (
+	resultOK        string = "ok"
+	resultFailed    string = "failed"
+	resultCancelled string = "cancelled"
+	resultSkipped   string = "skipped"
+)
+
+// recentRunsKept is the number of recent runs kept per job.
+const recentRunsKept int = 20
+
+// recentRun is the result of one of the last runs of a job.
+type recentRun struct {
+	Result          string    `json:"result"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Scheduled and Params are the context of the run, kept to retry it
+	// with retry-failed, see retry.go. Retried is set once it has been.
+	Scheduled *time.Time `json:"scheduled,omitempty"`
+	Params    []string   `json:"params,omitempty"`
+	Retried   bool       `json:"retried,omitempty"`
+}
+
+// addRecentRun appends a run to the recent runs of js, dropping the oldest
+// beyond recentRunsKept.
+func (js *jobStatus) addRecentRun(run recentRun) {
+	js.Recent = append(js.Recent, run)
+	if len(js.Recent) > recentRunsKept {
+		js.Recent = append([]recentRun(nil), js.Recent[len(js.Recent)-recentRunsKept:]...)
+	}
+}
+
+// runStatus is a run of a job whose command is currently executing.
+type runStatus struct {
+	RunID   int       `json:"run_id"`
+	Job     string    `json:"job"`
+	JobID   int       `json:"job_id"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Command string    `json:"command"`
+	// Usage is the latest resource sample of the run, see sample.go.
+	Usage *resourceUsage `json:"usage,omitempty"`
+	// Frozen is true while the run is stopped by freeze, see freeze.go.
+	Frozen bool `json:"frozen,omitempty"`
+}
+
+// statusFile manages this process' own status file.
+type statusFile struct {
+	mu     sync.Mutex
+	path   string
+	status daemonStatus
+}
+
+func newStatusFile(status daemonStatus) (*statusFile, error) {
+	dir := runtimeDir()
+	if err := makeRuntimeDir(dir); err != nil {
+		return nil, err
+	}
+	return &statusFile{
+		path:   filepath.Join(dir, strconv.Itoa(status.PID)+statusFileExt),
+		status: status,
+	}, nil
+}
+
+// update lets fn modify the status and writes the result to disk.
+func (s *statusFile) update(fn func(*daemonStatus)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fn != nil {
+		fn(&s.status)
+	}
+	data, err := json.MarshalIndent(s.status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+// snapshot returns a copy of the status.
+func (s *statusFile) snapshot() daemonStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Jobs = append([]jobStatus(nil), s.status.Jobs...)
+	return status
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path, so readers see either the old or the new content and
+// never a torn file, even after a power loss.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Sync the directory so the rename itself is durable.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// updateJobStatus refreshes the previous and next run times of job id from the
+// scheduler and writes the status file. Errors are logged, not fatal.
+func updateJobStatus(s *statusFile, c *cronolizer.Scheduler, id cronolizer.EntryID) {
+	entry := c.Entry(id)
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			if status.Jobs[i].ID != int(id) {
+				continue
+			}
+			if !entry.Prev.IsZero() {
+				prev := entry.Prev
+				status.Jobs[i].Prev = &prev
+			}
+			if !entry.Next.IsZero() {
+				next := entry.Next
+				status.Jobs[i].Next = &next
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// recordJobRun adds the outcome of run f of job id to its counters and writes
+// the status file. Errors are logged, not fatal.
+func recordJobRun(s *statusFile, id cronolizer.EntryID, f fire, started time.Time, duration time.Duration, runErr error) {
+	run := recentRun{Started: started, DurationSeconds: duration.Seconds(), Params: f.params}
+	if !f.scheduled.IsZero() {
+		run.Scheduled = &f.scheduled
+	}
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			js := &status.Jobs[i]
+			if js.ID != int(id) {
+				continue
+			}
+			js.Runs++
+			if runErr != nil {
+				js.Failures++
+				js.ConsecutiveFailures++
+				run.Result = resultFailed
+			} else {
+				js.Successes++
+				js.ConsecutiveFailures = 0
+				run.Result = resultOK
+			}
+			js.addRecentRun(run)
+			js.AverageDurationSeconds += (duration.Seconds() - js.AverageDurationSeconds) / float64(js.Runs)
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// recordCancelledRun records a run of job id killed by `cronolize kill`
+// (neither a success nor a failure) and writes the status file. Errors are
+// logged, not fatal.
+func recordCancelledRun(s *statusFile, id cronolizer.EntryID, started time.Time, duration time.Duration) {
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			if status.Jobs[i].ID == int(id) {
+				status.Jobs[i].Runs++
+				status.Jobs[i].Cancelled++
+				status.Jobs[i].addRecentRun(recentRun{Result: resultCancelled, Started: started, DurationSeconds: duration.Seconds()})
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// recordSkippedRun records a run of job id skipped because its preconditions
+// were not met and writes the status file. Errors are logged, not fatal.
+func recordSkippedRun(s *statusFile, id cronolizer.EntryID) {
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			if status.Jobs[i].ID == int(id) {
+				status.Jobs[i].Skipped++
+				status.Jobs[i].addRecentRun(recentRun{Result: resultSkipped, Started: time.Now()})
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// setJobEnabled records whether job id is enabled and writes the status file.
+// Errors are logged, not fatal.
+func setJobEnabled(s *statusFile, id cronolizer.EntryID, enabled bool) {
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			if status.Jobs[i].ID == int(id) {
+				status.Jobs[i].Enabled = enabled
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// setJobSpec records the schedule of job id and its next run, and writes the
+// status file. Errors are logged, not fatal.
+func setJobSpec(s *statusFile, c *cronolizer.Scheduler, id cronolizer.EntryID, spec string) {
+	next := c.Entry(id).Next
+	err := s.update(func(status *daemonStatus) {
+		for i := range status.Jobs {
+			if status.Jobs[i].ID == int(id) {
+				status.Jobs[i].Spec = spec
+				if !next.IsZero() {
+					status.Jobs[i].Next = &next
+				}
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// addJobStatus adds job j to the status file. Errors are logged, not fatal.
+func addJobStatus(s *statusFile, c *cronolizer.Scheduler, j *job) {
+	err := s.update(func(status *daemonStatus) {
+		status.Jobs = append(status.Jobs, j.status())
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+	updateJobStatus(s, c, j.id)
+}
+
+// removeJobStatus removes job id from the status file. Errors are logged, not
+// fatal.
+func removeJobStatus(s *statusFile, id cronolizer.EntryID) {
+	err := s.update(func(status *daemonStatus) {
+		jobs := make([]jobStatus, 0, len(status.Jobs))
+		for _, js := range status.Jobs {
+			if js.ID != int(id) {
+				jobs = append(jobs, js)
+			}
+		}
+		status.Jobs = jobs
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// addActiveRun records that run has started and writes the status file.
+// Errors are logged, not fatal.
+func addActiveRun(s *statusFile, run runStatus) {
+	err := s.update(func(status *daemonStatus) {
+		status.ActiveRuns = append(status.ActiveRuns, run)
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// removeActiveRun records that run runID has finished and writes the status
+// file. Errors are logged, not fatal.
+func removeActiveRun(s *statusFile, runID int) {
+	err := s.update(func(status *daemonStatus) {
+		runs := make([]runStatus, 0, len(status.ActiveRuns))
+		for _, run := range status.ActiveRuns {
+			if run.RunID != runID {
+				runs = append(runs, run)
+			}
+		}
+		status.ActiveRuns = runs
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// setActiveRunFrozen records whether run runID is frozen and writes the status
+// file. Errors are logged, not fatal.
+func setActiveRunFrozen(s *statusFile, runID int, frozen bool) {
+	err := s.update(func(status *daemonStatus) {
+		runs := make([]runStatus, len(status.ActiveRuns))
+		copy(runs, status.ActiveRuns)
+		for i := range runs {
+			if runs[i].RunID == runID {
+				runs[i].Frozen = frozen
+			}
+		}
+		status.ActiveRuns = runs
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// setActiveRunUsage records the latest resource sample of run runID and writes
+// the status file. Errors are logged, not fatal.
+func setActiveRunUsage(s *statusFile, runID int, usage resourceUsage) {
+	err := s.update(func(status *daemonStatus) {
+		runs := make([]runStatus, len(status.ActiveRuns))
+		copy(runs, status.ActiveRuns)
+		for i := range runs {
+			if runs[i].RunID == runID {
+				runs[i].Usage = &usage
+			}
+		}
+		status.ActiveRuns = runs
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+// setPoolStats records the worker pool metrics and writes the status file.
+// Errors are logged, not fatal.
+func setPoolStats(s *statusFile, stats poolStats) {
+	err := s.update(func(status *daemonStatus) {
+		status.Running = stats.Running
+		status.Queued = stats.Queued
+		status.Dropped = stats.Dropped
+	})
+	if err != nil {
+		log.Printf("Unable to write status file: %v", err)
+	}
+}
+
+func (s *statusFile) remove() {
+	os.Remove(s.path)
+}
+
+// isAlive reports whether a process with pid exists.
+func isAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// readStatuses returns the status of all live daemons of this user sorted by
+// PID, or of the one listening on the socket given by -socket.
+func readStatuses() ([]daemonStatus, error) {
+	if len(controlSocketOverride) > 0 {
+		status, err := queryStatus(controlSocketOverride)
+		if err != nil {
+			return nil, err
+		}
+		return []daemonStatus{status}, nil
+	}
+	return readStatusesIn(runtimeDir())
+}
+
+// readStatusesIn returns the status of all live daemons with a status file in
+// dir sorted by PID, status files this user may not read are skipped.
+func readStatusesIn(dir string) ([]daemonStatus, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+statusFileExt))
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]daemonStatus, 0, len(matches))
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+				continue
+			}
+			return nil, err
+		}
+		var status daemonStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			pe("Warning: ignoring %s: %v", match, err)
+			continue
+		}
+		if !isAlive(status.PID) {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].PID < statuses[j].PID
+	})
+	return statuses, nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// hasTags reports whether the job is tagged with all of tags.
+func (js jobStatus) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range js.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// filterByTags removes all jobs not tagged with all of tags, and daemons left
+// without jobs.
+func filterByTags(statuses []daemonStatus, tags []string) []daemonStatus {
+	if len(tags) == 0 {
+		return statuses
+	}
+	filtered := make([]daemonStatus, 0, len(statuses))
+	for _, status := range statuses {
+		jobs := make([]jobStatus, 0, len(status.Jobs))
+		for _, js := range status.Jobs {
+			if js.hasTags(tags) {
+				jobs = append(jobs, js)
+			}
+		}
+		if len(jobs) == 0 {
+			continue
+		}
+		status.Jobs = jobs
+		filtered = append(filtered, status)
+	}
+	return filtered
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fatal(err)
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// listCmd implements `cronolize list [-json]` listing all jobs of all daemons.
+func listCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(listCommand, flag.ExitOnError)
+	asJSON := cmdFlags.Bool("json", false, "Output as JSON")
+	var tags stringList
+	cmdFlags.Var(&tags, "tag", "Only include jobs with this tag (repeatable, jobs must have all tags)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Parse(args)
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	statuses = filterByTags(statuses, tags)
+	jobs := make([]jobStatus, 0)
+	for _, status := range statuses {
+		jobs = append(jobs, status.Jobs...)
+	}
+	if *asJSON {
+		printJSON(jobs)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tID\tNAME\tTAGS\tENABLED\tSPEC\tPREV\tNEXT\tCOMMAND")
+	for _, job := range jobs {
+		spec := job.Spec
+		if len(job.Watch) > 0 {
+			if len(spec) > 0 {
+				spec += ", "
+			}
+			spec += "watch " + job.Watch
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n", job.PID, job.ID, job.Name, strings.Join(job.Tags, ","), job.Enabled, spec, formatTime(job.Prev), formatTime(job.Next), job.Command)
+	}
+	tw.Flush()
+}
+
+// statusCmd implements `cronolize status [-json]` showing all daemons.
+func statusCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(statusCommand, flag.ExitOnError)
+	asJSON := cmdFlags.Bool("json", false, "Output as JSON")
+	var tags stringList
+	cmdFlags.Var(&tags, "tag", "Only include jobs with this tag (repeatable, jobs must have all tags)")
+	recent := cmdFlags.Int("recent", 5, fmt.Sprintf("Show the results of this many recent runs per job (at most %d)", recentRunsKept))
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Parse(args)
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	statuses = filterByTags(statuses, tags)
+	if *asJSON {
+		printJSON(statuses)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tVERSION\tSTARTED\tMODE\tMAINTENANCE\tJOBS\tRUNNING\tQUEUED\tDROPPED\tLOG")
+	for _, status := range statuses {
+		mode := "daemon"
+		if status.Foreground {
+			mode = "foreground"
+		}
+		maintenance := "-"
+		if m := status.Maintenance; m != nil {
+			maintenance = "since " + formatTime(&m.Since)
+			if m.Until != nil {
+				maintenance += ", until " + formatTime(m.Until)
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", status.PID, status.Version, formatTime(&status.Started), mode, maintenance, len(status.Jobs), status.Running, status.Queued, status.Dropped, status.Logfile)
+	}
+	tw.Flush()
+	if len(statuses) == 0 {
+		return
+	}
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tNAME\tRUNS\tOK\tFAILED\tCANCELLED\tSKIPPED\tCONSECUTIVE FAILURES\tAVG DURATION\tPREV\tNEXT\tRECENT")
+	for _, status := range statuses {
+		for _, job := range status.Jobs {
+			avg := time.Duration(job.AverageDurationSeconds * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", job.PID, job.Name, job.Runs, job.Successes, job.Failures, job.Cancelled, job.Skipped, job.ConsecutiveFailures, avg, formatTime(job.Prev), formatTime(job.Next), formatRecent(job.Recent, *recent))
+		}
+	}
+	tw.Flush()
+}
+
+// recentMarks mark the results of recent runs in the status table.
+var recentMarks = map[string]string{
+	resultOK:        "✓",
+	resultFailed:    "✗",
+	resultCancelled: "⊘",
+	resultSkipped:   "-",
+}
+
+// formatRecent returns the last n of the recent runs, oldest first, as marks
+// followed by durations, e.g. "✓1.2s ✗3s -", or "-" if there are none.
+func formatRecent(recent []recentRun, n int) string {
+	if n <= 0 || len(recent) == 0 {
+		return "-"
+	}
+	if len(recent) > n {
+		recent = recent[len(recent)-n:]
+	}
+	runs := make([]string, len(recent))
+	for i, r := range recent {
+		runs[i] = recentMarks[r.Result]
+		if r.Result != resultSkipped {
+			d := time.Duration(r.DurationSeconds * float64(time.Second))
+			switch {
+			case d < time.Second:
+				d = d.Round(time.Millisecond)
+			case d < time.Minute:
+				d = d.Round(100 * time.Millisecond)
+			default:
+				d = d.Round(time.Second)
+			}
+			runs[i] += d.String()
+		}
+	}
+	return strings.Join(runs, " ")
+}
+
+// runningCmd implements `cronolize running [-json]` showing the runs currently
+// executing in all daemons.
+func runningCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(runningCommand, flag.ExitOnError)
+	asJSON := cmdFlags.Bool("json", false, "Output as JSON")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Parse(args)
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	type daemonRun struct {
+		Daemon int `json:"daemon"`
+		runStatus
+	}
+	runs := make([]daemonRun, 0)
+	for _, status := range statuses {
+		for _, run := range status.ActiveRuns {
+			runs = append(runs, daemonRun{Daemon: status.PID, runStatus: run})
+		}
+	}
+	if *asJSON {
+		printJSON(runs)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAEMON\tJOB\tRUN\tPID\tSTATE\tSTARTED\tELAPSED\tCPU\tMEM\tPEAK MEM\tCOMMAND")
+	for _, run := range runs {
+		elapsed := time.Since(run.Started).Round(time.Second)
+		state := "running"
+		if run.Frozen {
+			state = "frozen"
+		}
+		cpu, mem, peak := "-", "-", "-"
+		if u := run.Usage; u != nil {
+			cpu = fmt.Sprintf("%.0f%%", u.CPUPercent)
+			mem, peak = formatBytes(u.MemoryBytes), formatBytes(u.PeakMemoryBytes)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", run.Daemon, run.Job, run.RunID, run.PID, state, formatTime(&run.Started), elapsed, cpu, mem, peak, run.Command)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+// `cronolize start` starts a daemon exactly like cronolize without a
+// subcommand, so scripts can read as `cronolize start ...` and `cronolize stop
+// PID`. A daemon is identified by its PID, the name of its status file in the
+// runtime directory, as shown by `cronolize status`.
+//
+// `cronolize stop` asks the daemon to exit over its control socket (so the
+// audit log records who stopped it), falling back to SIGTERM, and waits for
+// it to be gone. Runs in progress are not waited for, stopping a daemon is
+// the same as sending it SIGTERM.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	startCommand string = "start"
+	stopCommand  string = "stop"
+	// stopPollInterval is how often stop checks if the daemon is gone.
+	stopPollInterval time.Duration = 100 * time.Millisecond
+	defaultStopWait  time.Duration = 10 * time.Second
+)
+
+// stopDaemon asks the daemon of status to exit and waits up to wait for it
+// to.
+func stopDaemon(status daemonStatus, wait time.Duration) error {
+	err := errors.New("no control socket")
+	if len(status.ControlSocket) > 0 {
+		err = sendControl(status.ControlSocket, controlRequest{Command: stopCommand})
+	}
+	if err != nil {
+		if err := syscall.Kill(status.PID, syscall.SIGTERM); err != nil {
+			return err
+		}
+	}
+	for deadline := time.Now().Add(wait); isAlive(status.PID); {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("still running after %s", wait)
+		}
+		time.Sleep(stopPollInterval)
+	}
+	return nil
+}
+
+// stopCmd implements `cronolize stop [-statedir directory] [-all] [-wait
+// DURATION] [PID...]`.
+func stopCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(stopCommand, flag.ExitOnError)
+	all := cmdFlags.Bool("all", false, "Stop every running cronolize process")
+	wait := cmdFlags.Duration("wait", defaultStopWait, "How long to wait for a process to exit")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-statedir directory] [-all] [-wait DURATION] [PID...]", os.Args[0], stopCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if *all && cmdFlags.NArg() > 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	pids := make(map[int]bool)
+	for _, arg := range cmdFlags.Args() {
+		pid, err := strconv.Atoi(arg)
+		if err != nil || pid <= 0 {
+			fatalf("Syntax error: %q is not a PID", arg)
+		}
+		pids[pid] = true
+	}
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	var stop []daemonStatus
+	for _, status := range statuses {
+		if *all || pids[status.PID] {
+			stop = append(stop, status)
+			delete(pids, status.PID)
+		}
+	}
+	if len(pids) == 0 && len(stop) == 0 && !*all {
+		// Without arguments, the only process running is stopped.
+		switch len(statuses) {
+		case 0:
+		case 1:
+			stop = statuses
+		default:
+			fatal("several cronolize processes are running, give the PID of the one to stop or use -all")
+		}
+	}
+	failed := false
+	for pid := range pids {
+		pe("%s no cronolize process with PID %d", colorize("Error:", ansiBold, ansiRed), pid)
+		failed = true
+	}
+	if len(stop) == 0 && !failed {
+		fatal("no cronolize processes are running")
+	}
+	for _, status := range stop {
+		if err := stopDaemon(status, *wait); err != nil {
+			pe("%s process %d: %v", colorize("Error:", ansiBold, ansiRed), status.PID, err)
+			failed = true
+			continue
+		}
+		p("Stopped PID %d", status.PID)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
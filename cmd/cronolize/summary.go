@@ -0,0 +1,67 @@
+package main
+
+// After every run a single summary line is logged regardless of -q, as the
+// canonical record of what happened, e.g.
+//
+//	backup: Summary: job=backup run_id=12 scheduled=2026-10-15T03:00:00.000+02:00 start=2026-10-15T03:00:00.104+02:00 end=2026-10-15T03:01:12.530+02:00 duration=1m12.426s exit_code=0 output_bytes=5120
+//
+// The values are logfmt, quoted if they contain spaces, quotes or an equals
+// sign. The times are RFC 3339 with milliseconds unless -log-time-format is
+// given, see logtime.go. scheduled is - for runs not started by the schedule (by a watched
+// path or `cronolize run`), run_id is - if the command could not be started.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const summaryTimeFormat string = "2006-01-02T15:04:05.000Z07:00"
+
+// byteCounter is an io.Writer counting the bytes written to it, it may be
+// written to concurrently.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+func (c *byteCounter) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// runSummary is the summary line of a run.
+type runSummary struct {
+	job         string
+	runID       int
+	scheduled   time.Time
+	start, end  time.Time
+	exitCode    int
+	outputBytes int64
+	// times is how the times are formatted.
+	times logTimeFormat
+}
+
+func (s runSummary) String() string {
+	value := func(v string) string {
+		if len(v) == 0 || strings.ContainsAny(v, " \t\"=") {
+			return strconv.Quote(v)
+		}
+		return v
+	}
+	runID, scheduled := "-", "-"
+	if s.runID > 0 {
+		runID = strconv.Itoa(s.runID)
+	}
+	if !s.scheduled.IsZero() {
+		scheduled = value(s.times.format(s.scheduled))
+	}
+	return fmt.Sprintf("Summary: job=%s run_id=%s scheduled=%s start=%s end=%s duration=%s exit_code=%d output_bytes=%d",
+		value(s.job), runID, scheduled, value(s.times.format(s.start)), value(s.times.format(s.end)),
+		s.end.Sub(s.start).Round(time.Millisecond), s.exitCode, s.outputBytes)
+}
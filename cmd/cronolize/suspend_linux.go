@@ -0,0 +1,37 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Clock IDs of clock_gettime(2).
+const (
+	clockMonotonic = 1
+	clockBoottime  = 7
+)
+
+func clockGettime(id uintptr) (time.Duration, error) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, id, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// suspendedTime returns how long the system has been suspended since it
+// booted, CLOCK_BOOTTIME includes the time suspended, CLOCK_MONOTONIC does
+// not.
+func suspendedTime() (time.Duration, bool) {
+	monotonic, err := clockGettime(clockMonotonic)
+	if err != nil {
+		return 0, false
+	}
+	boottime, err := clockGettime(clockBoottime)
+	if err != nil {
+		return 0, false
+	}
+	return boottime - monotonic, true
+}
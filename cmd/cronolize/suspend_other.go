@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+// suspendedTime is not supported, the time suspended can only be told apart
+// from a stepped clock on Linux.
+func suspendedTime() (time.Duration, bool) {
+	return 0, false
+}
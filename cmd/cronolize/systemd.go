@@ -0,0 +1,85 @@
+package main
+
+// A job with systemd_scope: true in a config (or every job with
+// -systemd-scope) runs in a transient systemd scope unit of its own, named
+// cronolize-JOB-PID-N.scope, so systemd tracks and accounts for all of its
+// processes and `systemctl status` (or `systemctl --user status` when
+// cronolize is not run by root) shows what is running. Resource controls are
+// set with systemd_properties, e.g.
+//
+//	systemd_scope: true
+//	systemd_properties: [MemoryMax=2G, CPUWeight=20]
+//
+// The command is run by systemd-run --scope, which asks systemd over D-Bus to
+// create the scope before executing the command in it with the same PID, so
+// not even the first process it forks escapes the scope.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	systemdScopeFlag string = "systemd-scope"
+	systemdRun       string = "systemd-run"
+)
+
+// lastScopeID numbers the scopes of this process.
+var lastScopeID int64
+
+// checkSystemdRun returns an error unless systemd-run is available.
+func checkSystemdRun() error {
+	if _, err := exec.LookPath(systemdRun); err != nil {
+		return errors.New(systemdRun + " not found, running jobs in systemd scopes requires systemd")
+	}
+	return nil
+}
+
+// checkSystemdProperty returns an error unless property is NAME=VALUE.
+func checkSystemdProperty(property string) error {
+	name, _, ok := strings.Cut(property, "=")
+	if !ok || len(name) == 0 {
+		return fmt.Errorf("%q is not NAME=VALUE", property)
+	}
+	return nil
+}
+
+// scopeUnitName returns a unit name for the next scope of the job, characters
+// not allowed in unit names are replaced by underscores.
+func scopeUnitName(job string) string {
+	escaped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == ':':
+			return r
+		}
+		return '_'
+	}, job)
+	return fmt.Sprintf("cronolize-%s-%d-%d.scope", escaped, os.Getpid(), atomic.AddInt64(&lastScopeID, 1))
+}
+
+// scopeArgv returns argv wrapped to run in a new transient scope. The scope is
+// created by the user's service manager unless cronolize runs as root. If the
+// job runs as another user, systemd-run switches to it after creating the
+// scope, the command must then be started without the job's credentials.
+func (j *job) scopeArgv(argv []string) []string {
+	wrapped := []string{systemdRun, "--scope", "--quiet", "--collect",
+		"--unit=" + scopeUnitName(j.Name),
+		"--description=cronolize job " + j.Name}
+	if os.Geteuid() != 0 {
+		wrapped = append(wrapped, "--user")
+	}
+	if j.cred != nil && j.cred.cred != nil {
+		wrapped = append(wrapped,
+			"--uid="+strconv.FormatUint(uint64(j.cred.cred.Uid), 10),
+			"--gid="+strconv.FormatUint(uint64(j.cred.cred.Gid), 10))
+	}
+	for _, property := range j.SystemdProperties {
+		wrapped = append(wrapped, "--property="+property)
+	}
+	return append(append(wrapped, "--"), argv...)
+}
@@ -0,0 +1,85 @@
+package main
+
+// With -tag-output, the standard output and error of a command are read from
+// separate pipes and every line is logged prefixed by the stream it was
+// written to, in the order the lines were completed:
+//
+//	[out] copying 1200 files
+//	[err] warning: /srv/a/b: permission denied
+//	[out] done
+//
+// Lines are written whole, so a line of one stream is never torn by output on
+// the other, however chatty both are. A last line without a newline is
+// completed when the command exits, a line longer than maxTaggedLine is split.
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	tagOutputFlag string = "tag-output"
+	stdoutTag     string = "[out] "
+	stderrTag     string = "[err] "
+	maxTaggedLine int    = 64 * 1024
+)
+
+// streamTagger serializes the lines of the streams of a command.
+type streamTagger struct {
+	mu sync.Mutex
+}
+
+// taggedStream is an io.Writer for a stream of a command, the writes of each
+// stream must not be concurrent (as when written by os/exec).
+type taggedStream struct {
+	t    *streamTagger
+	w    io.Writer
+	tag  string
+	line []byte
+}
+
+// stream returns the writer of a stream whose lines are written to w prefixed
+// by tag.
+func (t *streamTagger) stream(w io.Writer, tag string) *taggedStream {
+	return &taggedStream{t: t, w: w, tag: tag}
+}
+
+func (s *taggedStream) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.line = append(s.line, p...)
+			if len(s.line) >= maxTaggedLine {
+				return n, s.writeLine()
+			}
+			break
+		}
+		s.line = append(s.line, p[:i]...)
+		if err := s.writeLine(); err != nil {
+			return n, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Flush completes a last line without a newline.
+func (s *taggedStream) Flush() error {
+	if len(s.line) == 0 {
+		return nil
+	}
+	return s.writeLine()
+}
+
+// writeLine writes the pending line completed by a newline.
+func (s *taggedStream) writeLine() error {
+	line := make([]byte, 0, len(s.tag)+len(s.line)+1)
+	line = append(append(append(line, s.tag...), s.line...), '\n')
+	s.line = s.line[:0]
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	_, err := s.w.Write(line)
+	return err
+}
@@ -0,0 +1,9 @@
+package main
+
+// A run taking longer than -timeout (or timeout of its job in a config) is
+// killed: its command and everything it started get SIGTERM, and SIGKILL
+// cronolizer.TimeoutGrace later if they are still around. The run fails with
+// "timed out after ..." and is alerted about like any other failure, so hung
+// runs no longer pile up.
+
+const timeoutFlag string = "timeout"
@@ -0,0 +1,33 @@
+package main
+
+// A job that timestamps or signs data can require the system clock to be
+// synchronized, e.g. by NTP, using
+//
+//	require_ntp_sync: true
+//
+// in a config. While the kernel reports the clock as unsynchronized, or if
+// that can not be told, the job is skipped with a warning (logged even with
+// -q) and the skip counted in the status. The state is read using adjtimex(2),
+// as by `timedatectl`, which is only supported on Linux.
+
+import "errors"
+
+// errClockUnsynchronized is why a job requiring a synchronized clock is
+// skipped.
+var errClockUnsynchronized = errors.New("the system clock is not synchronized")
+
+// checkClockSync returns why the job should not run with the state of the
+// system clock, nil if it can run.
+func (j *job) checkClockSync() error {
+	if !j.RequireNTPSync {
+		return nil
+	}
+	synced, err := clockSynchronized()
+	if err != nil {
+		return errors.New("unable to tell if the system clock is synchronized: " + err.Error())
+	}
+	if !synced {
+		return errClockUnsynchronized
+	}
+	return nil
+}
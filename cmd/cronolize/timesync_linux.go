@@ -0,0 +1,24 @@
+package main
+
+import "syscall"
+
+const (
+	clockSyncSupported = true
+	// timeError is the TIME_ERROR clock state of adjtimex(2), the clock is
+	// not synchronized.
+	timeError = 5
+	// staUnsync is the STA_UNSYNC status bit of adjtimex(2).
+	staUnsync = 0x0040
+)
+
+// clockSynchronized reads whether the kernel considers the system clock
+// synchronized, like the NTPSynchronized property of timedatectl(1).
+func clockSynchronized() (bool, error) {
+	// A zero modes only reads the state.
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return false, err
+	}
+	return state != timeError && tx.Status&staUnsync == 0, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const clockSyncSupported = false
+
+// clockSynchronized is not supported, the clock state is only read on Linux.
+func clockSynchronized() (bool, error) {
+	return false, errors.New("only supported on Linux")
+}
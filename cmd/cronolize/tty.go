@@ -0,0 +1,131 @@
+package main
+
+// Interactive niceties for the -fg mode when attached to a terminal: colored
+// job headers and failures, and a live "next run in" countdown line that is
+// cleared while a job is running so it never interleaves with job output.
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ansiReset     string = "\033[0m"
+	ansiBold      string = "\033[1m"
+	ansiDim       string = "\033[2m"
+	ansiRed       string = "\033[31m"
+	ansiYellow    string = "\033[33m"
+	ansiCyan      string = "\033[36m"
+	ansiClearLine string = "\r\033[K"
+)
+
+// useColor is set when running in the foreground on a terminal (and NO_COLOR
+// is not set, see https://no-color.org/).
+var useColor bool
+
+// isTerminal reports whether f is a character device, i.e. most likely a TTY.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the ANSI escape code(s) if useColor is true, otherwise
+// returns s as is.
+func colorize(s string, codes ...string) string {
+	if !useColor || len(codes) == 0 {
+		return s
+	}
+	var prefix string
+	for _, code := range codes {
+		prefix += code
+	}
+	return prefix + s + ansiReset
+}
+
+// formatCountdown formats d as MM:SS, HH:MM:SS when an hour or more away, or
+// with a day prefix (e.g. 2d 03:04:05) when a day or more away.
+func formatCountdown(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %02d:%02d:%02d", days, h, m, s)
+	case h > 0:
+		return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+	default:
+		return fmt.Sprintf("%02d:%02d", m, s)
+	}
+}
+
+// countdown redraws a single "next run in" status line every second. Calls to
+// pause() and resume() bracket job executions (they may overlap), the line is
+// only drawn when no job is running.
+type countdown struct {
+	mu      sync.Mutex
+	running int
+	next    func() time.Time
+	out     *os.File
+}
+
+func newCountdown(out *os.File, next func() time.Time) *countdown {
+	return &countdown{out: out, next: next}
+}
+
+// start draws the countdown line every second until the process exits.
+func (c *countdown) start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		c.draw()
+		for range ticker.C {
+			c.draw()
+		}
+	}()
+}
+
+func (c *countdown) draw() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running > 0 {
+		return
+	}
+	next := c.next()
+	if next.IsZero() {
+		return
+	}
+	fmt.Fprint(c.out, ansiClearLine+colorize("next run in "+formatCountdown(time.Until(next)), ansiDim))
+}
+
+// pause clears the countdown line and stops redrawing it until resume() has
+// been called as many times as pause().
+func (c *countdown) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running == 0 {
+		fmt.Fprint(c.out, ansiClearLine)
+	}
+	c.running++
+}
+
+func (c *countdown) resume() {
+	c.mu.Lock()
+	if c.running > 0 {
+		c.running--
+	}
+	c.mu.Unlock()
+	c.draw()
+}
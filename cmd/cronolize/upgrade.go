@@ -0,0 +1,187 @@
+package main
+
+// `cronolize upgrade` replaces running background daemons with the cronolize
+// binary currently on disk without a window where runs are missed. The old
+// process stops its scheduler, writes its state (the next run of every job,
+// runtime changes such as disabled or added jobs, run counters and the
+// maintenance window) to a handover file and starts the new binary with the
+// same arguments. The new process takes over the state and, once it has
+// reported back that it is ready, the old process waits for its running jobs
+// to finish and exits. If the new process fails to start, the old one resumes.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const (
+	upgradeCommand string = "upgrade"
+	// handoverEnvVar is set to the path of the handover file in the
+	// environment of the new process.
+	handoverEnvVar string = "__CRONOLIZER_HANDOVER__"
+	// handoverReadyFD is the file descriptor the new process reports back on.
+	handoverReadyFD     uintptr       = 3
+	handoverReady       string        = "ready"
+	handoverReadTimeout time.Duration = 30 * time.Second
+)
+
+// handover is the state passed from the old to the new process.
+type handover struct {
+	PID int `json:"pid"`
+	// Jobs have the next run time from the scheduler of the old process.
+	Jobs []jobStatus `json:"jobs"`
+	// Added are the jobs added at runtime (and not persisted in a config),
+	// Removed the names of the jobs removed at runtime.
+	Added       []*job             `json:"added,omitempty"`
+	Removed     []string           `json:"removed,omitempty"`
+	Maintenance *maintenanceStatus `json:"maintenance,omitempty"`
+}
+
+// job returns the handed over state of the job named name, nil if none.
+func (h *handover) job(name string) *jobStatus {
+	for i := range h.Jobs {
+		if h.Jobs[i].Name == name {
+			return &h.Jobs[i]
+		}
+	}
+	return nil
+}
+
+// startUpgrade writes h to a handover file and starts the cronolize binary
+// with the arguments of this process. It returns the PID of the new process
+// once it has taken over.
+func startUpgrade(h handover) (int, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return 0, err
+	}
+	path := filepath.Join(runtimeDir(), strconv.Itoa(os.Getpid())+".handover")
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return 0, err
+	}
+	// The new process removes the file once read.
+	defer os.Remove(path)
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cmd, err := cronolizer.StartBackground(os.Args[1:], []string{cronolizerEnvVar + "=" + envVarValueExpected, handoverEnvVar + "=" + path}, []*os.File{w})
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	// Wait for the new process to report back (or exit).
+	ready := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		if scanner.Scan() && scanner.Text() == handoverReady {
+			ready <- nil
+			return
+		}
+		ready <- errors.New("the new process exited before taking over")
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(handoverReadTimeout):
+		err = fmt.Errorf("the new process did not take over within %s", handoverReadTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	// The process is not waited for, it outlives this one.
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// readHandover reads the state handed over by the process that started this
+// one, nil if this process was not started by an upgrade.
+func readHandover() (*handover, error) {
+	path, ok := os.LookupEnv(handoverEnvVar)
+	if !ok {
+		return nil, nil
+	}
+	os.Unsetenv(handoverEnvVar)
+	// Keep the jobs from inheriting the ready pipe.
+	syscall.CloseOnExec(int(handoverReadyFD))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+	var h handover
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &h, nil
+}
+
+// reportTakeover tells the old process that this one has taken over.
+func reportTakeover() error {
+	f := os.NewFile(handoverReadyFD, "handover")
+	defer f.Close()
+	_, err := fmt.Fprintln(f, handoverReady)
+	return err
+}
+
+// upgradeCmd implements `cronolize upgrade [-pid PID]`.
+func upgradeCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(upgradeCommand, flag.ExitOnError)
+	pid := cmdFlags.Int("pid", 0, "PID of the cronolize process to upgrade (default all background processes)")
+	addStateDirFlag(cmdFlags)
+	addControlSocketFlag(cmdFlags)
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-pid PID] [-statedir directory]", os.Args[0], upgradeCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() != 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	statuses, err := readStatuses()
+	if err != nil {
+		fatal(err)
+	}
+	found, failed := false, false
+	for _, status := range statuses {
+		if *pid != 0 && status.PID != *pid {
+			continue
+		}
+		if status.Foreground && *pid == 0 {
+			continue
+		}
+		found = true
+		if len(status.ControlSocket) == 0 {
+			pe("%s process %d has no control socket", colorize("Error:", ansiBold, ansiRed), status.PID)
+			failed = true
+			continue
+		}
+		if err := sendControl(status.ControlSocket, controlRequest{Command: upgradeCommand}); err != nil {
+			pe("%s process %d: %v", colorize("Error:", ansiBold, ansiRed), status.PID, err)
+			failed = true
+		}
+	}
+	if !found {
+		if *pid != 0 {
+			fatalf("Error: no cronolize process with PID %d", *pid)
+		}
+		fatal("no cronolize processes are running in the background")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+// A job with wasm instead of command in a config runs a WASI module (a single
+// .wasm file) on each fire, so sandboxed job logic can be distributed without
+// shell scripts:
+//
+//	- name: report
+//	  schedule: "@daily"
+//	  wasm: /opt/jobs/report.wasm
+//	  wasm_args: [--since, 24h]
+//	  wasm_mounts: [/var/lib/report, /etc/report:/config:ro]
+//
+// The module is run by `cronolize wasm` in a process of its own, so it is
+// logged, sampled, killed and limited like any other command. It sees the
+// environment of the job, where CRONOLIZE_JOB, CRONOLIZE_SCHEDULE and
+// CRONOLIZE_TIME (when the run started, RFC 3339) describe the run, but no
+// files except the directories in wasm_mounts (HOST[:GUEST][:ro], mounted at
+// the same path unless GUEST is given).
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const wasmCommand string = "wasm"
+
+// wasmMagic starts every binary WebAssembly module.
+var wasmMagic = []byte("\x00asm")
+
+// mountFlag collects -mount options.
+type mountFlag []string
+
+func (m *mountFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *mountFlag) Set(s string) error {
+	if _, _, _, err := parseWasmMount(s); err != nil {
+		return err
+	}
+	*m = append(*m, s)
+	return nil
+}
+
+// parseWasmMount parses HOST[:GUEST][:ro].
+func parseWasmMount(s string) (host, guest string, readOnly bool, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 1 && parts[len(parts)-1] == "ro" {
+		readOnly = true
+		parts = parts[:len(parts)-1]
+	}
+	switch len(parts) {
+	case 1:
+		host, guest = parts[0], parts[0]
+	case 2:
+		host, guest = parts[0], parts[1]
+	default:
+		return "", "", false, fmt.Errorf("%q is not HOST[:GUEST][:ro]", s)
+	}
+	if len(host) == 0 || len(guest) == 0 {
+		return "", "", false, fmt.Errorf("%q is not HOST[:GUEST][:ro]", s)
+	}
+	return host, guest, readOnly, nil
+}
+
+// checkWasmModule returns an error if path is not a binary WebAssembly module.
+// The module is compiled when run, not here.
+func checkWasmModule(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, len(wasmMagic))
+	if _, err := f.Read(magic); err != nil || !bytes.Equal(magic, wasmMagic) {
+		return fmt.Errorf("%s is not a WebAssembly module", path)
+	}
+	return nil
+}
+
+// wasmArgv returns the command line running the job's module using this
+// executable.
+func (j *job) wasmArgv() ([]string, error) {
+	if len(j.Command) > 0 {
+		return nil, errors.New("wasm: a job has either a command or a wasm module, not both")
+	}
+	if err := checkWasmModule(j.Wasm); err != nil {
+		return nil, fmt.Errorf("wasm: %v", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("wasm: %v", err)
+	}
+	argv := []string{self, wasmCommand}
+	for _, mount := range j.WasmMounts {
+		if _, _, _, err := parseWasmMount(mount); err != nil {
+			return nil, fmt.Errorf("wasm_mounts: %v", err)
+		}
+		argv = append(argv, "-mount", mount)
+	}
+	return append(append(argv, j.Wasm), j.WasmArgs...), nil
+}
+
+// wasmEnv describes the run to the module.
+func (j *job) wasmEnv(started time.Time) []string {
+	return []string{
+		"CRONOLIZE_JOB=" + j.Name,
+		"CRONOLIZE_SCHEDULE=" + j.Schedule,
+		"CRONOLIZE_TIME=" + started.Format(time.RFC3339),
+	}
+}
+
+// wasmCmd runs a WASI module with the standard input, output, error and
+// environment of this process, exiting with the exit code of the module.
+func wasmCmd(args []string) {
+	cmdFlags := flag.NewFlagSet(wasmCommand, flag.ExitOnError)
+	var mounts mountFlag
+	cmdFlags.Var(&mounts, "mount", "Directory the module may access as HOST[:GUEST][:ro], may be repeated")
+	cmdFlags.Usage = func() {
+		pe("Syntax: %s %s [-mount HOST[:GUEST][:ro]]... file [args...]", os.Args[0], wasmCommand)
+		cmdFlags.PrintDefaults()
+	}
+	cmdFlags.Parse(args)
+	if cmdFlags.NArg() < 1 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+	path := cmdFlags.Arg(0)
+	binary, err := os.ReadFile(path)
+	if err != nil {
+		fatal(err)
+	}
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	fsConfig := wazero.NewFSConfig()
+	for _, mount := range mounts {
+		host, guest, readOnly, _ := parseWasmMount(mount)
+		if readOnly {
+			fsConfig = fsConfig.WithReadOnlyDirMount(host, guest)
+		} else {
+			fsConfig = fsConfig.WithDirMount(host, guest)
+		}
+	}
+	config := wazero.NewModuleConfig().
+		WithArgs(append([]string{filepath.Base(path)}, cmdFlags.Args()[1:]...)...).
+		WithStdin(os.Stdin).
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			config = config.WithEnv(k, v)
+		}
+	}
+	_, err = runtime.InstantiateWithConfig(ctx, binary, config)
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		runtime.Close(ctx)
+		os.Exit(int(exitErr.ExitCode()))
+	} else if err != nil {
+		fatal(err)
+	}
+}
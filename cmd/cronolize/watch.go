@@ -0,0 +1,115 @@
+package main
+
+// A job with watch in a config is run when the watched file or directory
+// changes, in addition to its schedule or instead of it if it has none:
+//
+//	jobs:
+//	  - name: ingest
+//	    watch: /srv/dropbox
+//	    debounce: 5s
+//	    command: process-dropbox.sh
+//
+// A burst of changes (a file being copied in, several files dropped at once)
+// runs the job once, debounce after the last change (1s by default). A
+// directory is watched for files created, written, moved or removed in it, not
+// in its subdirectories. Changes are picked up using inotify on Linux, other
+// platforms poll every second.
+
+import (
+	"time"
+
+	"github.com/sa6mwa/cronolizer/pkg/cronolizer"
+)
+
+const (
+	defaultDebounce time.Duration = time.Second
+	// pollInterval is how often a watched path is checked for changes
+	// where inotify is not available.
+	pollInterval time.Duration = time.Second
+)
+
+// neverSchedule is the schedule of a job only run when its watched path
+// changes, a zero next time is never reached.
+type neverSchedule struct{}
+
+func (neverSchedule) Next(time.Time) time.Time {
+	return time.Time{}
+}
+
+// scheduleOn schedules fn on c according to the job's schedule, never for a
+// job without one (only run by its watch).
+func (j *job) scheduleOn(c *cronolizer.Scheduler, fn func()) (cronolizer.EntryID, error) {
+	if len(j.Schedule) == 0 {
+		return c.Schedule(neverSchedule{}, fn), nil
+	}
+	return c.AddFunc(j.Schedule, fn)
+}
+
+// watcher calls fire when the watched path has not changed for debounce after
+// a change.
+type watcher struct {
+	changes *changeNotifier
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startWatcher watches path, it is an error if path can not be watched.
+func startWatcher(path string, debounce time.Duration, fire func()) (*watcher, error) {
+	changes, err := newChangeNotifier(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		changes: changes,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		defer reportPanic()
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		defer timer.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-changes.C:
+				timer.Stop()
+				timer.Reset(debounce)
+			case <-timer.C:
+				fire()
+			}
+		}
+	}()
+	return w, nil
+}
+
+// close stops watching, a run already fired is not waited for.
+func (w *watcher) close() {
+	close(w.stop)
+	<-w.done
+	w.changes.close()
+}
+
+// watch starts watching the job's path (if it has one) submitting it to d on
+// changes.
+func (j *job) watch(d *dispatcher) error {
+	if len(j.Watch) == 0 {
+		return nil
+	}
+	w, err := startWatcher(j.Watch, j.debounce, func() { d.submit(j, time.Time{}) })
+	if err != nil {
+		return err
+	}
+	j.watcher = w
+	return nil
+}
+
+// unwatch stops watching the job's path.
+func (j *job) unwatch() {
+	if j.watcher != nil {
+		j.watcher.close()
+		j.watcher = nil
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask is the inotify events counted as a change.
+const watchMask uint32 = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MODIFY | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// changeNotifier signals changes of a path on C, a burst of changes may be
+// signalled once.
+type changeNotifier struct {
+	C chan struct{}
+	f *os.File
+}
+
+// newChangeNotifier watches path using inotify. A file is watched through its
+// directory so it is still watched when replaced by a rename, as editors and
+// atomic writes do.
+func newChangeNotifier(path string) (*changeNotifier, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := path, ""
+	if !fi.IsDir() {
+		dir, name = filepath.Dir(path), filepath.Base(path)
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, watchMask); err != nil {
+		syscall.Close(fd)
+		return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	// A non-blocking descriptor is handled by the runtime poller, so Close
+	// interrupts a pending Read.
+	n := &changeNotifier{
+		C: make(chan struct{}, 1),
+		f: os.NewFile(uintptr(fd), "inotify"),
+	}
+	go n.read(name)
+	return n, nil
+}
+
+// read signals events about name (any event if empty) until closed.
+func (n *changeNotifier) read(name string) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.f.Read(buf)
+		if err != nil {
+			return
+		}
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			if len(name) == 0 || trimNUL(nameBytes) == name {
+				changed = true
+			}
+		}
+		if changed {
+			select {
+			case n.C <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// trimNUL returns b up to the first NUL byte as a string.
+func trimNUL(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+func (n *changeNotifier) close() {
+	n.f.Close()
+}
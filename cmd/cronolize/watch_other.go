@@ -0,0 +1,78 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// changeNotifier signals changes of a path on C, a burst of changes may be
+// signalled once.
+type changeNotifier struct {
+	C    chan struct{}
+	stop chan struct{}
+}
+
+// newChangeNotifier polls path every pollInterval for changes to its size,
+// modification time or (for a directory) the entries in it.
+func newChangeNotifier(path string) (*changeNotifier, error) {
+	previous, err := pathSignature(path)
+	if err != nil {
+		return nil, err
+	}
+	n := &changeNotifier{
+		C:    make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C:
+				signature, _ := pathSignature(path)
+				if signature == previous {
+					continue
+				}
+				previous = signature
+				select {
+				case n.C <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return n, nil
+}
+
+// pathSignature describes path so that a change gives another signature.
+func pathSignature(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	signature := fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())
+	if !fi.IsDir() {
+		return signature, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		signature += fmt.Sprintf("\n%s %d %d", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return signature, nil
+}
+
+func (n *changeNotifier) close() {
+	close(n.stop)
+}
@@ -0,0 +1,155 @@
+package main
+
+// Failed (and recovered) runs can be posted as cards to Microsoft Teams
+// (-teams-webhook) and Google Chat (-google-chat-webhook) incoming webhooks. The card shows the
+// job, command, schedule, exit code and duration followed by the tail of the
+// output.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	teamsWebhookFlag      string        = "teams-webhook"
+	googleChatWebhookFlag string        = "google-chat-webhook"
+	webhookTimeout        time.Duration = 10 * time.Second
+	// webhookOutputLines is the number of output lines shown in a card.
+	webhookOutputLines int = 20
+)
+
+// webhookNotifier posts the JSON returned by card for every alerted run.
+type webhookNotifier struct {
+	kind   string
+	url    string
+	card   func(r runResult) any
+	client *http.Client
+}
+
+func newTeamsNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{kind: "Teams", url: url, card: teamsCard, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func newGoogleChatNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{kind: "Google Chat", url: url, card: googleChatCard, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (w *webhookNotifier) name() string {
+	return w.kind
+}
+
+func (w *webhookNotifier) notify(r runResult) error {
+	if !r.alert() {
+		return nil
+	}
+	data, err := json.Marshal(w.card(r))
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json; charset=UTF-8", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// cardFacts returns the label/value pairs shown in a card about r.
+func cardFacts(r runResult) [][2]string {
+	facts := [][2]string{
+		{"Command", r.job.displayCommand()},
+		{"Schedule", r.job.spec()},
+		{"Started", r.started.Format(time.RFC3339)},
+		{"Duration", r.duration.Round(time.Millisecond).String()},
+		{"Exit code", fmt.Sprint(r.exitCode)},
+	}
+	if r.err != nil {
+		facts = append(facts, [2]string{"Error", r.err.Error()})
+	}
+	if len(r.outputFile) > 0 && r.lines > webhookOutputLines {
+		hostname, _ := os.Hostname()
+		facts = append(facts, [2]string{"Full output", fmt.Sprintf("%s:%s (%d lines)", hostname, r.outputFile, r.lines)})
+	}
+	return facts
+}
+
+// outputTailLines returns the last n lines of r's output.
+func outputTailLines(r runResult, n int) string {
+	lines := strings.Split(strings.TrimRight(string(r.output), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// teamsCard returns an Adaptive Card message for a Teams incoming webhook.
+func teamsCard(r runResult) any {
+	facts := make([]map[string]string, 0)
+	for _, f := range cardFacts(r) {
+		facts = append(facts, map[string]string{"title": f[0], "value": f[1]})
+	}
+	color := "Attention"
+	if r.recovered {
+		color = "Good"
+	}
+	body := []map[string]any{
+		{"type": "TextBlock", "text": r.title(), "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+		{"type": "FactSet", "facts": facts},
+	}
+	if output := outputTailLines(r, webhookOutputLines); len(output) > 0 {
+		body = append(body, map[string]any{"type": "TextBlock", "text": output, "fontType": "Monospace", "wrap": true, "separator": true})
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// googleChatCard returns a cardsV2 message for a Google Chat incoming
+// webhook.
+func googleChatCard(r runResult) any {
+	widgets := make([]map[string]any, 0)
+	for _, f := range cardFacts(r) {
+		widgets = append(widgets, map[string]any{
+			"decoratedText": map[string]any{"topLabel": f[0], "text": html.EscapeString(f[1]), "wrapText": true},
+		})
+	}
+	sections := []map[string]any{{"widgets": widgets}}
+	if output := outputTailLines(r, webhookOutputLines); len(output) > 0 {
+		sections = append(sections, map[string]any{
+			"header": "Output",
+			"widgets": []map[string]any{{
+				"textParagraph": map[string]any{"text": strings.ReplaceAll(html.EscapeString(output), "\n", "<br>")},
+			}},
+		})
+	}
+	return map[string]any{
+		"text": r.title(),
+		"cardsV2": []map[string]any{{
+			"cardId": "cronolize",
+			"card": map[string]any{
+				"header":   map[string]any{"title": r.title()},
+				"sections": sections,
+			},
+		}},
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// With -not-before and -until, jobs only fire within a window of time, e.g. a
+// migration job that should stop firing at the end of the year. Both are
+// dates (midnight local time) or RFC 3339 times, the window includes
+// -not-before but not -until. The daemon exits once -until has passed, as if
+// stopped by `cronolize stop`.
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	notBeforeFlag string = "not-before"
+	untilFlag     string = "until"
+)
+
+// windowParser parses the schedules of parser limited to the window from
+// notBefore until until, either of which may be zero.
+type windowParser struct {
+	parser    cron.ScheduleParser
+	notBefore time.Time
+	until     time.Time
+}
+
+func (p windowParser) Parse(spec string) (cron.Schedule, error) {
+	schedule, err := p.parser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return windowSchedule{schedule: schedule, notBefore: p.notBefore, until: p.until}, nil
+}
+
+// windowSchedule runs at the times of schedule from notBefore until until.
+type windowSchedule struct {
+	schedule  cron.Schedule
+	notBefore time.Time
+	until     time.Time
+}
+
+func (s windowSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.notBefore) {
+		// Next returns a time after t, notBefore itself is included.
+		t = s.notBefore.Add(-time.Nanosecond)
+	}
+	next := s.schedule.Next(t)
+	if !s.until.IsZero() && !next.Before(s.until) {
+		return time.Time{}
+	}
+	return next
+}
@@ -3,6 +3,7 @@ module github.com/sa6mwa/cronolizer
 go 1.19
 
 require (
-	github.com/robfig/cron v1.2.0
 	github.com/robfig/cron/v3 v3.0.1
+	github.com/tetratelabs/wazero v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,120 @@
+package cronolizer
+
+// A background process is the running program started again by itself, the
+// way the cronolize command daemonizes. Its command line only has the program
+// name, the arguments (whose command may well have a password or token in it)
+// are written to a pipe it reads them from on startup, so they are not shown
+// to every user of the host by ps. The program is started by the absolute
+// path it was started from, not by looking os.Args[0] up in PATH again.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	// argsFDEnvVar is set to the file descriptor of the pipe with the
+	// arguments in the environment of the background process.
+	argsFDEnvVar string = "__CRONOLIZER_ARGS_FD__"
+	// daemonEnvVar is set to daemonEnvValue in the environment of the
+	// process started by Daemonize.
+	daemonEnvVar   string = "__CRONOLIZER__"
+	daemonEnvValue string = "INSTANTIATED"
+)
+
+// executable is the path of the running program, resolved on startup as on
+// some systems it can not be once the binary has been replaced by a new
+// version.
+var executable = resolveExecutable()
+
+// resolveExecutable returns the path of the running program, falling back to
+// os.Args[0].
+func resolveExecutable() string {
+	path, err := os.Executable()
+	if err != nil && len(os.Args) > 0 {
+		return os.Args[0]
+	}
+	return path
+}
+
+// StartBackground starts the running program again with args (without the
+// program name) passed on a pipe, env added to its environment and
+// extraFiles from file descriptor 3. The new process gets the arguments back
+// using ReadBackgroundArgs. The process is not waited for.
+func StartBackground(args, env []string, extraFiles []*os.File) (*exec.Cmd, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	cmd := exec.Command(executable)
+	cmd.Args = []string{os.Args[0]}
+	cmd.Env = append(append(os.Environ(), env...), argsFDEnvVar+"="+strconv.Itoa(3+len(extraFiles)))
+	cmd.ExtraFiles = append(extraFiles, r)
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(w).Encode(args); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("passing the arguments to the background process: %w", err)
+	}
+	return cmd, nil
+}
+
+// ReadBackgroundArgs replaces the arguments in os.Args by the ones passed on
+// the pipe by StartBackground, if this process was started by it. It should
+// be called first thing in main.
+func ReadBackgroundArgs() error {
+	fdString, ok := os.LookupEnv(argsFDEnvVar)
+	if !ok {
+		return nil
+	}
+	os.Unsetenv(argsFDEnvVar)
+	fd, err := strconv.Atoi(fdString)
+	if err != nil || fd < 3 {
+		return fmt.Errorf("%s: invalid file descriptor %q", argsFDEnvVar, fdString)
+	}
+	f := os.NewFile(uintptr(fd), "args")
+	defer f.Close()
+	var args []string
+	if err := json.NewDecoder(f).Decode(&args); err != nil {
+		return fmt.Errorf("reading the arguments: %w", err)
+	}
+	os.Args = append(os.Args[:1], args...)
+	return nil
+}
+
+// Daemonize starts the running program again in the background with the same
+// arguments and returns its PID, after which the caller should exit. In the
+// background process it returns 0 with the arguments restored in os.Args and
+// the program carries on, e.g. to start its jobs and block:
+//
+//	pid, err := cronolizer.Daemonize()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if pid > 0 {
+//		fmt.Println("Running in the background as PID", pid)
+//		return
+//	}
+//	cronolizer.New("@hourly", "sync.sh").Start()
+//	select {}
+func Daemonize() (int, error) {
+	if os.Getenv(daemonEnvVar) == daemonEnvValue {
+		os.Unsetenv(daemonEnvVar)
+		return 0, ReadBackgroundArgs()
+	}
+	cmd, err := StartBackground(os.Args[1:], []string{daemonEnvVar + "=" + daemonEnvValue}, nil)
+	if err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
@@ -0,0 +1,198 @@
+// Package cronolizer is the library behind the cronolize command. The
+// Scheduler runs functions on cron.Schedules (as parsed by
+// github.com/robfig/cron/v3) using an injectable Clock, so embedders and
+// tests can drive it with a FakeClock instead of real sleeps. A Job runs a
+// shell command on a schedule using the Process, timeout, Retries and
+// OverlapPolicy the cronolize command runs its jobs with, and Daemonize moves
+// the running program to the background the way the command does.
+package cronolizer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used by the Scheduler.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer that sends the current time on its channel
+	// after at least duration d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of *time.Timer used by the Scheduler.
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing, it returns false if the timer
+	// has already fired or been stopped.
+	Stop() bool
+}
+
+// RealClock returns a Clock backed by the time package.
+func RealClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// FakeClock is a Clock that only moves when told to. Timers fire when the
+// clock is advanced (or set) to or past their deadline.
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{
+		clock: c,
+		when:  c.now.Add(d),
+		c:     make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, firing all timers due on the way in
+// deadline order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t (which may be in the past, as a wall clock may be
+// stepped backwards) and fires all timers due at t.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.when.After(t) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- t
+	}
+	for i := len(pending); i < len(c.timers); i++ {
+		c.timers[i] = nil
+	}
+	c.timers = pending
+	c.cond.Broadcast()
+}
+
+// Next returns the deadline of the earliest pending timer, false if there is
+// none. Advancing the clock to Next fires at least one timer.
+func (c *FakeClock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next time.Time
+	for _, timer := range c.timers {
+		if next.IsZero() || timer.when.Before(next) {
+			next = timer.when
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// BlockUntil blocks until at least n timers are pending, useful to wait for
+// the Scheduler to go to sleep before advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			t.clock.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// NewScaledClock returns a Clock starting at start that runs speed times
+// faster than real time, e.g. a speed of 3600 makes an hour pass every
+// second. It is used to simulate days of scheduling in seconds.
+func NewScaledClock(start time.Time, speed float64) Clock {
+	return &scaledClock{
+		start:  start,
+		origin: time.Now(),
+		speed:  speed,
+	}
+}
+
+type scaledClock struct {
+	start  time.Time
+	origin time.Time
+	speed  float64
+}
+
+func (c *scaledClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.origin)) * c.speed))
+}
+
+func (c *scaledClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(time.Duration(float64(d) / c.speed))}
+}
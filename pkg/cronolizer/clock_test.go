@@ -0,0 +1,78 @@
+package cronolizer
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(timer Timer) bool {
+	select {
+	case <-timer.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	first := clock.NewTimer(time.Minute)
+	second := clock.NewTimer(2 * time.Minute)
+	third := clock.NewTimer(3 * time.Minute)
+	if next, ok := clock.Next(); !ok || !next.Equal(testStart.Add(time.Minute)) {
+		t.Errorf("Next() = %v, %v, want %v", next, ok, testStart.Add(time.Minute))
+	}
+
+	clock.Advance(90 * time.Second)
+	if !fired(first) || fired(second) || fired(third) {
+		t.Error("advancing past the first deadline did not fire only the first timer")
+	}
+	if first.Stop() {
+		t.Error("stopping a fired timer returned true")
+	}
+	if !second.Stop() {
+		t.Error("stopping a pending timer returned false")
+	}
+
+	// A wall clock stepped backwards fires nothing.
+	clock.Set(testStart)
+	if fired(third) {
+		t.Error("setting the clock back fired a timer")
+	}
+	if now := clock.Now(); !now.Equal(testStart) {
+		t.Errorf("Now() = %v, want %v", now, testStart)
+	}
+
+	clock.Advance(time.Hour)
+	if fired(second) || !fired(third) {
+		t.Error("advancing fired a stopped timer or not the pending one")
+	}
+	if _, ok := clock.Next(); ok {
+		t.Error("timers pending after all fired")
+	}
+
+	if !fired(clock.NewTimer(0)) {
+		t.Error("a timer with no duration did not fire right away")
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	done := make(chan struct{})
+	go func() {
+		clock.BlockUntil(2)
+		close(done)
+	}()
+	clock.NewTimer(time.Minute)
+	select {
+	case <-done:
+		t.Fatal("BlockUntil(2) returned with one timer pending")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.NewTimer(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BlockUntil(2) did not return with two timers pending")
+	}
+}
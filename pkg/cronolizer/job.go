@@ -0,0 +1,294 @@
+package cronolizer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShell       string = "/bin/sh"
+	defaultShellOption string = "-c"
+)
+
+// Job is a shell command run on a cron schedule:
+//
+//	err := cronolizer.New("*/5 * * * *", "backup.sh").Start()
+//
+// The command is run the way the cronolize command runs it, in a process
+// group of its own, killed once its timeout (see WithTimeout) has passed,
+// retried if it failed (see WithRetries) and overlapping the previous run
+// according to its OverlapPolicy (see WithOverlap). Its output is passed
+// through and the outcome of every attempt handed to OnRun, the logging,
+// jitter and notifications of the command are not part of a Job.
+type Job struct {
+	mu          sync.Mutex
+	spec        string
+	command     string
+	shell       string
+	shellOption string
+	env         []string
+	stdout      io.Writer
+	stderr      io.Writer
+	scheduler   *Scheduler
+	timeout     time.Duration
+	retries     Retries
+	overlap     OverlapPolicy
+	// running is entered by a run with an overlap policy other than allow
+	// until it has finished.
+	running Overlap
+	// procs are the processes of the runs in progress, true once killed
+	// by the overlap policy.
+	procsMu sync.Mutex
+	procs   map[*Process]bool
+	// shared is true if the scheduler was given with WithScheduler, it is
+	// then started and stopped by its owner.
+	shared  bool
+	onRun   func(Run)
+	started bool
+	id      EntryID
+}
+
+// Run is the outcome of an attempt of a run of a Job.
+type Run struct {
+	Started  time.Time
+	Duration time.Duration
+	// Attempt counts the retries of the run, 0 for the first attempt.
+	Attempt int
+	// ExitCode is the exit status of the command, -1 if it could not be
+	// started or was killed by a signal.
+	ExitCode int
+	// Err is nil if the command exited 0, a *TimeoutError if it was killed
+	// by its timeout.
+	Err error
+	// Cancelled is true if the run was killed by the kill overlap policy
+	// and Skipped if it was not run by the skip overlap policy.
+	Cancelled bool
+	Skipped   bool
+}
+
+// JobOption configures a Job.
+type JobOption func(*Job)
+
+// WithShell runs the command using shell with option (e.g. /bin/bash and
+// -c). The default is $SHELL or /bin/sh with -c.
+func WithShell(shell, option string) JobOption {
+	return func(j *Job) {
+		j.shell = shell
+		j.shellOption = option
+	}
+}
+
+// WithEnv adds KEY=VALUE pairs to the environment of the command.
+func WithEnv(env ...string) JobOption {
+	return func(j *Job) {
+		j.env = append(j.env, env...)
+	}
+}
+
+// WithOutput sets where the output of the command goes, os.Stdout and
+// os.Stderr by default. Either may be nil to discard it.
+func WithOutput(stdout, stderr io.Writer) JobOption {
+	return func(j *Job) {
+		j.stdout = stdout
+		j.stderr = stderr
+	}
+}
+
+// WithScheduler schedules the job on s, shared with other jobs (or
+// functions), instead of on a Scheduler of its own. Starting or stopping the
+// job then only adds it to or removes it from s, s is started and stopped by
+// the caller.
+func WithScheduler(s *Scheduler) JobOption {
+	return func(j *Job) {
+		j.scheduler = s
+		j.shared = true
+	}
+}
+
+// WithTimeout kills a run taking longer than d: the command and everything it
+// started get SIGTERM, and SIGKILL TimeoutGrace later if they are still
+// around.
+func WithTimeout(d time.Duration) JobOption {
+	return func(j *Job) {
+		j.timeout = d
+	}
+}
+
+// WithRetries runs a failed run again up to max times, after backoff doubled
+// for every retry, see Retries.
+func WithRetries(max int, backoff time.Duration) JobOption {
+	return func(j *Job) {
+		j.retries = Retries{Max: max, Backoff: backoff}
+	}
+}
+
+// WithOverlap sets what happens when the job comes due while a run of it has
+// not finished, OverlapAllow by default. Retries of a failed run belong to the
+// run, a new run overlaps them as it would the run itself.
+func WithOverlap(policy OverlapPolicy) JobOption {
+	return func(j *Job) {
+		j.overlap = policy
+	}
+}
+
+// OnRun calls fn after every attempt of a run of the job, and for every run
+// skipped by the overlap policy.
+func OnRun(fn func(Run)) JobOption {
+	return func(j *Job) {
+		j.onRun = fn
+	}
+}
+
+// New returns a stopped Job running command according to spec, a cron
+// expression parsed by the parser of its Scheduler (see WithParser).
+func New(spec, command string, opts ...JobOption) *Job {
+	j := &Job{
+		spec:        spec,
+		command:     command,
+		shell:       os.Getenv("SHELL"),
+		shellOption: defaultShellOption,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		overlap:     OverlapAllow,
+		procs:       make(map[*Process]bool),
+	}
+	if len(j.shell) == 0 {
+		j.shell = defaultShell
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.scheduler == nil {
+		j.scheduler = NewScheduler()
+	}
+	return j
+}
+
+// Start schedules the job, it returns an error if the spec can not be parsed
+// or the job has already been started.
+func (j *Job) Start() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.started {
+		return errors.New("job already started")
+	}
+	id, err := j.scheduler.AddFunc(j.spec, j.fire)
+	if err != nil {
+		return err
+	}
+	j.id = id
+	j.started = true
+	if !j.shared {
+		j.scheduler.Start()
+	}
+	return nil
+}
+
+// Stop unschedules the job, runs in progress are not stopped.
+func (j *Job) Stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.started {
+		return
+	}
+	j.started = false
+	if !j.shared {
+		j.scheduler.Stop()
+	}
+	j.scheduler.Remove(j.id)
+}
+
+// Next returns the time of the next run, the zero time if the job is not
+// started.
+func (j *Job) Next() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.started {
+		return time.Time{}
+	}
+	return j.scheduler.Entry(j.id).Next
+}
+
+// fire runs the job when it comes due, according to its overlap policy.
+func (j *Job) fire() {
+	if j.overlap != OverlapAllow {
+		if !j.running.TryEnter() {
+			switch j.overlap {
+			case OverlapSkip:
+				j.report(Run{Started: j.scheduler.Clock().Now(), ExitCode: -1, Skipped: true})
+				return
+			case OverlapKill:
+				j.kill()
+			}
+			j.running.Wait()
+		}
+		defer j.running.Exit()
+	}
+	j.RunNow()
+}
+
+// RunNow runs the command right away and waits for it to exit, retrying it
+// if it fails, without regard to runs already in progress. It returns the
+// outcome of the last attempt.
+func (j *Job) RunNow() Run {
+	for attempt := 0; ; attempt++ {
+		run := j.attempt(attempt)
+		j.report(run)
+		delay, retry := j.retries.Delay(attempt, run.Err, run.Cancelled)
+		if !retry {
+			return run
+		}
+		<-j.scheduler.Clock().NewTimer(delay).C()
+	}
+}
+
+// attempt runs the command once.
+func (j *Job) attempt(attempt int) Run {
+	cmd := exec.Command(j.shell, j.shellOption, j.command)
+	if len(j.env) > 0 {
+		cmd.Env = append(os.Environ(), j.env...)
+	}
+	cmd.Stdout = j.stdout
+	cmd.Stderr = j.stderr
+	run := Run{Started: j.scheduler.Clock().Now(), Attempt: attempt, ExitCode: -1}
+	p, err := StartProcess(cmd, nil)
+	if err != nil {
+		run.Err = err
+		return run
+	}
+	j.procsMu.Lock()
+	j.procs[p] = false
+	j.procsMu.Unlock()
+	if j.timeout > 0 {
+		p.Timeout(j.timeout, nil)
+	}
+	run.Err = p.Wait()
+	run.Duration = j.scheduler.Clock().Now().Sub(run.Started)
+	run.ExitCode = cmd.ProcessState.ExitCode()
+	j.procsMu.Lock()
+	run.Cancelled = j.procs[p]
+	delete(j.procs, p)
+	j.procsMu.Unlock()
+	return run
+}
+
+// kill terminates the runs in progress.
+func (j *Job) kill() {
+	j.procsMu.Lock()
+	defer j.procsMu.Unlock()
+	for p := range j.procs {
+		p.terminate()
+		j.procs[p] = true
+	}
+}
+
+// report hands run to OnRun.
+func (j *Job) report(run Run) {
+	if j.onRun != nil {
+		j.onRun(run)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build !windows
+
+package cronolizer
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runRecorder collects the runs handed to OnRun.
+type runRecorder struct {
+	mu   sync.Mutex
+	runs []Run
+}
+
+func (r *runRecorder) record(run Run) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, run)
+}
+
+func (r *runRecorder) get() []Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Run(nil), r.runs...)
+}
+
+// waitForRun waits for j to have a run in progress.
+func waitForRun(t *testing.T, j *Job) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		j.procsMu.Lock()
+		n := len(j.procs)
+		j.procsMu.Unlock()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the job was not running within 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobRetries(t *testing.T) {
+	var rec runRecorder
+	j := New("@hourly", "exit 3", WithShell("/bin/sh", "-c"), WithRetries(2, time.Millisecond), OnRun(rec.record))
+	run := j.RunNow()
+	if run.Attempt != 2 || run.ExitCode != 3 || run.Err == nil {
+		t.Errorf("RunNow() = %+v, want attempt 2 exiting 3", run)
+	}
+	runs := rec.get()
+	if len(runs) != 3 {
+		t.Fatalf("OnRun got %d attempts, want 3", len(runs))
+	}
+	for i, run := range runs {
+		if run.Attempt != i {
+			t.Errorf("attempt %d: Attempt = %d", i, run.Attempt)
+		}
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	j := New("@hourly", "sleep 60", WithShell("/bin/sh", "-c"), WithTimeout(50*time.Millisecond), WithRetries(1, time.Millisecond))
+	start := time.Now()
+	run := j.RunNow()
+	var timeoutErr *TimeoutError
+	if !errors.As(run.Err, &timeoutErr) {
+		t.Errorf("RunNow() = %v, want a *TimeoutError", run.Err)
+	}
+	// A run that timed out is retried.
+	if run.Attempt != 1 {
+		t.Errorf("RunNow() ended on attempt %d, want 1", run.Attempt)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunNow() took %s", elapsed)
+	}
+}
+
+func TestJobOverlap(t *testing.T) {
+	for _, tc := range []struct {
+		policy OverlapPolicy
+		// want are the runs in the order they were reported, the first
+		// run sleeps until killed or its timeout, the second exits
+		// right away.
+		want []Run
+	}{
+		{OverlapSkip, []Run{{Skipped: true, ExitCode: -1}, {ExitCode: -1}}},
+		{OverlapQueue, []Run{{ExitCode: -1}, {}}},
+		{OverlapKill, []Run{{Cancelled: true, ExitCode: -1}, {}}},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			var rec runRecorder
+			marker := filepath.Join(t.TempDir(), "started")
+			j := New("@hourly", "[ -e "+marker+" ] || { touch "+marker+"; exec sleep 60; }",
+				WithShell("/bin/sh", "-c"), WithOverlap(tc.policy), WithTimeout(time.Second), OnRun(rec.record))
+			first := make(chan struct{})
+			go func() {
+				defer close(first)
+				j.fire()
+			}()
+			waitForRun(t, j)
+			j.fire()
+			<-first
+			runs := rec.get()
+			if len(runs) != len(tc.want) {
+				t.Fatalf("got %d runs, want %d", len(runs), len(tc.want))
+			}
+			for i, run := range runs {
+				want := tc.want[i]
+				if run.Skipped != want.Skipped || run.Cancelled != want.Cancelled || run.ExitCode != want.ExitCode {
+					t.Errorf("run %d = %+v, want %+v", i, run, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package cronolizer
+
+// An OverlapPolicy decides what happens when a job fires while a run of it
+// has not finished yet:
+//
+//	allow  start another run next to it (the default)
+//	skip   skip the new run
+//	queue  start the new run when the previous one has finished
+//	kill   kill the previous run and start the new one when it has exited
+//
+// The runs of a job are kept apart by an Overlap of its own.
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OverlapPolicy is allow, skip, queue or kill.
+type OverlapPolicy string
+
+const (
+	OverlapAllow OverlapPolicy = "allow"
+	OverlapSkip  OverlapPolicy = "skip"
+	OverlapQueue OverlapPolicy = "queue"
+	OverlapKill  OverlapPolicy = "kill"
+)
+
+// ParseOverlapPolicy parses allow, skip, queue or kill.
+func ParseOverlapPolicy(s string) (OverlapPolicy, error) {
+	switch p := OverlapPolicy(s); p {
+	case OverlapAllow, OverlapSkip, OverlapQueue, OverlapKill:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q is not allow, skip, queue or kill", s)
+}
+
+// Overlap is held by the run of a job in progress, for the policies other
+// than allow. The zero value is free.
+type Overlap struct {
+	mu sync.Mutex
+}
+
+// TryEnter enters the overlap and returns true if no run of the job is in
+// progress, the new run may start right away. Otherwise it is to be skipped
+// (with skip), or it may start once Wait has returned (with queue, and with
+// kill once the run in progress has been killed).
+func (o *Overlap) TryEnter() bool {
+	return o.mu.TryLock()
+}
+
+// Wait waits for the run in progress to exit the overlap and enters it.
+func (o *Overlap) Wait() {
+	o.mu.Lock()
+}
+
+// Exit is called by a run that entered the overlap once it has finished.
+func (o *Overlap) Exit() {
+	o.mu.Unlock()
+}
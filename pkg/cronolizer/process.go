@@ -0,0 +1,120 @@
+package cronolizer
+
+// A Process is the running command of a run. It leads a process group of its
+// own, so everything the command started is signalled with it, even once the
+// command itself has exited and left its children behind. A Process with a
+// timeout is killed when it has passed: the command and everything it started
+// get SIGTERM, and SIGKILL TimeoutGrace later if they are still around.
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TimeoutGrace is how long a Process that timed out has to exit after SIGTERM
+// before it gets SIGKILL.
+const TimeoutGrace time.Duration = 10 * time.Second
+
+// Process is a started command, see StartProcess.
+type Process struct {
+	cmd *exec.Cmd
+	// Pid is the PID of the command, which is also the ID of its process
+	// group.
+	Pid int
+
+	mu       sync.Mutex
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut bool
+	done     bool
+}
+
+// TimeoutError is the error of a run killed for taking longer than Timeout,
+// Err is the error it exited with.
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %v", e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// StartProcess starts cmd in a process group of its own using start, or
+// cmd.Start if start is nil (e.g. to start it with other scheduling
+// attributes than those of the calling thread).
+func StartProcess(cmd *exec.Cmd, start func(*exec.Cmd) error) (*Process, error) {
+	setProcessGroup(cmd)
+	if start == nil {
+		start = (*exec.Cmd).Start
+	}
+	if err := start(cmd); err != nil {
+		return nil, err
+	}
+	return &Process{cmd: cmd, Pid: cmd.Process.Pid}, nil
+}
+
+// Signal sends sig to the process and everything it started.
+func (p *Process) Signal(sig syscall.Signal) error {
+	return SignalTree(p.Pid, sig)
+}
+
+// Timeout kills the process once d has passed, calling expired right before.
+// It must be called at most once, before Wait.
+func (p *Process) Timeout(d time.Duration, expired func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = d
+	p.timer = time.AfterFunc(d, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.done {
+			return
+		}
+		p.timedOut = true
+		if expired != nil {
+			expired()
+		}
+		p.terminate()
+		p.timer = time.AfterFunc(TimeoutGrace, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if !p.done {
+				p.Signal(syscall.SIGKILL)
+			}
+		})
+	})
+}
+
+// Wait waits for the command to exit and its output to be copied, the error
+// is a *TimeoutError if it was killed by its timeout.
+func (p *Process) Wait() error {
+	err := p.cmd.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.timedOut {
+		return &TimeoutError{Timeout: p.timeout, Err: err}
+	}
+	return err
+}
+
+// ProcessTree is the sum of the resources used by a process and its
+// descendants, see ReadProcessTree.
+type ProcessTree struct {
+	PIDs []int
+	// CPUTime is the user and system time used (including by reaped
+	// children) and RSS the resident memory in bytes.
+	CPUTime time.Duration
+	RSS     int64
+}
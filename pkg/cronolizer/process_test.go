@@ -0,0 +1,155 @@
+//go:build linux
+
+package cronolizer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startOrphaning starts a shell like the command of a run, whose child
+// outlives it when the shell is killed, and returns it and the PID of the
+// child. The child holds on to stderr, so Wait does not return until it has
+// exited too.
+func startOrphaning(t *testing.T) (*Process, int) {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", "sleep 60 & echo $!; wait; echo done")
+	cmd.Stderr = &bytes.Buffer{}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := StartProcess(cmd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-p.Pid, syscall.SIGKILL)
+		syscall.Kill(child, syscall.SIGKILL)
+	})
+	return p, child
+}
+
+// waitExited waits for p and returns its error once the process child has
+// exited too, failing the test if they don't within a few seconds.
+func waitExited(t *testing.T, p *Process, child int) error {
+	t.Helper()
+	waited := make(chan error, 1)
+	go func() {
+		waited <- p.Wait()
+	}()
+	var err error
+	select {
+	case err = <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not done within 5s, its child is still running")
+	}
+	for deadline := time.Now().Add(5 * time.Second); !exited(child); {
+		if time.Now().After(deadline) {
+			t.Fatalf("the child %d of the command is still running", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// exited returns true if the process pid is gone or a zombie.
+func exited(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}
+	i := bytes.LastIndexByte(data, ')')
+	return i >= 0 && i+2 < len(data) && data[i+2] == 'Z'
+}
+
+// processState returns the state of pid in /proc/PID/stat.
+func processState(t *testing.T, pid int) byte {
+	t.Helper()
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 || i+2 >= len(data) {
+		t.Fatalf("/proc/%d/stat: unexpected format", pid)
+	}
+	return data[i+2]
+}
+
+func TestProcessSignal(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		t.Run(sig.String(), func(t *testing.T) {
+			p, child := startOrphaning(t)
+			if err := p.Signal(sig); err != nil {
+				t.Fatal(err)
+			}
+			waitExited(t, p, child)
+		})
+	}
+}
+
+func TestProcessSignalStop(t *testing.T) {
+	p, child := startOrphaning(t)
+	if err := p.Signal(syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []int{p.Pid, child} {
+		if state := processState(t, pid); state != 'T' {
+			t.Errorf("process %d is in state %c, not stopped", pid, state)
+		}
+	}
+	p.Signal(syscall.SIGCONT)
+	p.Signal(syscall.SIGTERM)
+	waitExited(t, p, child)
+}
+
+func TestProcessTimeout(t *testing.T) {
+	p, child := startOrphaning(t)
+	expired := make(chan struct{})
+	p.Timeout(50*time.Millisecond, func() { close(expired) })
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the process did not time out")
+	}
+	// The child exiting within the grace period shows it got SIGTERM.
+	err := waitExited(t, p, child)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Wait() = %v, want a *TimeoutError", err)
+	}
+	if got, want := timeoutErr.Error(), "timed out after 50ms: signal: terminated"; got != want {
+		t.Errorf("Wait() = %q, want %q", got, want)
+	}
+}
+
+func TestProcessTimeoutStopped(t *testing.T) {
+	p, err := StartProcess(exec.Command("/bin/sh", "-c", "exit 3"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Timeout(50*time.Millisecond, func() { t.Error("the timeout of an exited process expired") })
+	err = p.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("Wait() = %v, want exit status 3", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package cronolizer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd lead a process group of its own once started.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// terminate asks the process and everything it started to exit.
+func (p *Process) terminate() {
+	p.Signal(syscall.SIGTERM)
+	// A stopped process would not get the signal until continued.
+	p.Signal(syscall.SIGCONT)
+}
+
+// SignalTree sends sig to the process group led by pid and to all
+// descendants of pid, including those that have left the group. The
+// descendants are looked up before signalling, a killed process no longer
+// has its children under it. A process may fork before it is stopped, so
+// when stopping the tree is searched again until no new processes are found.
+func SignalTree(pid int, sig syscall.Signal) error {
+	tree, treeErr := ReadProcessTree(pid)
+	if err := syscall.Kill(-pid, sig); err != nil {
+		// pid does not lead a process group.
+		if err := syscall.Kill(pid, sig); err != nil {
+			return err
+		}
+	}
+	if treeErr != nil {
+		// Not supported (or pid has already exited), only the group is
+		// signalled.
+		return nil
+	}
+	signalled := map[int]bool{pid: true}
+	for {
+		found := false
+		for _, p := range tree.PIDs {
+			if !signalled[p] {
+				syscall.Kill(p, sig)
+				signalled[p] = true
+				found = true
+			}
+		}
+		if !found || sig != syscall.SIGSTOP {
+			return nil
+		}
+		if tree, treeErr = ReadProcessTree(pid); treeErr != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package cronolizer
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing, there are no process groups on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminate kills the process, Windows has no SIGTERM to ask it to exit.
+func (p *Process) terminate() {
+	p.Signal(syscall.SIGKILL)
+}
+
+// SignalTree sends sig to pid, only SIGKILL is supported on Windows and the
+// processes it started are not signalled.
+func SignalTree(pid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
@@ -0,0 +1,99 @@
+package cronolizer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the times in /proc/PID/stat, which is
+// 100 on every Linux architecture Go supports.
+const clockTicks = 100
+
+// procStat is the part of /proc/PID/stat used for sampling.
+type procStat struct {
+	ppid  int
+	ticks uint64
+	rss   int64
+}
+
+// readProcStat reads /proc/PID/stat of pid.
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return procStat{}, err
+	}
+	// The command name in parentheses may contain spaces and
+	// parentheses, the fields that follow are counted from the last one.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return procStat{}, fmt.Errorf("/proc/%d/stat: unexpected format", pid)
+	}
+	// fields[0] is field 3 (state) in proc(5).
+	fields := bytes.Fields(data[i+1:])
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("/proc/%d/stat: unexpected format", pid)
+	}
+	var st procStat
+	if st.ppid, err = strconv.Atoi(string(fields[1])); err != nil {
+		return procStat{}, err
+	}
+	// utime, stime, cutime and cstime.
+	for _, field := range fields[11:15] {
+		n, err := strconv.ParseInt(string(field), 10, 64)
+		if err != nil {
+			return procStat{}, err
+		}
+		if n > 0 {
+			st.ticks += uint64(n)
+		}
+	}
+	pages, err := strconv.ParseInt(string(fields[21]), 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	st.rss = pages * int64(os.Getpagesize())
+	return st, nil
+}
+
+// ReadProcessTree sums the resources used by pid and all its descendants.
+func ReadProcessTree(pid int) (ProcessTree, error) {
+	root, err := readProcStat(pid)
+	if err != nil {
+		return ProcessTree{}, err
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return ProcessTree{}, err
+	}
+	children := make(map[int][]int)
+	stats := map[int]procStat{pid: root}
+	for _, entry := range entries {
+		n, err := strconv.Atoi(entry.Name())
+		if err != nil || n == pid {
+			continue
+		}
+		st, err := readProcStat(n)
+		if err != nil {
+			// Processes come and go.
+			continue
+		}
+		stats[n] = st
+		children[st.ppid] = append(children[st.ppid], n)
+	}
+	var tree ProcessTree
+	var ticks uint64
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		tree.PIDs = append(tree.PIDs, p)
+		ticks += stats[p].ticks
+		tree.RSS += stats[p].rss
+		queue = append(queue, children[p]...)
+	}
+	tree.CPUTime = time.Duration(ticks) * time.Second / clockTicks
+	return tree, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cronolizer
+
+import "errors"
+
+// ReadProcessTree is only supported on Linux.
+func ReadProcessTree(pid int) (ProcessTree, error) {
+	return ProcessTree{}, errors.New("reading the process tree is only supported on Linux")
+}
@@ -0,0 +1,34 @@
+package cronolizer
+
+// A failed run is run again up to Retries.Max times before it counts as
+// failed, after Retries.Backoff doubled for every retry (at most
+// MaxRetryDelay). Runs that were killed on purpose are not retried, runs that
+// timed out are.
+
+import "time"
+
+// MaxRetryDelay caps the doubling backoff of Retries.
+const MaxRetryDelay time.Duration = time.Hour
+
+// Retries is how often and when a failed run is run again.
+type Retries struct {
+	Max     int
+	Backoff time.Duration
+}
+
+// Delay returns how long to wait before retrying attempt (counted from 0) of
+// a run that ended with err, or false if it is not retried: it succeeded, was
+// cancelled (killed on purpose) or has no retries left.
+func (r Retries) Delay(attempt int, err error, cancelled bool) (time.Duration, bool) {
+	if err == nil || cancelled || attempt >= r.Max {
+		return 0, false
+	}
+	delay := r.Backoff
+	for i := 0; i < attempt && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryDelay {
+		delay = MaxRetryDelay
+	}
+	return delay, true
+}
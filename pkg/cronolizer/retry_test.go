@@ -0,0 +1,36 @@
+package cronolizer
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetriesDelay(t *testing.T) {
+	failed := errors.New("exit status 1")
+	retries := Retries{Max: 3, Backoff: 30 * time.Second}
+	for _, tc := range []struct {
+		name      string
+		retries   Retries
+		attempt   int
+		err       error
+		cancelled bool
+		delay     time.Duration
+		retry     bool
+	}{
+		{"succeeded", retries, 0, nil, false, 0, false},
+		{"cancelled", retries, 0, failed, true, 0, false},
+		{"first retry", retries, 0, failed, false, 30 * time.Second, true},
+		{"doubled", retries, 2, failed, false, 2 * time.Minute, true},
+		{"no retries left", retries, 3, failed, false, 0, false},
+		{"capped", Retries{Max: 10, Backoff: 20 * time.Minute}, 5, failed, false, MaxRetryDelay, true},
+		{"no retries", Retries{}, 0, failed, false, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			delay, retry := tc.retries.Delay(tc.attempt, tc.err, tc.cancelled)
+			if delay != tc.delay || retry != tc.retry {
+				t.Errorf("Delay(%d) = %s, %t, want %s, %t", tc.attempt, delay, retry, tc.delay, tc.retry)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package cronolizer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// EntryID identifies an entry in a Scheduler.
+type EntryID int
+
+// Entry is a snapshot of a scheduled function.
+type Entry struct {
+	ID       EntryID
+	Schedule cron.Schedule
+	// Next is the next time the entry will run, zero until the Scheduler
+	// has been started.
+	Next time.Time
+	// Prev is the last time the entry was run, zero if never.
+	Prev time.Time
+	Job  func()
+}
+
+// Option configures a Scheduler.
+type Option func(*Scheduler)
+
+// WithClock makes the Scheduler use clock instead of the real time.
+func WithClock(clock Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+// WithLocation sets the time zone schedules are interpreted in (unless a
+// spec overrides it using CRON_TZ=), the default is time.Local.
+func WithLocation(loc *time.Location) Option {
+	return func(s *Scheduler) {
+		s.location = loc
+	}
+}
+
+// WithParser sets the parser used by AddFunc, the default is
+// cron.ParseStandard (five fields and descriptors such as @daily).
+func WithParser(parser cron.ScheduleParser) Option {
+	return func(s *Scheduler) {
+		s.parser = parser
+	}
+}
+
+// WithJumpDetection makes the Scheduler check at least every interval how
+// much further the wall clock has moved than the monotonic clock, e.g.
+// because the clock was stepped or the system was suspended (the monotonic
+// clock stops during a suspend). If the difference is more than threshold in
+// either direction, fn is called with it before anything is run, so fn can
+// decide what to do about the runs that came due (see Wake and SkipMissed).
+// Clocks whose times have no monotonic clock reading, such as a FakeClock,
+// never jump.
+func WithJumpDetection(interval, threshold time.Duration, fn func(jump time.Duration)) Option {
+	return func(s *Scheduler) {
+		s.jumpInterval = interval
+		s.jumpThreshold = threshold
+		s.onJump = fn
+	}
+}
+
+// Scheduler runs functions according to their schedules. Each run is started
+// in a goroutine of its own. Entries due at the same time are started in the
+// order they were added.
+type Scheduler struct {
+	mu       sync.Mutex
+	clock    Clock
+	location *time.Location
+	parser   cron.ScheduleParser
+	entries  []*Entry
+	lastID   EntryID
+	running  bool
+	wake     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	jumpInterval  time.Duration
+	jumpThreshold time.Duration
+	onJump        func(jump time.Duration)
+	// elapsed returns the time from from to to on the monotonic clock,
+	// tests replace it to simulate a jump of a FakeClock.
+	elapsed func(from, to time.Time) time.Duration
+}
+
+// NewScheduler returns a stopped Scheduler.
+func NewScheduler(opts ...Option) *Scheduler {
+	s := &Scheduler{
+		clock:    RealClock(),
+		location: time.Local,
+		parser:   standardParser{},
+		wake:     make(chan struct{}, 1),
+		elapsed: func(from, to time.Time) time.Duration {
+			return to.Sub(from)
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type standardParser struct{}
+
+func (standardParser) Parse(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// AddFunc parses spec and schedules fn.
+func (s *Scheduler) AddFunc(spec string, fn func()) (EntryID, error) {
+	schedule, err := s.parser.Parse(spec)
+	if err != nil {
+		return 0, err
+	}
+	return s.Schedule(schedule, fn), nil
+}
+
+// Schedule schedules fn to run according to schedule.
+func (s *Scheduler) Schedule(schedule cron.Schedule, fn func()) EntryID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	entry := &Entry{
+		ID:       s.lastID,
+		Schedule: schedule,
+		Job:      fn,
+	}
+	if s.running {
+		entry.Next = schedule.Next(s.now())
+	}
+	s.entries = append(s.entries, entry)
+	s.notify()
+	return entry.ID
+}
+
+// Reschedule parses spec and replaces the schedule of entry id. If the
+// Scheduler is running, the next run is computed anew from now.
+func (s *Scheduler) Reschedule(id EntryID, spec string) error {
+	schedule, err := s.parser.Parse(spec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.ID == id {
+			e.Schedule = schedule
+			if s.running {
+				e.Next = schedule.Next(s.now())
+			}
+			s.notify()
+			return nil
+		}
+	}
+	return fmt.Errorf("no entry with ID %d", id)
+}
+
+// Remove unschedules entry id.
+func (s *Scheduler) Remove(id EntryID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.ID == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	s.notify()
+}
+
+// Entry returns a snapshot of entry id, the zero Entry if not found.
+func (s *Scheduler) Entry(id EntryID) Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.ID == id {
+			return *e
+		}
+	}
+	return Entry{}
+}
+
+// Entries returns a snapshot of all entries in the order they were added.
+func (s *Scheduler) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// Location returns the time zone schedules are interpreted in.
+func (s *Scheduler) Location() *time.Location {
+	return s.location
+}
+
+// Clock returns the Clock used by the Scheduler.
+func (s *Scheduler) Clock() Clock {
+	return s.clock
+}
+
+// Start computes the next run of all entries and starts the scheduler in a
+// goroutine of its own. Starting a running Scheduler does nothing.
+func (s *Scheduler) Start() {
+	s.StartAt(nil)
+}
+
+// StartAt is like Start, but the entries in next are first run at the given
+// time instead of the next time of their schedule, immediately if it has
+// passed. It is used to take over entries from a stopped Scheduler (see
+// Entries) without missing or repeating a run.
+func (s *Scheduler) StartAt(next map[EntryID]time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	now := s.now()
+	for _, e := range s.entries {
+		if t, ok := next[e.ID]; ok && !t.IsZero() {
+			e.Next = t
+		} else {
+			e.Next = e.Schedule.Next(now)
+		}
+	}
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go s.run(s.stop, s.stopped)
+}
+
+// Stop stops the scheduler, runs already started are not waited for.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	close(s.stop)
+	stopped := s.stopped
+	s.mu.Unlock()
+	<-stopped
+}
+
+// Wake makes a running Scheduler recompute when to run next from the current
+// time of its Clock, starting entries whose next run has passed. Timers do
+// not advance while the system is suspended, call Wake after a resume to run
+// what came due during the suspend right away.
+func (s *Scheduler) Wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notify()
+}
+
+// SkipMissed computes the next run of the entries whose next run has passed
+// from the current time without running them, and returns them. It is the
+// alternative to Wake when runs missed during a suspend are not wanted.
+func (s *Scheduler) SkipMissed() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return nil
+	}
+	now := s.now()
+	var skipped []Entry
+	for _, e := range s.entries {
+		if !e.Next.IsZero() && !e.Next.After(now) {
+			skipped = append(skipped, *e)
+			e.Next = e.Schedule.Next(now)
+		}
+	}
+	s.notify()
+	return skipped
+}
+
+func (s *Scheduler) now() time.Time {
+	return s.clock.Now().In(s.location)
+}
+
+// notify wakes the run loop to recompute when to wake up next, s.mu must be
+// held.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) run(stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	checked := s.clock.Now()
+	for {
+		s.mu.Lock()
+		var next time.Time
+		for _, e := range s.entries {
+			if !e.Next.IsZero() && (next.IsZero() || e.Next.Before(next)) {
+				next = e.Next
+			}
+		}
+		var timer Timer
+		var fired <-chan time.Time
+		if !next.IsZero() || s.onJump != nil {
+			d := next.Sub(s.now())
+			if s.onJump != nil && (next.IsZero() || d > s.jumpInterval) {
+				d = s.jumpInterval
+			}
+			timer = s.clock.NewTimer(d)
+			fired = timer.C()
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-fired:
+		case <-s.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+		if s.onJump != nil {
+			now := s.clock.Now()
+			// Round(0) strips the monotonic clock reading, leaving the
+			// wall clock.
+			jump := now.Round(0).Sub(checked.Round(0)) - s.elapsed(checked, now)
+			checked = now
+			if jump > s.jumpThreshold || jump < -s.jumpThreshold {
+				s.onJump(jump)
+			}
+		}
+		s.runDue()
+	}
+}
+
+// runDue starts every entry due now and computes their next run.
+func (s *Scheduler) runDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	due := make([]*Entry, 0)
+	for _, e := range s.entries {
+		if !e.Next.IsZero() && !e.Next.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+	for _, e := range due {
+		go e.Job()
+		e.Prev = e.Next
+		e.Next = e.Schedule.Next(now)
+	}
+}
@@ -0,0 +1,386 @@
+package cronolizer
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+const runLayout = "Mon Jan 2 15:04"
+
+// testStart is a Monday.
+var testStart = time.Date(2026, time.January, 5, 10, 7, 0, 0, time.UTC)
+
+// testRun is an entry that was run and the time it was due, formatted using
+// runLayout in UTC.
+type testRun struct {
+	id EntryID
+	at string
+}
+
+// runUntil advances clock from one timer of s to the next until the next is
+// after end, and returns the runs started on the way in the order they came
+// due, entries due together in ID order.
+func runUntil(t *testing.T, s *Scheduler, clock *FakeClock, end time.Time) []testRun {
+	t.Helper()
+	var runs []testRun
+	for {
+		// The Scheduler has one timer pending while it waits.
+		clock.BlockUntil(1)
+		next, _ := clock.Next()
+		if next.After(end) {
+			return runs
+		}
+		prev := make(map[EntryID]time.Time)
+		for _, e := range s.Entries() {
+			prev[e.ID] = e.Prev
+		}
+		clock.Set(next)
+		clock.BlockUntil(1)
+		runs = append(runs, newRuns(s, prev)...)
+	}
+}
+
+// newRuns returns the entries of s run since their Prev was prev.
+func newRuns(s *Scheduler, prev map[EntryID]time.Time) []testRun {
+	var runs []testRun
+	for _, e := range s.Entries() {
+		if !e.Prev.Equal(prev[e.ID]) {
+			runs = append(runs, testRun{id: e.ID, at: e.Prev.UTC().Format(runLayout)})
+		}
+	}
+	return runs
+}
+
+// waitForTimer waits for the Scheduler to have set a timer at want after
+// being woken up.
+func waitForTimer(t *testing.T, clock *FakeClock, want time.Time) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if next, ok := clock.Next(); ok && next.Equal(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			next, _ := clock.Next()
+			t.Fatalf("timer at %v, want %v", next, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func equalRuns(a, b []testRun) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScheduler(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		end   time.Time
+		want  []testRun
+	}{
+		{
+			name:  "every 15 minutes",
+			specs: []string{"*/15 * * * *"},
+			end:   time.Date(2026, time.January, 5, 11, 0, 0, 0, time.UTC),
+			want: []testRun{
+				{1, "Mon Jan 5 10:15"},
+				{1, "Mon Jan 5 10:30"},
+				{1, "Mon Jan 5 10:45"},
+				{1, "Mon Jan 5 11:00"},
+			},
+		},
+		{
+			name:  "entries due together in the order added",
+			specs: []string{"0 * * * *", "*/30 * * * *"},
+			end:   time.Date(2026, time.January, 5, 11, 30, 0, 0, time.UTC),
+			want: []testRun{
+				{2, "Mon Jan 5 10:30"},
+				{1, "Mon Jan 5 11:00"},
+				{2, "Mon Jan 5 11:00"},
+				{2, "Mon Jan 5 11:30"},
+			},
+		},
+		{
+			name:  "weekdays only",
+			specs: []string{"0 9 * * 1-5"},
+			end:   time.Date(2026, time.January, 13, 10, 0, 0, 0, time.UTC),
+			want: []testRun{
+				{1, "Tue Jan 6 09:00"},
+				{1, "Wed Jan 7 09:00"},
+				{1, "Thu Jan 8 09:00"},
+				{1, "Fri Jan 9 09:00"},
+				{1, "Mon Jan 12 09:00"},
+				{1, "Tue Jan 13 09:00"},
+			},
+		},
+		{
+			name:  "time zone of the spec",
+			specs: []string{"CRON_TZ=Asia/Tokyo 0 0 * * *", "0 0 * * *"},
+			end:   time.Date(2026, time.January, 7, 0, 0, 0, 0, time.UTC),
+			want: []testRun{
+				{1, "Mon Jan 5 15:00"},
+				{2, "Tue Jan 6 00:00"},
+				{1, "Tue Jan 6 15:00"},
+				{2, "Wed Jan 7 00:00"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(testStart)
+			s := NewScheduler(WithClock(clock), WithLocation(time.UTC))
+			for _, spec := range test.specs {
+				if _, err := s.AddFunc(spec, func() {}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Start()
+			defer s.Stop()
+			if got := runUntil(t, s, clock, test.end); !equalRuns(got, test.want) {
+				t.Errorf("got runs %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerRunsJobs(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	s := NewScheduler(WithClock(clock), WithLocation(time.UTC))
+	ran := make(chan time.Time, 10)
+	if _, err := s.AddFunc("*/5 * * * *", func() { ran <- clock.Now() }); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Minute)
+	select {
+	case at := <-ran:
+		if want := testStart.Add(3 * time.Minute); !at.Equal(want) {
+			t.Errorf("ran at %v, want %v", at, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job not run")
+	}
+}
+
+func TestSchedulerReschedule(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	s := NewScheduler(WithClock(clock), WithLocation(time.UTC))
+	id, err := s.AddFunc("0 * * * *", func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+	got := runUntil(t, s, clock, time.Date(2026, time.January, 5, 11, 0, 0, 0, time.UTC))
+	if err := s.Reschedule(id, "*/20 * * * *"); err != nil {
+		t.Fatal(err)
+	}
+	waitForTimer(t, clock, time.Date(2026, time.January, 5, 11, 20, 0, 0, time.UTC))
+	got = append(got, runUntil(t, s, clock, time.Date(2026, time.January, 5, 12, 0, 0, 0, time.UTC))...)
+	want := []testRun{
+		{id, "Mon Jan 5 11:00"},
+		{id, "Mon Jan 5 11:20"},
+		{id, "Mon Jan 5 11:40"},
+		{id, "Mon Jan 5 12:00"},
+	}
+	if !equalRuns(got, want) {
+		t.Errorf("got runs %v, want %v", got, want)
+	}
+	if err := s.Reschedule(id+1, "* * * * *"); err == nil {
+		t.Error("rescheduling an unknown entry did not fail")
+	}
+	if err := s.Reschedule(id, "not a spec"); err == nil {
+		t.Error("rescheduling using an invalid spec did not fail")
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	s := NewScheduler(WithClock(clock), WithLocation(time.UTC))
+	first, _ := s.AddFunc("*/30 * * * *", func() {})
+	second, _ := s.AddFunc("0 * * * *", func() {})
+	s.Start()
+	defer s.Stop()
+	got := runUntil(t, s, clock, time.Date(2026, time.January, 5, 10, 30, 0, 0, time.UTC))
+	s.Remove(first)
+	waitForTimer(t, clock, time.Date(2026, time.January, 5, 11, 0, 0, 0, time.UTC))
+	got = append(got, runUntil(t, s, clock, time.Date(2026, time.January, 5, 12, 0, 0, 0, time.UTC))...)
+	want := []testRun{
+		{first, "Mon Jan 5 10:30"},
+		{second, "Mon Jan 5 11:00"},
+		{second, "Mon Jan 5 12:00"},
+	}
+	if !equalRuns(got, want) {
+		t.Errorf("got runs %v, want %v", got, want)
+	}
+	if e := s.Entry(first); e.ID != 0 {
+		t.Errorf("removed entry still there: %+v", e)
+	}
+}
+
+func TestSchedulerStartAt(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	s := NewScheduler(WithClock(clock), WithLocation(time.UTC))
+	overdue, _ := s.AddFunc("0 * * * *", func() {})
+	later, _ := s.AddFunc("0 * * * *", func() {})
+	normal, _ := s.AddFunc("0 * * * *", func() {})
+	s.StartAt(map[EntryID]time.Time{
+		overdue: time.Date(2026, time.January, 5, 9, 0, 0, 0, time.UTC),
+		later:   time.Date(2026, time.January, 5, 10, 30, 0, 0, time.UTC),
+	})
+	defer s.Stop()
+	// The overdue entry is run right away, before the first timer is set.
+	clock.BlockUntil(1)
+	got := newRuns(s, nil)
+	got = append(got, runUntil(t, s, clock, time.Date(2026, time.January, 5, 11, 0, 0, 0, time.UTC))...)
+	want := []testRun{
+		{overdue, "Mon Jan 5 09:00"},
+		{later, "Mon Jan 5 10:30"},
+		{overdue, "Mon Jan 5 11:00"},
+		{later, "Mon Jan 5 11:00"},
+		{normal, "Mon Jan 5 11:00"},
+	}
+	if !equalRuns(got, want) {
+		t.Errorf("got runs %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerSkipMissed(t *testing.T) {
+	clock := NewFakeClock(testStart)
+	s := NewScheduler(WithClock(clock), WithLocation(time.UTC))
+	if skipped := s.SkipMissed(); skipped != nil {
+		t.Errorf("stopped Scheduler skipped %v", skipped)
+	}
+	id, _ := s.AddFunc("0 * * * *", func() {})
+	s.Start()
+	defer s.Stop()
+	clock.BlockUntil(1)
+	// Move the clock without firing the timer, like during a suspend.
+	clock.mu.Lock()
+	clock.now = time.Date(2026, time.January, 5, 13, 30, 0, 0, time.UTC)
+	clock.mu.Unlock()
+	skipped := s.SkipMissed()
+	if len(skipped) != 1 || skipped[0].ID != id || !skipped[0].Next.Equal(time.Date(2026, time.January, 5, 11, 0, 0, 0, time.UTC)) {
+		t.Fatalf("skipped %+v, want entry %d due at 11:00", skipped, id)
+	}
+	waitForTimer(t, clock, time.Date(2026, time.January, 5, 14, 0, 0, 0, time.UTC))
+	got := runUntil(t, s, clock, time.Date(2026, time.January, 5, 14, 0, 0, 0, time.UTC))
+	want := []testRun{{id, "Mon Jan 5 14:00"}}
+	if !equalRuns(got, want) {
+		t.Errorf("got runs %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerJumpDetection(t *testing.T) {
+	tests := []struct {
+		name string
+		// step is how far the wall clock is moved from 10:07 while the
+		// monotonic clock moves one minute.
+		step time.Duration
+		skip bool
+		// wantJump is the jump reported, zero if none.
+		wantJump time.Duration
+		want     []testRun
+	}{
+		{
+			name:     "stepped forward runs the missed run",
+			step:     3*time.Hour + 23*time.Minute,
+			wantJump: 3*time.Hour + 22*time.Minute,
+			want: []testRun{
+				{1, "Mon Jan 5 11:00"},
+				{1, "Mon Jan 5 14:00"},
+			},
+		},
+		{
+			name:     "stepped forward skipping the missed runs",
+			step:     3*time.Hour + 23*time.Minute,
+			skip:     true,
+			wantJump: 3*time.Hour + 22*time.Minute,
+			want: []testRun{
+				{1, "Mon Jan 5 14:00"},
+			},
+		},
+		{
+			name:     "stepped backwards",
+			step:     -time.Hour,
+			wantJump: -time.Hour - time.Minute,
+			want: []testRun{
+				{1, "Mon Jan 5 11:00"},
+				{1, "Mon Jan 5 12:00"},
+				{1, "Mon Jan 5 13:00"},
+				{1, "Mon Jan 5 14:00"},
+			},
+		},
+		{
+			name: "drift below the threshold",
+			step: time.Minute + 2*time.Second,
+			want: []testRun{
+				{1, "Mon Jan 5 11:00"},
+				{1, "Mon Jan 5 12:00"},
+				{1, "Mon Jan 5 13:00"},
+				{1, "Mon Jan 5 14:00"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(testStart)
+			var mu sync.Mutex
+			var jumps []time.Duration
+			var s *Scheduler
+			s = NewScheduler(WithClock(clock), WithLocation(time.UTC), WithJumpDetection(time.Minute, 5*time.Second, func(jump time.Duration) {
+				mu.Lock()
+				jumps = append(jumps, jump)
+				mu.Unlock()
+				if test.skip {
+					s.SkipMissed()
+				}
+			}))
+			// The FakeClock has no monotonic clock, the step is
+			// subtracted from the next interval measured.
+			var step time.Duration
+			s.elapsed = func(from, to time.Time) time.Duration {
+				mu.Lock()
+				defer mu.Unlock()
+				d := to.Sub(from) - step
+				step = 0
+				return d
+			}
+			s.AddFunc("0 * * * *", func() {})
+			s.Start()
+			defer s.Stop()
+			clock.BlockUntil(1)
+			mu.Lock()
+			step = test.step - time.Minute
+			mu.Unlock()
+			prev := map[EntryID]time.Time{}
+			clock.Set(testStart.Add(test.step))
+			clock.BlockUntil(1)
+			got := newRuns(s, prev)
+			got = append(got, runUntil(t, s, clock, time.Date(2026, time.January, 5, 14, 0, 0, 0, time.UTC))...)
+			if !equalRuns(got, test.want) {
+				t.Errorf("got runs %v, want %v", got, test.want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case test.wantJump == 0 && len(jumps) > 0:
+				t.Errorf("got jumps %v, want none", jumps)
+			case test.wantJump != 0 && (len(jumps) != 1 || jumps[0] != test.wantJump):
+				t.Errorf("got jumps %v, want %v", jumps, test.wantJump)
+			}
+		})
+	}
+}